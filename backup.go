@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/alphagov/paas-cf-conduit/conduit"
+	"github.com/alphagov/paas-cf-conduit/logging"
+	"github.com/alphagov/paas-cf-conduit/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	BackupFile        string
+	BackupCompression string
+)
+
+var Backup = &cobra.Command{
+	Use: "backup [flags] SERVICE_INSTANCE --file FILE",
+	Example: `  Back up a postgres database with pg_dump's custom format:
+  cf conduit backup postgres-instance --file backup.dump
+
+  Back up a mysql database, compressed with zstd:
+  cf conduit backup mysql-instance --file backup.sql.zst
+  `,
+	Short: "backs up a service instance to a file",
+	Long:  "streams a backup of a service instance to a file using the dump tool for its service type, and writes a metadata file alongside it with a checksum of the backup.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instanceName := args[0]
+
+		if BackupFile == "" {
+			return errors.New("--file is required")
+		}
+		compression := BackupCompression
+		if compression == "" {
+			compression = util.CompressionForPath(BackupFile)
+		}
+		if err := util.ValidCompression(compression); err != nil {
+			return err
+		}
+
+		status, done, err := newStatus()
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		app, err := newApp(status, []string{instanceName}, []string{})
+		if err != nil {
+			return err
+		}
+		app.UseProviderClients()
+
+		defer func() {
+			if err := app.Teardown(); err != nil {
+				logging.Error(err)
+			}
+		}()
+
+		if err := openTunnels(app, status); err != nil {
+			return err
+		}
+
+		// write to a temporary file so a failed backup can't be mistaken
+		// for a good one
+		partialFile := BackupFile + ".partial"
+		f, err := os.OpenFile(partialFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create backup file: %s", err)
+		}
+		meta, err := app.Backup(instanceName, f, compression)
+		if err == nil {
+			err = f.Close()
+		} else {
+			f.Close()
+		}
+		if err == nil {
+			err = os.Rename(partialFile, BackupFile)
+		}
+		if err != nil {
+			os.Remove(partialFile)
+			return err
+		}
+
+		if err := conduit.WriteBackupMetadata(BackupFile, meta); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "\nBacked up %s to %s (%d bytes, sha256 %s)\n", instanceName, BackupFile, meta.Size, meta.SHA256)
+		return nil
+	},
+	SilenceUsage: true,
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/conduit"
+
+	gocfclient "github.com/cloudfoundry-community/go-cfclient"
+	"github.com/cloudfoundry/multierror"
+	"github.com/spf13/cobra"
+)
+
+var (
+	CleanupAllSpaces bool
+	CleanupOlderThan time.Duration
+	CleanupDryRun    bool
+)
+
+var Cleanup = &cobra.Command{
+	Use: "cleanup [flags]",
+	Example: `  List the conduit apps left behind in the targeted space without deleting them:
+  cf conduit cleanup --dry-run
+
+  Delete conduit apps over a day old in every space in the org:
+  cf conduit cleanup --all-spaces --older-than 24h
+  `,
+	Short: "deletes conduit apps which were left behind",
+	Long:  "finds the apps conduit created which weren't deleted, for example because conduit was killed, and unbinds their service instances and deletes them. The app shared by --shared is stopped and unbound instead, once every lease on it has expired. Apps are found by their generated names and by the label conduit gives them. The sessions of conduit processes on this machine which died before tearing down are also finished off.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if CleanupOlderThan < 0 {
+			return errors.New("--older-than can't be negative")
+		}
+
+		status, done, err := newStatus()
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		cfClient, err := newClient(status)
+		if err != nil {
+			return err
+		}
+
+		verb := "Deleted"
+		sharedVerb := "Stopped"
+		sessionVerb := "Tore down"
+		if CleanupDryRun {
+			verb = "Would delete"
+			sharedVerb = "Would stop"
+			sessionVerb = "Would tear down"
+		}
+		errs := &multierror.MultiError{}
+
+		// the sessions of conduit processes which died are torn down whatever
+		// their age, as they can't be in use
+		sessionDir, err := conduit.DefaultSessionDir()
+		if err != nil {
+			return err
+		}
+		stale := staleSessions(sessionDir)
+		for _, s := range stale {
+			if !CleanupDryRun {
+				if err := conduit.RecoverSession(cfClient, status, s); err != nil {
+					errs.Add(err)
+					continue
+				}
+			}
+			status.Done()
+			fmt.Fprintf(os.Stdout, "%s %s\n", sessionVerb, s.Describe())
+		}
+
+		status.Text("Targeting org", ConduitOrg)
+		org, err := cfClient.GetOrgByName(ConduitOrg)
+		if err != nil {
+			return err
+		}
+		var space *gocfclient.Space
+		if !CleanupAllSpaces {
+			status.Text("Targeting space", ConduitSpace)
+			space, err = cfClient.GetSpaceByName(org.Guid, ConduitSpace)
+			if err != nil {
+				return err
+			}
+		}
+
+		status.Text("Finding conduit apps")
+		apps, err := conduit.FindOrphanedApps(cfClient, org, space, CleanupOlderThan, time.Now())
+		if err != nil {
+			return err
+		}
+
+		for _, app := range apps {
+			if !CleanupDryRun {
+				if err := conduit.RemoveOrphanedApp(cfClient, status, app); err != nil {
+					errs.Add(err)
+					continue
+				}
+			}
+			status.Done()
+			appVerb := verb
+			if app.Shared {
+				appVerb = sharedVerb
+			}
+			fmt.Fprintf(os.Stdout, "%s %s in space %s, created %s ago%s%s\n",
+				appVerb, app.Name, app.SpaceName,
+				time.Since(app.CreatedAt).Round(time.Minute),
+				createdBy(app.Metadata.Annotations[conduit.OwnerAnnotation]),
+				boundTo(app.Bindings),
+			)
+		}
+		status.Done()
+
+		if len(apps) == 0 && len(stale) == 0 {
+			fmt.Fprintf(os.Stderr, "No conduit apps older than %s found\n", CleanupOlderThan)
+		}
+		if len(errs.Errors) > 0 {
+			return errs
+		}
+		return nil
+	},
+	SilenceUsage: true,
+}
+
+func createdBy(owner string) string {
+	if owner == "" {
+		return ""
+	}
+	return " by " + owner
+}
+
+func boundTo(bindings []string) string {
+	if len(bindings) == 0 {
+		return ""
+	}
+	return ", bound to " + strings.Join(bindings, ", ")
+}
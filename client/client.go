@@ -20,8 +20,12 @@ import (
 	"time"
 
 	gocfclient "github.com/cloudfoundry-community/go-cfclient"
+
+	"github.com/alphagov/paas-cf-conduit/logging"
 )
 
+var logger = logging.For("client")
+
 type Env struct {
 	SystemEnv *SystemEnv `json:"system_env_json"`
 }
@@ -34,6 +38,8 @@ type VcapService struct {
 	Name         string `json:"name"`
 	Credentials  Credentials `json:"credentials"`
 	InstanceName string `json:"instance_name"`
+	Label        string `json:"label,omitempty"`
+	Plan         string `json:"plan,omitempty"`
 }
 
 func NewClient(api string, token string, insecure bool, cipherSuites []uint16, minTLSVersion uint16) (Client, error) {
@@ -66,11 +72,13 @@ type client struct {
 func (c *client) init() (error) {
 	// Use the TLS config when creating an HTTP client
 	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: c.insecureSkipVerify,
-				MinVersion:         c.minTLSVersion,
-				CipherSuites:       c.cipherSuites,
+		Transport: &loggingTransport{
+			next: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: c.insecureSkipVerify,
+					MinVersion:         c.minTLSVersion,
+					CipherSuites:       c.cipherSuites,
+				},
 			},
 		},
 	}
@@ -122,6 +130,26 @@ func (c *client) GetAppEnv(appGuid string) (*Env, error) {
 	return &env, nil
 }
 
+// GetAppSSHEnabled returns whether SSH is allowed to the app, taking into
+// account the app, space and platform settings, and if not the reason why
+func (c *client) GetAppSSHEnabled(appGuid string) (bool, string, error) {
+	res := struct {
+		Enabled bool   `json:"enabled"`
+		Reason  string `json:"reason"`
+	}{}
+	req := c.goCFClient.NewRequest("GET", "/v3/apps/"+appGuid+"/ssh_enabled")
+	resp, err := c.goCFClient.DoRequest(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return false, "", err
+	}
+
+	return res.Enabled, res.Reason, nil
+}
+
 func (c *client) GetSpaceByName(orgGuid string, name string) (*gocfclient.Space, error) {
 	space, err := c.goCFClient.GetSpaceByName(name, orgGuid)
 
@@ -190,8 +218,11 @@ func (c *client) BindService(
 	appGuid string,
 	serviceInstanceGuid string,
 	parameters map[string]interface{},
-) (*Credentials, error) {
+) (creds *Credentials, bindingGuid string, err error) {
 	res := struct {
+		Metadata struct {
+			Guid string `json:"guid"`
+		} `json:"metadata"`
 		Entity struct {
 			Credentials Credentials `json:"credentials"`
 		} `json:"entity"`
@@ -204,26 +235,26 @@ func (c *client) BindService(
 	}
 	bodyJson, err := json.Marshal(body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	req := c.goCFClient.NewRequestWithBody("POST", "/v2/service_bindings", bytes.NewReader(bodyJson))
 	resp, err := c.goCFClient.DoRequest(req)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	respBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	err = json.Unmarshal(respBytes, &res)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return &res.Entity.Credentials, nil
+	return &res.Entity.Credentials, res.Metadata.Guid, nil
 
 }
 
@@ -265,10 +296,153 @@ func (c *client) CreateApp(name string, spaceGUID string) (guid string, err erro
 	return app.Guid, nil
 }
 
+// Metadata is the labels and annotations of a resource
+type Metadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// AppSummary is an app as listed by ListApps
+type AppSummary struct {
+	Guid      string
+	Name      string
+	SpaceGuid string
+	SpaceName string
+	State     string
+	CreatedAt time.Time
+	Metadata  Metadata
+}
+
+// UpdateAppMetadata adds labels and annotations to an app
+func (c *client) UpdateAppMetadata(appGuid string, metadata Metadata) error {
+	bodyJson, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return err
+	}
+
+	req := c.goCFClient.NewRequestWithBody("PATCH", "/v3/apps/"+appGuid, bytes.NewReader(bodyJson))
+	resp, err := c.goCFClient.DoRequest(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RemoveAppAnnotations deletes annotations from an app
+func (c *client) RemoveAppAnnotations(appGuid string, keys []string) error {
+	annotations := map[string]interface{}{}
+	for _, key := range keys {
+		annotations[key] = nil
+	}
+	bodyJson, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+
+	req := c.goCFClient.NewRequestWithBody("PATCH", "/v3/apps/"+appGuid, bytes.NewReader(bodyJson))
+	resp, err := c.goCFClient.DoRequest(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ListApps lists every app matching the v3 API query, e.g. space_guids
+func (c *client) ListApps(query url.Values) ([]AppSummary, error) {
+	type page struct {
+		Pagination struct {
+			Next *struct {
+				Href string `json:"href"`
+			} `json:"next"`
+		} `json:"pagination"`
+		Resources []struct {
+			Guid          string    `json:"guid"`
+			Name          string    `json:"name"`
+			State         string    `json:"state"`
+			CreatedAt     time.Time `json:"created_at"`
+			Metadata      Metadata  `json:"metadata"`
+			Relationships struct {
+				Space struct {
+					Data struct {
+						Guid string `json:"guid"`
+					} `json:"data"`
+				} `json:"space"`
+			} `json:"relationships"`
+		} `json:"resources"`
+		Included struct {
+			Spaces []struct {
+				Guid string `json:"guid"`
+				Name string `json:"name"`
+			} `json:"spaces"`
+		} `json:"included"`
+	}
+
+	params := url.Values{"include": {"space"}, "per_page": {"5000"}}
+	for k, v := range query {
+		params[k] = v
+	}
+	path := "/v3/apps?" + params.Encode()
+
+	apps := []AppSummary{}
+	for path != "" {
+		req := c.goCFClient.NewRequest("GET", path)
+		resp, err := c.goCFClient.DoRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		var res page
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		spaceNames := map[string]string{}
+		for _, space := range res.Included.Spaces {
+			spaceNames[space.Guid] = space.Name
+		}
+		for _, r := range res.Resources {
+			spaceGuid := r.Relationships.Space.Data.Guid
+			apps = append(apps, AppSummary{
+				Guid:      r.Guid,
+				Name:      r.Name,
+				SpaceGuid: spaceGuid,
+				SpaceName: spaceNames[spaceGuid],
+				State:     r.State,
+				CreatedAt: r.CreatedAt,
+				Metadata:  r.Metadata,
+			})
+		}
+
+		path = ""
+		if res.Pagination.Next != nil {
+			next, err := url.Parse(res.Pagination.Next.Href)
+			if err != nil {
+				return nil, err
+			}
+			path = next.RequestURI()
+		}
+	}
+
+	return apps, nil
+}
+
+func (c *client) DeleteServiceBinding(bindingGuid string) error {
+	return c.goCFClient.DeleteServiceBinding(bindingGuid)
+}
+
 func (c *client) StartApp(appGuid string) error {
 	return c.goCFClient.StartApp(appGuid)
 }
 
+func (c *client) StopApp(appGuid string) error {
+	return c.goCFClient.StopApp(appGuid)
+}
+
 func (c *client) PollForAppState(appGuid string, state string, maxRetries int) error {
 	tries := 0
 	for {
@@ -391,6 +565,27 @@ func (c *client) SSHCode() (string, error) {
 	return codes[0], nil
 }
 
+// loggingTransport logs every API request so that they can be correlated
+// with tunnel events
+type loggingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	log := logger.
+		With("method", req.Method).
+		With("path", req.URL.Path).
+		With("duration", time.Since(start).Round(time.Millisecond))
+	if err != nil {
+		log.Debug("api request failed:", err)
+		return resp, err
+	}
+	log.With("status", resp.StatusCode).Debug("api request")
+	return resp, nil
+}
+
 func createZeroByteFileInZip(zipWriter *zip.Writer, name string) error {
 	fileInZip, err := zipWriter.Create(name)
 	if err != nil {
@@ -3,6 +3,8 @@
 package client
 
 import (
+	"net/url"
+
 	gocfclient "github.com/cloudfoundry-community/go-cfclient"
 )
 
@@ -10,16 +12,27 @@ import (
 type Client interface {
 	RefreshAccessToken() error
 	GetAppEnv(appGuid string) (*Env, error)
+	// GetAppSSHEnabled returns whether SSH is allowed to the app, taking into
+	// account the app, space and platform settings, and if not the reason why
+	GetAppSSHEnabled(appGuid string) (bool, string, error)
 	GetSpaceByName(orgGuid string, name string) (*gocfclient.Space, error)
 	GetOrgByName(name string) (*gocfclient.Org, error)
 	GetAppByName(orgGuid, spaceGuid, appName string) (*gocfclient.App, error)
 	GetServiceBindings(filters ...string) (map[string]*gocfclient.ServiceBinding, error)
 	GetServiceInstances(filters ...string) (map[string]*gocfclient.ServiceInstance, error)
-	BindService(appGuid string, serviceInstanceGuid string, parameters map[string]interface{}) (*Credentials, error)
+	BindService(appGuid string, serviceInstanceGuid string, parameters map[string]interface{}) (creds *Credentials, bindingGuid string, err error)
 	UploadStaticAppBits(appGuid string) error
 	DestroyApp(appGuid string) error
 	CreateApp(name string, spaceGUID string) (guid string, err error)
+	// UpdateAppMetadata adds labels and annotations to an app
+	UpdateAppMetadata(appGuid string, metadata Metadata) error
+	// RemoveAppAnnotations deletes annotations from an app
+	RemoveAppAnnotations(appGuid string, keys []string) error
+	// ListApps lists every app matching the v3 API query, e.g. space_guids
+	ListApps(query url.Values) ([]AppSummary, error)
+	DeleteServiceBinding(bindingGuid string) error
 	StartApp(appGuid string) error
+	StopApp(appGuid string) error
 	PollForAppState(appGuid string, state string, maxRetries int) error
 	AppSSHEndpoint() string
 	AppSSHHostKeyFingerprint() string
@@ -2,6 +2,7 @@
 package clientfakes
 
 import (
+	"net/url"
 	"sync"
 
 	"github.com/alphagov/paas-cf-conduit/client"
@@ -29,7 +30,7 @@ type FakeClient struct {
 	appSSHHostKeyFingerprintReturnsOnCall map[int]struct {
 		result1 string
 	}
-	BindServiceStub        func(string, string, map[string]interface{}) (*client.Credentials, error)
+	BindServiceStub        func(string, string, map[string]interface{}) (*client.Credentials, string, error)
 	bindServiceMutex       sync.RWMutex
 	bindServiceArgsForCall []struct {
 		arg1 string
@@ -38,11 +39,13 @@ type FakeClient struct {
 	}
 	bindServiceReturns struct {
 		result1 *client.Credentials
-		result2 error
+		result2 string
+		result3 error
 	}
 	bindServiceReturnsOnCall map[int]struct {
 		result1 *client.Credentials
-		result2 error
+		result2 string
+		result3 error
 	}
 	CreateAppStub        func(string, string) (string, error)
 	createAppMutex       sync.RWMutex
@@ -58,6 +61,17 @@ type FakeClient struct {
 		result1 string
 		result2 error
 	}
+	DeleteServiceBindingStub        func(string) error
+	deleteServiceBindingMutex       sync.RWMutex
+	deleteServiceBindingArgsForCall []struct {
+		arg1 string
+	}
+	deleteServiceBindingReturns struct {
+		result1 error
+	}
+	deleteServiceBindingReturnsOnCall map[int]struct {
+		result1 error
+	}
 	DestroyAppStub        func(string) error
 	destroyAppMutex       sync.RWMutex
 	destroyAppArgsForCall []struct {
@@ -97,6 +111,21 @@ type FakeClient struct {
 		result1 *client.Env
 		result2 error
 	}
+	GetAppSSHEnabledStub        func(string) (bool, string, error)
+	getAppSSHEnabledMutex       sync.RWMutex
+	getAppSSHEnabledArgsForCall []struct {
+		arg1 string
+	}
+	getAppSSHEnabledReturns struct {
+		result1 bool
+		result2 string
+		result3 error
+	}
+	getAppSSHEnabledReturnsOnCall map[int]struct {
+		result1 bool
+		result2 string
+		result3 error
+	}
 	GetOrgByNameStub        func(string) (*cfclient.Org, error)
 	getOrgByNameMutex       sync.RWMutex
 	getOrgByNameArgsForCall []struct {
@@ -150,6 +179,19 @@ type FakeClient struct {
 		result1 *cfclient.Space
 		result2 error
 	}
+	ListAppsStub        func(url.Values) ([]client.AppSummary, error)
+	listAppsMutex       sync.RWMutex
+	listAppsArgsForCall []struct {
+		arg1 url.Values
+	}
+	listAppsReturns struct {
+		result1 []client.AppSummary
+		result2 error
+	}
+	listAppsReturnsOnCall map[int]struct {
+		result1 []client.AppSummary
+		result2 error
+	}
 	PollForAppStateStub        func(string, string, int) error
 	pollForAppStateMutex       sync.RWMutex
 	pollForAppStateArgsForCall []struct {
@@ -173,6 +215,18 @@ type FakeClient struct {
 	refreshAccessTokenReturnsOnCall map[int]struct {
 		result1 error
 	}
+	RemoveAppAnnotationsStub        func(string, []string) error
+	removeAppAnnotationsMutex       sync.RWMutex
+	removeAppAnnotationsArgsForCall []struct {
+		arg1 string
+		arg2 []string
+	}
+	removeAppAnnotationsReturns struct {
+		result1 error
+	}
+	removeAppAnnotationsReturnsOnCall map[int]struct {
+		result1 error
+	}
 	SSHCodeStub        func() (string, error)
 	sSHCodeMutex       sync.RWMutex
 	sSHCodeArgsForCall []struct {
@@ -196,6 +250,29 @@ type FakeClient struct {
 	startAppReturnsOnCall map[int]struct {
 		result1 error
 	}
+	StopAppStub        func(string) error
+	stopAppMutex       sync.RWMutex
+	stopAppArgsForCall []struct {
+		arg1 string
+	}
+	stopAppReturns struct {
+		result1 error
+	}
+	stopAppReturnsOnCall map[int]struct {
+		result1 error
+	}
+	UpdateAppMetadataStub        func(string, client.Metadata) error
+	updateAppMetadataMutex       sync.RWMutex
+	updateAppMetadataArgsForCall []struct {
+		arg1 string
+		arg2 client.Metadata
+	}
+	updateAppMetadataReturns struct {
+		result1 error
+	}
+	updateAppMetadataReturnsOnCall map[int]struct {
+		result1 error
+	}
 	UploadStaticAppBitsStub        func(string) error
 	uploadStaticAppBitsMutex       sync.RWMutex
 	uploadStaticAppBitsArgsForCall []struct {
@@ -317,7 +394,7 @@ func (fake *FakeClient) AppSSHHostKeyFingerprintReturnsOnCall(i int, result1 str
 	}{result1}
 }
 
-func (fake *FakeClient) BindService(arg1 string, arg2 string, arg3 map[string]interface{}) (*client.Credentials, error) {
+func (fake *FakeClient) BindService(arg1 string, arg2 string, arg3 map[string]interface{}) (*client.Credentials, string, error) {
 	fake.bindServiceMutex.Lock()
 	ret, specificReturn := fake.bindServiceReturnsOnCall[len(fake.bindServiceArgsForCall)]
 	fake.bindServiceArgsForCall = append(fake.bindServiceArgsForCall, struct {
@@ -333,9 +410,9 @@ func (fake *FakeClient) BindService(arg1 string, arg2 string, arg3 map[string]in
 		return stub(arg1, arg2, arg3)
 	}
 	if specificReturn {
-		return ret.result1, ret.result2
+		return ret.result1, ret.result2, ret.result3
 	}
-	return fakeReturns.result1, fakeReturns.result2
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
 }
 
 func (fake *FakeClient) BindServiceCallCount() int {
@@ -344,7 +421,7 @@ func (fake *FakeClient) BindServiceCallCount() int {
 	return len(fake.bindServiceArgsForCall)
 }
 
-func (fake *FakeClient) BindServiceCalls(stub func(string, string, map[string]interface{}) (*client.Credentials, error)) {
+func (fake *FakeClient) BindServiceCalls(stub func(string, string, map[string]interface{}) (*client.Credentials, string, error)) {
 	fake.bindServiceMutex.Lock()
 	defer fake.bindServiceMutex.Unlock()
 	fake.BindServiceStub = stub
@@ -357,30 +434,33 @@ func (fake *FakeClient) BindServiceArgsForCall(i int) (string, string, map[strin
 	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
-func (fake *FakeClient) BindServiceReturns(result1 *client.Credentials, result2 error) {
+func (fake *FakeClient) BindServiceReturns(result1 *client.Credentials, result2 string, result3 error) {
 	fake.bindServiceMutex.Lock()
 	defer fake.bindServiceMutex.Unlock()
 	fake.BindServiceStub = nil
 	fake.bindServiceReturns = struct {
 		result1 *client.Credentials
-		result2 error
-	}{result1, result2}
+		result2 string
+		result3 error
+	}{result1, result2, result3}
 }
 
-func (fake *FakeClient) BindServiceReturnsOnCall(i int, result1 *client.Credentials, result2 error) {
+func (fake *FakeClient) BindServiceReturnsOnCall(i int, result1 *client.Credentials, result2 string, result3 error) {
 	fake.bindServiceMutex.Lock()
 	defer fake.bindServiceMutex.Unlock()
 	fake.BindServiceStub = nil
 	if fake.bindServiceReturnsOnCall == nil {
 		fake.bindServiceReturnsOnCall = make(map[int]struct {
 			result1 *client.Credentials
-			result2 error
+			result2 string
+			result3 error
 		})
 	}
 	fake.bindServiceReturnsOnCall[i] = struct {
 		result1 *client.Credentials
-		result2 error
-	}{result1, result2}
+		result2 string
+		result3 error
+	}{result1, result2, result3}
 }
 
 func (fake *FakeClient) CreateApp(arg1 string, arg2 string) (string, error) {
@@ -448,6 +528,67 @@ func (fake *FakeClient) CreateAppReturnsOnCall(i int, result1 string, result2 er
 	}{result1, result2}
 }
 
+func (fake *FakeClient) DeleteServiceBinding(arg1 string) error {
+	fake.deleteServiceBindingMutex.Lock()
+	ret, specificReturn := fake.deleteServiceBindingReturnsOnCall[len(fake.deleteServiceBindingArgsForCall)]
+	fake.deleteServiceBindingArgsForCall = append(fake.deleteServiceBindingArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.DeleteServiceBindingStub
+	fakeReturns := fake.deleteServiceBindingReturns
+	fake.recordInvocation("DeleteServiceBinding", []interface{}{arg1})
+	fake.deleteServiceBindingMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeClient) DeleteServiceBindingCallCount() int {
+	fake.deleteServiceBindingMutex.RLock()
+	defer fake.deleteServiceBindingMutex.RUnlock()
+	return len(fake.deleteServiceBindingArgsForCall)
+}
+
+func (fake *FakeClient) DeleteServiceBindingCalls(stub func(string) error) {
+	fake.deleteServiceBindingMutex.Lock()
+	defer fake.deleteServiceBindingMutex.Unlock()
+	fake.DeleteServiceBindingStub = stub
+}
+
+func (fake *FakeClient) DeleteServiceBindingArgsForCall(i int) string {
+	fake.deleteServiceBindingMutex.RLock()
+	defer fake.deleteServiceBindingMutex.RUnlock()
+	argsForCall := fake.deleteServiceBindingArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeClient) DeleteServiceBindingReturns(result1 error) {
+	fake.deleteServiceBindingMutex.Lock()
+	defer fake.deleteServiceBindingMutex.Unlock()
+	fake.DeleteServiceBindingStub = nil
+	fake.deleteServiceBindingReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) DeleteServiceBindingReturnsOnCall(i int, result1 error) {
+	fake.deleteServiceBindingMutex.Lock()
+	defer fake.deleteServiceBindingMutex.Unlock()
+	fake.DeleteServiceBindingStub = nil
+	if fake.deleteServiceBindingReturnsOnCall == nil {
+		fake.deleteServiceBindingReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteServiceBindingReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeClient) DestroyApp(arg1 string) error {
 	fake.destroyAppMutex.Lock()
 	ret, specificReturn := fake.destroyAppReturnsOnCall[len(fake.destroyAppArgsForCall)]
@@ -639,6 +780,73 @@ func (fake *FakeClient) GetAppEnvReturnsOnCall(i int, result1 *client.Env, resul
 	}{result1, result2}
 }
 
+func (fake *FakeClient) GetAppSSHEnabled(arg1 string) (bool, string, error) {
+	fake.getAppSSHEnabledMutex.Lock()
+	ret, specificReturn := fake.getAppSSHEnabledReturnsOnCall[len(fake.getAppSSHEnabledArgsForCall)]
+	fake.getAppSSHEnabledArgsForCall = append(fake.getAppSSHEnabledArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.GetAppSSHEnabledStub
+	fakeReturns := fake.getAppSSHEnabledReturns
+	fake.recordInvocation("GetAppSSHEnabled", []interface{}{arg1})
+	fake.getAppSSHEnabledMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
+}
+
+func (fake *FakeClient) GetAppSSHEnabledCallCount() int {
+	fake.getAppSSHEnabledMutex.RLock()
+	defer fake.getAppSSHEnabledMutex.RUnlock()
+	return len(fake.getAppSSHEnabledArgsForCall)
+}
+
+func (fake *FakeClient) GetAppSSHEnabledCalls(stub func(string) (bool, string, error)) {
+	fake.getAppSSHEnabledMutex.Lock()
+	defer fake.getAppSSHEnabledMutex.Unlock()
+	fake.GetAppSSHEnabledStub = stub
+}
+
+func (fake *FakeClient) GetAppSSHEnabledArgsForCall(i int) string {
+	fake.getAppSSHEnabledMutex.RLock()
+	defer fake.getAppSSHEnabledMutex.RUnlock()
+	argsForCall := fake.getAppSSHEnabledArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeClient) GetAppSSHEnabledReturns(result1 bool, result2 string, result3 error) {
+	fake.getAppSSHEnabledMutex.Lock()
+	defer fake.getAppSSHEnabledMutex.Unlock()
+	fake.GetAppSSHEnabledStub = nil
+	fake.getAppSSHEnabledReturns = struct {
+		result1 bool
+		result2 string
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeClient) GetAppSSHEnabledReturnsOnCall(i int, result1 bool, result2 string, result3 error) {
+	fake.getAppSSHEnabledMutex.Lock()
+	defer fake.getAppSSHEnabledMutex.Unlock()
+	fake.GetAppSSHEnabledStub = nil
+	if fake.getAppSSHEnabledReturnsOnCall == nil {
+		fake.getAppSSHEnabledReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 string
+			result3 error
+		})
+	}
+	fake.getAppSSHEnabledReturnsOnCall[i] = struct {
+		result1 bool
+		result2 string
+		result3 error
+	}{result1, result2, result3}
+}
+
 func (fake *FakeClient) GetOrgByName(arg1 string) (*cfclient.Org, error) {
 	fake.getOrgByNameMutex.Lock()
 	ret, specificReturn := fake.getOrgByNameReturnsOnCall[len(fake.getOrgByNameArgsForCall)]
@@ -896,6 +1104,70 @@ func (fake *FakeClient) GetSpaceByNameReturnsOnCall(i int, result1 *cfclient.Spa
 	}{result1, result2}
 }
 
+func (fake *FakeClient) ListApps(arg1 url.Values) ([]client.AppSummary, error) {
+	fake.listAppsMutex.Lock()
+	ret, specificReturn := fake.listAppsReturnsOnCall[len(fake.listAppsArgsForCall)]
+	fake.listAppsArgsForCall = append(fake.listAppsArgsForCall, struct {
+		arg1 url.Values
+	}{arg1})
+	stub := fake.ListAppsStub
+	fakeReturns := fake.listAppsReturns
+	fake.recordInvocation("ListApps", []interface{}{arg1})
+	fake.listAppsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeClient) ListAppsCallCount() int {
+	fake.listAppsMutex.RLock()
+	defer fake.listAppsMutex.RUnlock()
+	return len(fake.listAppsArgsForCall)
+}
+
+func (fake *FakeClient) ListAppsCalls(stub func(url.Values) ([]client.AppSummary, error)) {
+	fake.listAppsMutex.Lock()
+	defer fake.listAppsMutex.Unlock()
+	fake.ListAppsStub = stub
+}
+
+func (fake *FakeClient) ListAppsArgsForCall(i int) url.Values {
+	fake.listAppsMutex.RLock()
+	defer fake.listAppsMutex.RUnlock()
+	argsForCall := fake.listAppsArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeClient) ListAppsReturns(result1 []client.AppSummary, result2 error) {
+	fake.listAppsMutex.Lock()
+	defer fake.listAppsMutex.Unlock()
+	fake.ListAppsStub = nil
+	fake.listAppsReturns = struct {
+		result1 []client.AppSummary
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) ListAppsReturnsOnCall(i int, result1 []client.AppSummary, result2 error) {
+	fake.listAppsMutex.Lock()
+	defer fake.listAppsMutex.Unlock()
+	fake.ListAppsStub = nil
+	if fake.listAppsReturnsOnCall == nil {
+		fake.listAppsReturnsOnCall = make(map[int]struct {
+			result1 []client.AppSummary
+			result2 error
+		})
+	}
+	fake.listAppsReturnsOnCall[i] = struct {
+		result1 []client.AppSummary
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) PollForAppState(arg1 string, arg2 string, arg3 int) error {
 	fake.pollForAppStateMutex.Lock()
 	ret, specificReturn := fake.pollForAppStateReturnsOnCall[len(fake.pollForAppStateArgsForCall)]
@@ -1012,6 +1284,73 @@ func (fake *FakeClient) RefreshAccessTokenReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeClient) RemoveAppAnnotations(arg1 string, arg2 []string) error {
+	var arg2Copy []string
+	if arg2 != nil {
+		arg2Copy = make([]string, len(arg2))
+		copy(arg2Copy, arg2)
+	}
+	fake.removeAppAnnotationsMutex.Lock()
+	ret, specificReturn := fake.removeAppAnnotationsReturnsOnCall[len(fake.removeAppAnnotationsArgsForCall)]
+	fake.removeAppAnnotationsArgsForCall = append(fake.removeAppAnnotationsArgsForCall, struct {
+		arg1 string
+		arg2 []string
+	}{arg1, arg2Copy})
+	stub := fake.RemoveAppAnnotationsStub
+	fakeReturns := fake.removeAppAnnotationsReturns
+	fake.recordInvocation("RemoveAppAnnotations", []interface{}{arg1, arg2Copy})
+	fake.removeAppAnnotationsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeClient) RemoveAppAnnotationsCallCount() int {
+	fake.removeAppAnnotationsMutex.RLock()
+	defer fake.removeAppAnnotationsMutex.RUnlock()
+	return len(fake.removeAppAnnotationsArgsForCall)
+}
+
+func (fake *FakeClient) RemoveAppAnnotationsCalls(stub func(string, []string) error) {
+	fake.removeAppAnnotationsMutex.Lock()
+	defer fake.removeAppAnnotationsMutex.Unlock()
+	fake.RemoveAppAnnotationsStub = stub
+}
+
+func (fake *FakeClient) RemoveAppAnnotationsArgsForCall(i int) (string, []string) {
+	fake.removeAppAnnotationsMutex.RLock()
+	defer fake.removeAppAnnotationsMutex.RUnlock()
+	argsForCall := fake.removeAppAnnotationsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeClient) RemoveAppAnnotationsReturns(result1 error) {
+	fake.removeAppAnnotationsMutex.Lock()
+	defer fake.removeAppAnnotationsMutex.Unlock()
+	fake.RemoveAppAnnotationsStub = nil
+	fake.removeAppAnnotationsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) RemoveAppAnnotationsReturnsOnCall(i int, result1 error) {
+	fake.removeAppAnnotationsMutex.Lock()
+	defer fake.removeAppAnnotationsMutex.Unlock()
+	fake.RemoveAppAnnotationsStub = nil
+	if fake.removeAppAnnotationsReturnsOnCall == nil {
+		fake.removeAppAnnotationsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.removeAppAnnotationsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeClient) SSHCode() (string, error) {
 	fake.sSHCodeMutex.Lock()
 	ret, specificReturn := fake.sSHCodeReturnsOnCall[len(fake.sSHCodeArgsForCall)]
@@ -1129,6 +1468,129 @@ func (fake *FakeClient) StartAppReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeClient) StopApp(arg1 string) error {
+	fake.stopAppMutex.Lock()
+	ret, specificReturn := fake.stopAppReturnsOnCall[len(fake.stopAppArgsForCall)]
+	fake.stopAppArgsForCall = append(fake.stopAppArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	stub := fake.StopAppStub
+	fakeReturns := fake.stopAppReturns
+	fake.recordInvocation("StopApp", []interface{}{arg1})
+	fake.stopAppMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeClient) StopAppCallCount() int {
+	fake.stopAppMutex.RLock()
+	defer fake.stopAppMutex.RUnlock()
+	return len(fake.stopAppArgsForCall)
+}
+
+func (fake *FakeClient) StopAppCalls(stub func(string) error) {
+	fake.stopAppMutex.Lock()
+	defer fake.stopAppMutex.Unlock()
+	fake.StopAppStub = stub
+}
+
+func (fake *FakeClient) StopAppArgsForCall(i int) string {
+	fake.stopAppMutex.RLock()
+	defer fake.stopAppMutex.RUnlock()
+	argsForCall := fake.stopAppArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeClient) StopAppReturns(result1 error) {
+	fake.stopAppMutex.Lock()
+	defer fake.stopAppMutex.Unlock()
+	fake.StopAppStub = nil
+	fake.stopAppReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) StopAppReturnsOnCall(i int, result1 error) {
+	fake.stopAppMutex.Lock()
+	defer fake.stopAppMutex.Unlock()
+	fake.StopAppStub = nil
+	if fake.stopAppReturnsOnCall == nil {
+		fake.stopAppReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.stopAppReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) UpdateAppMetadata(arg1 string, arg2 client.Metadata) error {
+	fake.updateAppMetadataMutex.Lock()
+	ret, specificReturn := fake.updateAppMetadataReturnsOnCall[len(fake.updateAppMetadataArgsForCall)]
+	fake.updateAppMetadataArgsForCall = append(fake.updateAppMetadataArgsForCall, struct {
+		arg1 string
+		arg2 client.Metadata
+	}{arg1, arg2})
+	stub := fake.UpdateAppMetadataStub
+	fakeReturns := fake.updateAppMetadataReturns
+	fake.recordInvocation("UpdateAppMetadata", []interface{}{arg1, arg2})
+	fake.updateAppMetadataMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeClient) UpdateAppMetadataCallCount() int {
+	fake.updateAppMetadataMutex.RLock()
+	defer fake.updateAppMetadataMutex.RUnlock()
+	return len(fake.updateAppMetadataArgsForCall)
+}
+
+func (fake *FakeClient) UpdateAppMetadataCalls(stub func(string, client.Metadata) error) {
+	fake.updateAppMetadataMutex.Lock()
+	defer fake.updateAppMetadataMutex.Unlock()
+	fake.UpdateAppMetadataStub = stub
+}
+
+func (fake *FakeClient) UpdateAppMetadataArgsForCall(i int) (string, client.Metadata) {
+	fake.updateAppMetadataMutex.RLock()
+	defer fake.updateAppMetadataMutex.RUnlock()
+	argsForCall := fake.updateAppMetadataArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeClient) UpdateAppMetadataReturns(result1 error) {
+	fake.updateAppMetadataMutex.Lock()
+	defer fake.updateAppMetadataMutex.Unlock()
+	fake.UpdateAppMetadataStub = nil
+	fake.updateAppMetadataReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeClient) UpdateAppMetadataReturnsOnCall(i int, result1 error) {
+	fake.updateAppMetadataMutex.Lock()
+	defer fake.updateAppMetadataMutex.Unlock()
+	fake.UpdateAppMetadataStub = nil
+	if fake.updateAppMetadataReturnsOnCall == nil {
+		fake.updateAppMetadataReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.updateAppMetadataReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeClient) UploadStaticAppBits(arg1 string) error {
 	fake.uploadStaticAppBitsMutex.Lock()
 	ret, specificReturn := fake.uploadStaticAppBitsReturnsOnCall[len(fake.uploadStaticAppBitsArgsForCall)]
@@ -1201,12 +1663,16 @@ func (fake *FakeClient) Invocations() map[string][][]interface{} {
 	defer fake.bindServiceMutex.RUnlock()
 	fake.createAppMutex.RLock()
 	defer fake.createAppMutex.RUnlock()
+	fake.deleteServiceBindingMutex.RLock()
+	defer fake.deleteServiceBindingMutex.RUnlock()
 	fake.destroyAppMutex.RLock()
 	defer fake.destroyAppMutex.RUnlock()
 	fake.getAppByNameMutex.RLock()
 	defer fake.getAppByNameMutex.RUnlock()
 	fake.getAppEnvMutex.RLock()
 	defer fake.getAppEnvMutex.RUnlock()
+	fake.getAppSSHEnabledMutex.RLock()
+	defer fake.getAppSSHEnabledMutex.RUnlock()
 	fake.getOrgByNameMutex.RLock()
 	defer fake.getOrgByNameMutex.RUnlock()
 	fake.getServiceBindingsMutex.RLock()
@@ -1215,14 +1681,22 @@ func (fake *FakeClient) Invocations() map[string][][]interface{} {
 	defer fake.getServiceInstancesMutex.RUnlock()
 	fake.getSpaceByNameMutex.RLock()
 	defer fake.getSpaceByNameMutex.RUnlock()
+	fake.listAppsMutex.RLock()
+	defer fake.listAppsMutex.RUnlock()
 	fake.pollForAppStateMutex.RLock()
 	defer fake.pollForAppStateMutex.RUnlock()
 	fake.refreshAccessTokenMutex.RLock()
 	defer fake.refreshAccessTokenMutex.RUnlock()
+	fake.removeAppAnnotationsMutex.RLock()
+	defer fake.removeAppAnnotationsMutex.RUnlock()
 	fake.sSHCodeMutex.RLock()
 	defer fake.sSHCodeMutex.RUnlock()
 	fake.startAppMutex.RLock()
 	defer fake.startAppMutex.RUnlock()
+	fake.stopAppMutex.RLock()
+	defer fake.stopAppMutex.RUnlock()
+	fake.updateAppMetadataMutex.RLock()
+	defer fake.updateAppMetadataMutex.RUnlock()
 	fake.uploadStaticAppBitsMutex.RLock()
 	defer fake.uploadStaticAppBitsMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
@@ -3,9 +3,12 @@ package client
 import (
 	"fmt"
 	"io"
+	"net"
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/alphagov/paas-cf-conduit/logging"
 )
 
 type Credentials map[string]interface{}
@@ -67,9 +70,39 @@ func (c *Credentials) IsTLSEnabled() bool {
 	return false
 }
 
+// pem returns the first of the keys' values which is PEM encoded
+func (c *Credentials) pem(keys ...string) string {
+	for _, key := range keys {
+		if value := c.get(key); strings.Contains(value, "-----BEGIN ") {
+			return value
+		}
+	}
+	return ""
+}
+
+// TLSCACertificate returns the PEM encoded CA certificates the service's
+// certificate is signed with, if the binding gives them
+func (c *Credentials) TLSCACertificate() string {
+	return c.pem("ca_certificate", "ca_cert", "cacert", "ca", "tls_ca", "sslrootcert")
+}
+
+// TLSClientCertificate returns the PEM encoded certificate to authenticate
+// to the service with, if the binding gives one
+func (c *Credentials) TLSClientCertificate() string {
+	return c.pem("client_certificate", "client_cert", "tls_cert", "sslcert")
+}
+
+// TLSClientKey returns the PEM encoded key of TLSClientCertificate. Every
+// key it's read from must be masked by logging.Redact, as VCAP_SERVICES is
+// logged in verbose mode.
+func (c *Credentials) TLSClientKey() string {
+	return c.pem("client_key", "private_key", "tls_key", "sslkey")
+}
+
 func (c *Credentials) SetAddress(host string, port int64) {
 	oldAddr := fmt.Sprintf("%s:%d", c.Host(), c.Port())
-	newAddr := fmt.Sprintf("%s:%d", host, port)
+	// IPv6 addresses are bracketed in URIs
+	newAddr := net.JoinHostPort(host, strconv.FormatInt(port, 10))
 	for k := range *c {
 		if stringVal, ok := (*c)[k].(string); ok {
 			(*c)[k] = strings.Replace(stringVal, oldAddr, newAddr, -1)
@@ -84,13 +117,31 @@ func (c *Credentials) SetAddress(host string, port int64) {
 	(*c)["port"] = fmt.Sprintf("%d", port)
 }
 
+// Redacted returns a copy of the credentials with secret fields and
+// passwords in URIs masked, unless logging.ShowSecrets is set
+func (c *Credentials) Redacted() Credentials {
+	redacted := Credentials{}
+	for k, v := range *c {
+		if stringVal, ok := v.(string); ok {
+			if logging.IsSecretKey(k) {
+				v = logging.Secret(stringVal)
+			} else {
+				v = logging.Redact(stringVal)
+			}
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
 func (c *Credentials) Fprint(writer io.Writer, indent string) {
+	redacted := c.Redacted()
 	var keys []string
-	for k := range *c {
+	for k := range redacted {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 	for _, k := range keys {
-		fmt.Fprintf(writer, "%s%s: %v\n", indent, k, (*c)[k])
+		fmt.Fprintf(writer, "%s%s: %v\n", indent, k, redacted[k])
 	}
 }
@@ -1,16 +1,14 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 
-	"github.com/alphagov/paas-cf-conduit/client"
 	"github.com/alphagov/paas-cf-conduit/conduit"
 	"github.com/alphagov/paas-cf-conduit/logging"
-	"github.com/alphagov/paas-cf-conduit/service"
-	"github.com/alphagov/paas-cf-conduit/util"
 
 	"github.com/spf13/cobra"
 )
@@ -34,10 +32,32 @@ var ConnectService = &cobra.Command{
 
   Import a mysql script:
   cf conduit mysql-instance -- mysql < backup.sql
+
+  Open a tunnel in the background and load its environment into your shell:
+  cf conduit --detach --export-env sh --export-env-file conduit.sh postgres-instance
+  . ./conduit.sh
+
+  Back up a service instance to a compressed file:
+  cf conduit backup postgres-instance --file backup.dump.gz
+
+  Write a docker-compose env_file while the tunnel is open:
+  cf conduit --export-env dotenv --export-env-file conduit.env postgres-instance
+
+  Connect using the options in a profile from ~/.cf/conduit.yml:
+  cf conduit --profile prod-reporting
+
+  Keep a tunnel open in the background, and stop it later:
+  cf conduit --detach postgres-instance
+  cf conduit stop ID
   `,
 	Short: "enables temporarily binding services to local running processes",
 	Long:  "spawns a temporary application, binds your desired service and creates an ssh tunnel from the application to your local machine enabling communication directly with the remote service.",
 	Args: func(cmd *cobra.Command, args []string) error {
+		if usingProfile() {
+			// the profile can give the service instances, so this is
+			// checked once it has been read
+			return nil
+		}
 		if cmd.ArgsLenAtDash() > -1 {
 			if cmd.ArgsLenAtDash() < 1 {
 				return errors.New("requires at least one SERVICE_INSTANCE argument to be specified")
@@ -59,60 +79,102 @@ var ConnectService = &cobra.Command{
 			runargs = []string{}
 		}
 
-		if ConduitNoDelete || ConduitReuse || ConduitExistingApp {
-			// propagate alias, force on if ConduitExistingApp
-			ConduitNoDelete = true
-			ConduitReuse = true
+		if profile != nil {
+			if len(serviceInstanceNames) == 0 {
+				serviceInstanceNames = profile.InstanceNames()
+			}
+			// the profile's command is run unless the tunnels are wanted
+			// for something else
+			if len(runargs) == 0 && ScriptFile == "" && ExportEnvFormat == "" && !Detach && daemonID == "" {
+				// already checked when the profile was read
+				runargs, _ = profile.CommandArgs()
+			}
+		}
+		if len(serviceInstanceNames) == 0 {
+			return errors.New("requires at least one SERVICE_INSTANCE argument to be specified")
 		}
 
-		if ConduitAppName == "" {
-			if ConduitExistingApp {
-				return errors.New("must specify --app-name of existing app to reuse")
+		var script [][]string
+		if ScriptFile != "" {
+			if len(runargs) > 0 {
+				return errors.New("--script cannot be used when running a command")
+			}
+			f, err := os.Open(ScriptFile)
+			if err != nil {
+				return fmt.Errorf("failed to open script: %s", err)
+			}
+			script, err = conduit.ParseScript(f)
+			f.Close()
+			if err != nil {
+				return err
 			}
+		}
+		if ContinueOnError && ScriptFile == "" {
+			return errors.New("--continue-on-error requires --script to be set")
+		}
+		runningCommands := len(runargs) > 0 || len(script) > 0
 
-			ConduitAppName = fmt.Sprintf("__conduit_%s__", GenerateRandomString(8))
+		if Shell && len(runargs) == 0 {
+			return errors.New("--shell requires a command to run")
+		}
+		if ClientType != "" && len(runargs) == 0 {
+			return errors.New("--client-type requires a command to run")
 		}
 
-		// create status writer
-		status := util.NewStatus(os.Stderr, NonInteractive)
-		defer status.Done()
+		if ExportEnvFormat != "" && runningCommands {
+			return errors.New("--export-env cannot be used when running a command")
+		}
+		if ExportEnvFile != "" && ExportEnvFormat == "" {
+			return errors.New("--export-env-file requires --export-env to be set")
+		}
+		if ExportEnvFormat != "" {
+			// fail early on an unknown format rather than after the tunnel is up
+			if err := conduit.WriteEnv(ioutil.Discard, ExportEnvFormat, nil); err != nil {
+				return err
+			}
+		}
 
-		tlsCipherSuites, err := util.CipherSuiteNamesToIDs(CipherSuites)
-		if err != nil {
-			return err
+		if OutputFile != "" && OutputFormat == "" {
+			return errors.New("--output-file requires --output to be set")
+		}
+		if OutputFormat != "" {
+			if err := conduit.ValidateOutputFormat(OutputFormat); err != nil {
+				return err
+			}
+			if OutputFile == "" && runningCommands {
+				return errors.New("--output must be used with --output-file when running a command")
+			}
+			if OutputFile == "" && ExportEnvFormat != "" && ExportEnvFile == "" {
+				return errors.New("--output and --export-env cannot both write to stdout")
+			}
 		}
 
-		versionID, err := util.TLSVersionToID(MinTLSVersion)
-		if err != nil {
-			return err
+		if Detach {
+			if err := validateDetach(runningCommands); err != nil {
+				return err
+			}
 		}
 
-		// create a client
-		status.Text("Connecting client")
-		cfClient, err := client.NewClient(ApiEndpoint, ApiToken, ApiInsecure, tlsCipherSuites, versionID)
+		status, done, err := newStatus()
 		if err != nil {
 			return err
 		}
+		defer done()
 
-		if util.PortIsInUse(int(ConduitLocalPort)) {
-			return fmt.Errorf("Port %d is already in use", ConduitLocalPort)
+		if Detach {
+			return startDaemon(status)
 		}
 
-		var bindParams map[string]interface{}
-		if err = json.Unmarshal([]byte(RawBindParameters), &bindParams); err != nil {
-			return fmt.Errorf("Could not parse bind parameters as JSON: %s", err)
+		app, err := newApp(status, serviceInstanceNames, runargs)
+		if err != nil {
+			return err
 		}
 
-		app := conduit.NewApp(
-			cfClient, status,
-			ConduitLocalPort, ConduitOrg, ConduitSpace, ConduitAppName, !ConduitNoDelete,
-			serviceInstanceNames, runargs, bindParams, ApiInsecure, tlsCipherSuites, versionID,
-		)
-
-		app.RegisterServiceProvider("mysql", &service.MySQL{})
-		app.RegisterServiceProvider("postgres", &service.Postgres{})
-		app.RegisterServiceProvider("redis", &service.Redis{})
-		app.RegisterServiceProvider("influxdb", &service.InfluxDB{})
+		app.SetScript(script)
+		app.SetShell(Shell)
+		if err := app.SetClientType(ClientType); err != nil {
+			return err
+		}
 
 		defer func() {
 			if err := app.Teardown(); err != nil {
@@ -120,32 +182,46 @@ var ConnectService = &cobra.Command{
 			}
 		}()
 
-		if err := app.Init(); err != nil {
+		if err := openTunnels(app, status); err != nil {
 			return err
 		}
 
-		if ConduitExistingApp {
-			if err := app.PrepareForExistingApp(); err != nil {
-				return err
+		if logging.Verbose || !runningCommands {
+			app.PrintConnectionInfo()
+		}
+
+		if OutputFormat != "" {
+			info := app.ConnectionInfo()
+			if OutputFile == "" {
+				// files are private to the user, but stdout tends to end up
+				// in terminal sessions and CI logs
+				info = conduit.RedactConnectionInfo(info)
 			}
-		} else {
-			if err := app.DeployApp(); err != nil {
+			if err := writeOutput(OutputFile, func(w io.Writer) error {
+				return conduit.WriteConnectionInfo(w, OutputFormat, info)
+			}); err != nil {
 				return err
 			}
 		}
 
-		if err := app.SetupTunnels(); err != nil {
-			return err
+		if len(runargs) > 0 {
+			return app.RunCommand()
 		}
 
-		status.Done()
+		if len(script) > 0 {
+			return app.RunScript(ContinueOnError)
+		}
 
-		if logging.Verbose || len(runargs) == 0 {
-			app.PrintConnectionInfo()
+		if ExportEnvFormat != "" {
+			if err := writeOutput(ExportEnvFile, func(w io.Writer) error {
+				return app.ExportEnv(w, ExportEnvFormat)
+			}); err != nil {
+				return err
+			}
 		}
 
-		if len(runargs) > 0 {
-			return app.RunCommand()
+		if daemonID != "" {
+			return serveDaemon(app)
 		}
 
 		fmt.Fprintln(os.Stderr, "\nPress Ctrl+C to shutdown.")
@@ -158,3 +234,31 @@ var ConnectService = &cobra.Command{
 	},
 	SilenceUsage: true,
 }
+
+// writeOutput calls write with stdout, or with the file at path if one is
+// given. Files are only readable by the current user as the output usually
+// contains credentials.
+func writeOutput(path string, write func(w io.Writer) error) error {
+	if path == "" {
+		return write(os.Stdout)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	// an existing file keeps its permissions when opened
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Written to %s\n", path)
+	return nil
+}
@@ -3,10 +3,13 @@ package conduit
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"sort"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/alphagov/paas-cf-conduit/client"
 	"github.com/alphagov/paas-cf-conduit/logging"
@@ -18,6 +21,8 @@ import (
 	gocfclient "github.com/cloudfoundry-community/go-cfclient"
 )
 
+var logger = logging.For("conduit")
+
 type AppExecution struct {
 	ExitCode int
 }
@@ -45,11 +50,37 @@ type App struct {
 	serviceProviders     map[string]ServiceProvider
 	runEnv               map[string]string
 	forwardAddrs         []ssh.ForwardAddrs
+	instances            []tunnelledInstance
 	tunnel               *ssh.Tunnel
 	tlsTunnels           []*tls.Tunnel
 	tlsInsecure          bool
 	tlsCipherSuites      []uint16
 	tlsMinVersion        uint16
+	tlsClientCreds       *tls.ClientCredentials
+	forceTLSTunnels      bool
+	gracePeriod          time.Duration
+	script               [][]string
+	shell                bool
+	clientType           string
+	providerClients      bool
+	exportEnv            bool
+	owner                AppOwner
+	session              *Session
+	interrupted          bool
+	localPorts           map[string]int64
+	ephemeralPorts       bool
+	bindAddress          string
+	credentialsHost      string
+	unixSockets          bool
+	tempDirs             []string
+	localTLS             bool
+	localCA              *tls.LocalCA
+	envTemplates         map[string]*template.Template
+	shared               bool
+	leaseID              string
+	leaseStop            chan struct{}
+	leaseDone            chan struct{}
+	lockGUID             string
 }
 
 type ServiceProvider interface {
@@ -92,6 +123,7 @@ func NewApp(
 		runArgs:              runArgs,
 		program:              program,
 		serviceProviders:     make(map[string]ServiceProvider),
+		gracePeriod:          DefaultGracePeriod,
 		runEnv:               make(map[string]string),
 		forwardAddrs:         make([]ssh.ForwardAddrs, 0),
 		bindParameters:       bindParameters,
@@ -113,12 +145,14 @@ func (a *App) Init() error {
 	if err != nil {
 		return err
 	}
+	a.status.Event("org_targeted", util.Fields{"org": a.org.Name, "org_guid": a.org.Guid})
 	// get space
 	a.status.Text("Targeting space", a.spaceName)
 	a.space, err = a.cfClient.GetSpaceByName(a.org.Guid, a.spaceName)
 	if err != nil {
 		return err
 	}
+	a.status.Event("space_targeted", util.Fields{"space": a.space.Name, "space_guid": a.space.Guid})
 	return nil
 }
 
@@ -127,6 +161,11 @@ func (a *App) DeployApp() error {
 		return err
 	}
 
+	// it's marked as in use until it's torn down
+	a.leaseStop = make(chan struct{})
+	a.leaseDone = make(chan struct{})
+	go a.renewLeases(a.leaseStop, a.leaseDone, a.markInUse)
+
 	if err := a.bindServices(); err != nil {
 		return err
 	}
@@ -142,6 +181,7 @@ func (a *App) PrepareForExistingApp() error {
 	}
 
 	a.appGUID = app.Guid
+	a.status.Event("app_found", util.Fields{"app": a.appName, "app_guid": a.appGUID})
 
 	// check it's actually bound to the requested services
 	a.status.Text("Fetching service infomation")
@@ -195,6 +235,15 @@ func (a *App) deployApp() error {
 	if err != nil {
 		return err
 	}
+	a.status.Event("app_created", util.Fields{"app": a.appName, "app_guid": a.appGUID})
+	a.journal(func(s *Session) {
+		s.AppGUID = a.appGUID
+	})
+
+	// the name alone finds it for cleanup, unless it was given with --app-name
+	if err := a.cfClient.UpdateAppMetadata(a.appGUID, a.appMetadata(time.Now())); err != nil {
+		logger.Warn("failed to label", a.appName, "as created by conduit:", err)
+	}
 
 	// upload bits if not staged
 	a.status.Text("Uploading", a.appName, "bits")
@@ -212,23 +261,24 @@ func (a *App) deployApp() error {
 	if err := a.cfClient.PollForAppState(a.appGUID, "STARTED", 15); err != nil {
 		return err
 	}
+	a.status.Event("app_started", util.Fields{"app": a.appName, "app_guid": a.appGUID})
 
 	return nil
 }
 
 func (a *App) destroyApp() error {
-	logging.Debug("destroying", a.appName, a.appGUID)
+	logger.Debug("destroying", a.appName, a.appGUID)
 	if err := a.cfClient.DestroyApp(a.appGUID); err != nil {
-		logging.Debug("failed to delete app", a.appName, "err:", err)
+		logger.Debug("failed to delete app", a.appName, "err:", err)
 
-		logging.Debug("refreshing auth token")
+		logger.Debug("refreshing auth token")
 		if err := a.cfClient.RefreshAccessToken(); err != nil {
-			logging.Debug("failed to refresh access token, err:", err)
+			logger.Debug("failed to refresh access token, err:", err)
 			return fmt.Errorf("failed to delete %s app, please delete it manually\n", a.appName)
 		}
 
 		if err := a.cfClient.DestroyApp(a.appGUID); err != nil {
-			logging.Debug("failed to delete app", a.appName, "err:", err)
+			logger.Debug("failed to delete app", a.appName, "err:", err)
 			return fmt.Errorf("failed to delete %s app, please delete it manually\n", a.appName)
 		}
 	}
@@ -254,14 +304,21 @@ func (a *App) bindServices() error {
 			}
 			// bind conduit app to service instance
 			a.status.Text("Binding", serviceInstance.Name)
-			logging.Debug("binding", serviceInstanceGUID, "to", a.appGUID)
-			creds, err := a.cfClient.BindService(a.appGUID, serviceInstanceGUID, a.bindParameters)
+			logger.Debug("binding", serviceInstanceGUID, "to", a.appGUID)
+			creds, bindingGUID, err := a.cfClient.BindService(a.appGUID, serviceInstanceGUID, a.bindParameters)
 			if err != nil {
 				return err
 			}
+			a.journal(func(s *Session) {
+				s.BindingGUIDs = append(s.BindingGUIDs, bindingGUID)
+			})
 			if creds.Host() == "" || creds.Port() == 0 {
 				return fmt.Errorf("%s service is missing host, hostname or port", name)
 			}
+			a.status.Event("binding_created", util.Fields{
+				"instance":              name,
+				"service_instance_guid": serviceInstanceGUID,
+			})
 			bound = true
 		}
 		if !bound {
@@ -271,6 +328,29 @@ func (a *App) bindServices() error {
 	return nil
 }
 
+// programs returns every program we're going to run whose service type is
+// worked out from its name, i.e. the command given after -- (unless it's run
+// through the shell or --client-type is given) or each program in the script
+func (a *App) programs() []string {
+	programs := []string{}
+	if a.program != "" && !a.shell && a.clientType == "" {
+		programs = append(programs, a.program)
+	}
+	for _, command := range a.script {
+		programs = append(programs, command[0])
+	}
+	return programs
+}
+
+func isKnownClient(program string, knownClients []string) bool {
+	for _, knownClient := range knownClients {
+		if knownClient == program {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *App) getAllValidServiceTypesForProgram(program string) []string {
 	validServiceTypes := []string{}
 	for serviceType, serviceProvider := range a.serviceProviders {
@@ -311,7 +391,7 @@ func (a *App) initServiceBindings() error {
 	for _, name := range a.serviceInstanceNames {
 		unsatisfiedServiceInstanceNames[name] = true
 	}
-	programServiceTypeSatisfied := false
+	initialisedServiceTypes := map[string]bool{}
 	for _, serviceName := range orderedVcapServicesKeys {
 		keptServiceInstances := []*client.VcapService{}
 		for _, si := range a.appEnv.SystemEnv.VcapServices[serviceName] {
@@ -332,35 +412,75 @@ func (a *App) initServiceBindings() error {
 				// this one is relevant to our interests
 				keptServiceInstances = append(keptServiceInstances, si)
 
+				localPort, err := a.localPort(si.InstanceName)
+				if err != nil {
+					return err
+				}
+				socketPath, err := a.socketPath(serviceName, localPort)
+				if err != nil {
+					return err
+				}
 				forwardAddr := ssh.ForwardAddrs{
-					RemoteAddr: fmt.Sprintf("%s:%d", si.Credentials.Host(), si.Credentials.Port()),
-					LocalPort:  a.nextPort,
+					RemoteAddr:  fmt.Sprintf("%s:%d", si.Credentials.Host(), si.Credentials.Port()),
+					LocalPort:   localPort,
+					BindAddress: a.bindAddress,
+					SocketPath:  socketPath,
 				}
-				logging.Debug("remote address for tunnel will be", forwardAddr.RemoteAddr)
-				a.nextPort++
+				logger.Debug("remote address for tunnel will be", forwardAddr.RemoteAddr)
 
-				createTLSTunnel := false
-				for _, nonTLSClient := range serviceProvider.GetNonTLSClients() {
-					if nonTLSClient == a.program {
+				createTLSTunnel := (a.providerClients || a.clientType == serviceName) && len(serviceProvider.GetNonTLSClients()) > 0
+				for _, program := range a.programs() {
+					if isKnownClient(program, serviceProvider.GetNonTLSClients()) {
 						createTLSTunnel = true
 						break
 					}
 				}
 
-				if serviceName == "redis" && serviceProvider.IsTLSEnabled(si.Credentials) && createTLSTunnel {
-					forwardAddr.TLSTunnelPort = a.nextPort
-					a.nextPort++
+				// TLS to the service can't be passed through if it's being
+				// terminated locally
+				localTLS := a.localTLSProtocol(serviceName)
+				if localTLS != "" || a.forceTLSTunnels {
+					createTLSTunnel = true
 				}
 
-				a.forwardAddrs = append(a.forwardAddrs, forwardAddr)
-
-				si.Credentials.SetAddress("127.0.0.1", forwardAddr.ConnectPort())
+				// postgres servers can be sent TLS by conduit even if the
+				// binding doesn't say they support it, as clients do
+				tlsTunnel := serviceName == "postgres" || serviceProvider.IsTLSEnabled(si.Credentials)
+				if createTLSTunnel && tlsTunnel && tlsProtocols[serviceName] != "" {
+					tlsTunnelPort, err := a.allocatePort()
+					if err != nil {
+						return err
+					}
+					forwardAddr.TLSTunnelPort = tlsTunnelPort
+				}
+				if localTLS != "" {
+					if err := a.serveLocalTLS(&forwardAddr, localTLS); err != nil {
+						return err
+					}
+				}
 
-				if !programServiceTypeSatisfied {
-					for _, knownClient := range serviceProvider.GetKnownClients() {
-						if knownClient == a.program {
-							serviceProvider.InitEnv(si.Credentials, a.runEnv)
-							programServiceTypeSatisfied = true
+				a.forwardAddrs = append(a.forwardAddrs, forwardAddr)
+				a.instances = append(a.instances, tunnelledInstance{
+					serviceType: serviceName,
+					instance:    si,
+					forwardAddr: forwardAddr,
+					localTLS:    localTLS,
+				})
+
+				si.Credentials.SetAddress(a.connectHost(), forwardAddr.ConnectPort())
+
+				// set up the environment from the first instance of each
+				// service type used by a program we're going to run, or of
+				// every service type when it's exported for any program
+				if !initialisedServiceTypes[serviceName] && (a.exportEnv || a.clientType == serviceName) {
+					a.initEnv(serviceProvider, si.Credentials, a.runEnv)
+					initialisedServiceTypes[serviceName] = true
+				}
+				if !initialisedServiceTypes[serviceName] {
+					for _, program := range a.programs() {
+						if isKnownClient(program, serviceProvider.GetKnownClients()) {
+							a.initEnv(serviceProvider, si.Credentials, a.runEnv)
+							initialisedServiceTypes[serviceName] = true
 							break
 						}
 					}
@@ -388,30 +508,51 @@ func (a *App) initServiceBindings() error {
 			strings.Join(names, ", "),
 		)
 	}
-	if a.program != "" && !programServiceTypeSatisfied {
-		validServiceTypes := a.getAllValidServiceTypesForProgram(a.program)
+	if a.clientType != "" && !initialisedServiceTypes[a.clientType] {
+		return fmt.Errorf(
+			"Client type %s: none of the service instances are of this type",
+			a.clientType,
+		)
+	}
+	for _, program := range a.programs() {
+		programServiceTypeSatisfied := false
+		for serviceName := range initialisedServiceTypes {
+			if isKnownClient(program, a.serviceProviders[serviceName].GetKnownClients()) {
+				programServiceTypeSatisfied = true
+				break
+			}
+		}
+		if programServiceTypeSatisfied {
+			continue
+		}
+
+		validServiceTypes := a.getAllValidServiceTypesForProgram(program)
 		if len(validServiceTypes) == 0 {
 			return fmt.Errorf(
 				"Unknown program %s: can't determine what service types it expects",
-				a.program,
+				program,
  			) 
 		}
 
 		return fmt.Errorf(
 			"%s program expects one of the following service types: %s",
-			a.program,
+			program,
 			strings.Join(validServiceTypes, ", "),
 		)
 	}
 
-	logging.Debug("runenv", a.runEnv)
+	logger.Debug("runenv", logging.RedactEnv(a.runEnv))
 
 	// add modified VCAP_SERVICES to environment
 	if b, err := json.Marshal(a.appEnv.SystemEnv.VcapServices); err != nil {
 		return fmt.Errorf("failed to marshal VCAP_SERVICES: %s", err)
 	} else {
 		a.runEnv["VCAP_SERVICES"] = string(b)
-		logging.Debug("VCAP_SERVICES", string(b))
+		logger.Debug("VCAP_SERVICES", string(b))
+	}
+
+	if err := a.renderEnvTemplates(a.runEnv); err != nil {
+		return err
 	}
 
 	return nil
@@ -419,12 +560,16 @@ func (a *App) initServiceBindings() error {
 
 func (a *App) PrintConnectionInfo() {
 	fmt.Fprintf(os.Stderr, "\nThe following services are ready for you to connect to:\n\n")
-	for serviceType, serviceInstances := range a.appEnv.SystemEnv.VcapServices {
-		for _, si := range serviceInstances {
-			fmt.Fprintf(os.Stderr, "* service: %s (%s)\n", si.Name, serviceType)
-			si.Credentials.Fprint(os.Stderr, "  ")
-			fmt.Fprintln(os.Stderr)
+	for _, info := range a.ConnectionInfo() {
+		fmt.Fprintf(os.Stderr, "* service: %s (%s)\n", info.Name, info.Service)
+		if info.LocalSocket != "" {
+			fmt.Fprintf(os.Stderr, "  unix socket: %s\n", info.LocalSocket)
+		}
+		if info.LocalCAFile != "" {
+			fmt.Fprintf(os.Stderr, "  TLS CA: %s\n", info.LocalCAFile)
 		}
+		info.Credentials.Fprint(os.Stderr, "  ")
+		fmt.Fprintln(os.Stderr)
 	}
 }
 
@@ -450,7 +595,7 @@ func (a *App) startSSHTunnels() error {
 		TunnelAddr:    a.cfClient.AppSSHEndpoint(),
 		TunnelHostKey: a.cfClient.AppSSHHostKeyFingerprint(),
 		ForwardAddrs:  a.forwardAddrs,
-		PasswordFunc:  a.cfClient.SSHCode,
+		PasswordFunc:  a.sshCode,
 	}
 
 	// start the tunnel
@@ -463,10 +608,14 @@ func (a *App) startSSHTunnels() error {
 	a.status.Text("Waiting for port forwarding")
 	for _, fwd := range a.tunnel.ForwardAddrs {
 		select {
-		case err := <-util.WaitForConnection(fwd.LocalAddress()):
+		case err := <-util.WaitForConnection(fwd.LocalDialAddress()):
 			if err != nil {
 				return err
 			}
+			a.status.Event("tunnel_listening", util.Fields{
+				"local_address":  fwd.LocalAddress(),
+				"remote_address": fwd.RemoteAddr,
+			})
 		case err := <-a.tunnel.WaitChan():
 			if err != nil {
 				return err
@@ -477,112 +626,288 @@ func (a *App) startSSHTunnels() error {
 	return nil
 }
 
+// sshCode gets a one-time code for the ssh-proxy, refreshing the access
+// token if it has expired as it will have for a tunnel which has been open
+// for a long time
+func (a *App) sshCode() (string, error) {
+	code, err := a.cfClient.SSHCode()
+	if err == nil {
+		return code, nil
+	}
+	logger.Debug("failed to get ssh code, refreshing auth token:", err)
+	if err := a.cfClient.RefreshAccessToken(); err != nil {
+		logger.Debug("failed to refresh access token, err:", err)
+		return "", fmt.Errorf("failed to get ssh code: %s", err)
+	}
+	return a.cfClient.SSHCode()
+}
+
 func (a *App) startTLSTunnels() error {
 	// Start TLS proxies
-	for _, addr := range a.forwardAddrs {
+	for _, ti := range a.instances {
+		addr := ti.forwardAddr
 		if addr.TLSTunnelPort == 0 {
 			continue
 		}
 
-		tlsTunnel := tls.NewTunnel(addr.TLSTunnelAddress(), addr.LocalAddress(), addr.RemoteAddr, a.tlsInsecure, a.tlsCipherSuites, a.tlsMinVersion)
-		_, err := tlsTunnel.Start()
+		tlsTunnel := tls.NewTunnel(addr.TLSTunnelAddress(), addr.LocalDialAddress(), addr.RemoteAddr, a.tlsInsecure, a.tlsCipherSuites, a.tlsMinVersion)
+		tlsTunnel.SetSocketPath(addr.SocketPath)
+		creds, err := a.tlsClientCredentials(ti)
+		if err != nil {
+			return err
+		}
+		tlsTunnel.SetClientCredentials(creds)
+		tlsTunnel.SetProtocol(tlsProtocols[ti.serviceType])
+		if ti.localTLS != "" {
+			tlsTunnel.SetServerConfig(a.localCA.ServerConfig)
+		}
+		remoteAddr := addr.RemoteAddr
+		tlsTunnel.SetHandshakeFunc(func(stats tls.HandshakeStats) {
+			logger.Debug("TLS handshake with", remoteAddr, "for", stats.Client+":", stats.Version, stats.CipherSuite, stats.Duration, stats.Error)
+			fields := stats.Fields()
+			fields["remote_address"] = remoteAddr
+			a.status.Event("tls_handshake", fields)
+		})
+		errs, err := tlsTunnel.Start()
 		if err != nil {
 			return err
 		}
 		a.tlsTunnels = append(a.tlsTunnels, tlsTunnel)
+		go func() {
+			for err := range errs {
+				logger.Warn(err)
+			}
+		}()
 
-		err = <-util.WaitForConnection(addr.TLSTunnelAddress())
+		err = <-util.WaitForConnection(addr.TLSTunnelDialAddress())
 		if err != nil {
 			return err
 		}
+		a.status.Event("tls_tunnel_up", util.Fields{
+			"local_address":  addr.TLSTunnelAddress(),
+			"remote_address": addr.RemoteAddr,
+		})
 	}
 
 	return nil
 }
 
 func (a *App) RunCommand() error {
+	cmd := command{args: a.runArgs, fields: util.Fields{}}
+	switch {
+	case a.shell:
+		// the provider's arguments can't be spliced into a shell command
+		// line, so they're made available for the command to use instead
+		cmd.args = shellCommand(a.runArgs)
+		if a.clientType != "" {
+			cmd.env = map[string]string{
+				"CONDUIT_CLIENT_ARGS": util.JoinCommandLine(a.getServiceTypeArgs(a.clientType)),
+			}
+		}
+	case a.clientType != "" && a.isKnownClient(a.clientType, a.program):
+		cmd.extraArgs = a.getServiceTypeArgs(a.clientType)
+	case a.clientType != "":
+		// other programs wouldn't understand the client's arguments
+		cmd.env = map[string]string{
+			"CONDUIT_CLIENT_ARGS": util.JoinCommandLine(a.getServiceTypeArgs(a.clientType)),
+		}
+	default:
+		cmd.extraArgs = a.getProgramSpecificArgs(a.program)
+	}
+
+	exitCode, err := a.runCommand(cmd)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return AppExecution{ExitCode: exitCode}
+	}
+	return nil
+}
+
+type command struct {
+	args []string
+	// extraArgs are inserted after the program by its service provider
+	extraArgs []string
+	// env is added to the environment for the tunnelled services
+	env map[string]string
+	// fields are added to the command's events
+	fields util.Fields
+	// stdin and stdout default to our own
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+// runCommand runs a command with the environment for the tunnelled services
+// and returns its exit code
+func (a *App) runCommand(cmd command) (int, error) {
+	wait, err := a.launchCommand(cmd)
+	if err != nil {
+		return 0, err
+	}
+	return wait(), nil
+}
+
+// launchCommand starts a command with the environment for the tunnelled
+// services and returns a function which waits for it to exit and returns
+// its exit code
+func (a *App) launchCommand(cmd command) (func() int, error) {
 	// execute CMD with environment
-	a.status.Text("Preparing command:", strings.Join(a.runArgs, " "))
+	a.status.Text("Preparing command:", strings.Join(a.redactArgs(cmd.args), " "))
 
-	extraArgs := a.getProgramSpecificArgs(a.program)
-	runArgs := append([]string{a.program}, extraArgs...)
-	runArgs = append(runArgs, a.runArgs[1:]...)
+	program := cmd.args[0]
+	runArgs := append([]string{program}, cmd.extraArgs...)
+	runArgs = append(runArgs, cmd.args[1:]...)
 
-	exe, err := exec.LookPath(a.program)
+	exe, err := exec.LookPath(program)
 	if err != nil {
-		return fmt.Errorf("cannot find '%s' in PATH", a.program)
+		return nil, fmt.Errorf("cannot find '%s' in PATH", program)
 	}
 
-	logging.Debug("running command", exe, strings.Join(runArgs[1:], " "))
+	logger.Debug("running command", exe, strings.Join(a.redactArgs(runArgs[1:]), " "))
 
 	proc := exec.Command(exe, runArgs[1:]...)
 	proc.Env = os.Environ()
 	for k, v := range a.runEnv {
 		proc.Env = append(proc.Env, fmt.Sprintf("%s=%s", k, v))
 	}
+	for k, v := range cmd.env {
+		proc.Env = append(proc.Env, fmt.Sprintf("%s=%s", k, v))
+	}
 	proc.Stdout = os.Stdout
+	if cmd.stdout != nil {
+		proc.Stdout = cmd.stdout
+	}
 	proc.Stdin = os.Stdin
+	if cmd.stdin != nil {
+		proc.Stdin = cmd.stdin
+	}
 	proc.Stderr = os.Stderr
 
 	a.status.Done()
 
-	if err := proc.Start(); err != nil {
-		return fmt.Errorf("%s: %s", exe, err)
-	}
-
-	proc.Wait()
+	wait, err := a.startCommand(proc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", exe, err)
+	}
+	a.status.Event("command_started", withFields(cmd.fields, util.Fields{
+		"command": strings.Join(a.redactArgs(runArgs), " "),
+		"pid":     proc.Process.Pid,
+	}))
+
+	return func() int {
+		exitCode := 0
+		if state := wait(); state != nil {
+			exitCode = processExitCode(state)
+			a.status.Event("command_exited", withFields(cmd.fields, util.Fields{"exit_code": exitCode}))
+		}
+		return exitCode
+	}, nil
+}
 
-	if proc.ProcessState != nil {
-		exitCode := proc.ProcessState.ExitCode()
+func withFields(fields util.Fields, extra util.Fields) util.Fields {
+	merged := util.Fields{}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged
+}
 
-		if exitCode != 0 {
-			return AppExecution{ExitCode: exitCode}
+// redactArgs masks any argument which is the password of a tunnelled
+// service instance, as providers may pass these on the command line
+func (a *App) redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = arg
+		for _, ti := range a.instances {
+			if password := ti.instance.Credentials.Password(); password != "" && arg == password {
+				redacted[i] = logging.Secret(arg)
+			}
 		}
 	}
-
-	return nil
+	return redacted
 }
 
 func (a *App) getProgramSpecificArgs(program string) []string {
 	for serviceName, provider := range a.serviceProviders {
 		for _, knownProgram := range provider.GetKnownClients() {
 			if knownProgram == program {
-				serviceInstances := a.appEnv.SystemEnv.VcapServices[serviceName]
-				return provider.AdditionalProgramArgs(serviceInstances)
+				return a.getServiceTypeArgs(serviceName)
 			}
 		}
 	}
 	return nil
 }
 
+// isKnownClient returns whether program is one of the service type's own
+// clients
+func (a *App) isKnownClient(serviceType string, program string) bool {
+	for _, knownProgram := range a.serviceProviders[serviceType].GetKnownClients() {
+		if knownProgram == program {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) getServiceTypeArgs(serviceType string) []string {
+	serviceInstances := a.appEnv.SystemEnv.VcapServices[serviceType]
+	return a.serviceProviders[serviceType].AdditionalProgramArgs(serviceInstances)
+}
+
 func (a *App) Teardown() error {
 	errs := &multierror.MultiError{}
 
-	for _, tlsTunnel := range a.tlsTunnels {
-		if err := tlsTunnel.Stop(); err != nil {
+	step := func(name string, fields util.Fields, fn func() error) {
+		if fields == nil {
+			fields = util.Fields{}
+		}
+		fields["step"] = name
+		err := fn()
+		if err != nil {
 			errs.Add(err)
+			fields["error"] = err.Error()
 		}
+		fields["ok"] = err == nil
+		a.status.Event("teardown_step", fields)
+	}
+
+	for _, tlsTunnel := range a.tlsTunnels {
+		step("stop_tls_tunnel", nil, tlsTunnel.Stop)
 	}
 
 	if a.tunnel != nil {
-		if err := a.tunnel.Stop(); err != nil {
-			errs.Add(err)
-		}
+		step("stop_tunnel", nil, a.tunnel.Stop)
 	}
 
-	for _, sp := range a.serviceProviders {
-		if err := sp.Teardown(); err != nil {
-			errs.Add(err)
-		}
+	if len(a.tempDirs) > 0 {
+		step("remove_temp_dirs", nil, a.removeTempDirs)
+	}
+
+	for name, sp := range a.serviceProviders {
+		step("provider_teardown", util.Fields{"provider": name}, sp.Teardown)
 	}
 
+	if a.leaseStop != nil && a.shared {
+		step("release_shared_app", util.Fields{"app": a.appName, "app_guid": a.appGUID}, a.releaseSharedApp)
+	}
+	a.stopRenewing()
+
 	if a.deleteApp && a.appGUID != "" {
-		if err := a.destroyApp(); err != nil {
-			errs.Add(err)
-		}
+		step("delete_app", util.Fields{"app": a.appName, "app_guid": a.appGUID}, a.destroyApp)
 	}
 	if len(errs.Errors) > 0 {
+		if a.session != nil {
+			logger.Warn("conduit will offer to finish tearing down next time it runs, or run `cf conduit cleanup`")
+		}
 		return errs
 	}
+	// everything has been torn down, so the session isn't needed any more
+	if a.session != nil {
+		return a.session.Remove()
+	}
 	return nil
 }
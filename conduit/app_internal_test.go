@@ -2,6 +2,7 @@ package conduit
 
 import (
 	"fmt"
+	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -135,6 +136,122 @@ var _ = Describe("Conduit App (internal behaviour)", func() {
 					LocalPort: int64(9933),
 					RemoteAddr: "10.9.8.7:6543",
 				}))
+
+				info := app.ConnectionInfo()
+				Expect(info).To(HaveLen(1))
+				Expect(info[0].Name).To(Equal("some-binding"))
+				Expect(info[0].InstanceName).To(Equal("my-service-foo"))
+				Expect(info[0].Service).To(Equal("postgres"))
+				Expect(info[0].LocalHost).To(Equal("127.0.0.1"))
+				Expect(info[0].LocalPort).To(Equal(int64(9933)))
+				Expect(info[0].TLSTunnelPort).To(Equal(int64(0)))
+				Expect(info[0].Credentials.URI()).To(Equal("foo://127.0.0.1:9933/blah"))
+			})
+		})
+
+		When("the service instance has a fixed local port", func () {
+			BeforeEach(func () {
+				app.SetLocalPorts(map[string]int64{"my-service-foo": 15432})
+				Expect(app.SetEnvTemplates(map[string]string{
+					"DATABASE_URL": `{{ (instance "my-service-foo").Credentials.url }}`,
+				})).To(Succeed())
+			})
+
+			It("tunnels from it and renders the env templates", func () {
+				err := app.initServiceBindings()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(app.forwardAddrs[0].LocalPort).To(Equal(int64(15432)))
+				Expect(app.runEnv).To(HaveKeyWithValue("PGPORT", "15432"))
+				Expect(app.runEnv).To(HaveKeyWithValue("DATABASE_URL", "foo://127.0.0.1:15432/blah"))
+				Expect(app.NextPort()).To(Equal(int64(9933)))
+			})
+		})
+
+		When("the environment is exported rather than given to a command", func () {
+			BeforeEach(func () {
+				app.program = ""
+				app.SetExportEnv(true)
+			})
+
+			It("sets up the environment of every service type for any client", func () {
+				err := app.initServiceBindings()
+				Expect(err).ToNot(HaveOccurred())
+
+				var out strings.Builder
+				Expect(app.ExportEnv(&out, "dotenv")).To(Succeed())
+				Expect(out.String()).To(ContainSubstring("PGHOST='127.0.0.1'\n"))
+				Expect(out.String()).To(ContainSubstring("PGPORT='9933'\n"))
+				Expect(out.String()).To(ContainSubstring("PGPASSWORD='cheese-abc'\n"))
+				Expect(out.String()).To(ContainSubstring("VCAP_SERVICES="))
+			})
+		})
+
+		When("there's no command and the environment isn't exported", func () {
+			BeforeEach(func () {
+				app.program = ""
+			})
+
+			It("only gives the command VCAP_SERVICES", func () {
+				err := app.initServiceBindings()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(app.runEnv).NotTo(HaveKey("PGHOST"))
+				Expect(app.runEnv).To(HaveKey("VCAP_SERVICES"))
+			})
+		})
+
+		When("a bind address and unix sockets are given", func () {
+			BeforeEach(func () {
+				app.SetBindAddress("0.0.0.0")
+				app.SetCredentialsHost("host.docker.internal")
+				app.SetUnixSockets(true)
+				DeferCleanup(app.removeTempDirs)
+			})
+
+			It("listens on them and points the credentials at the given host", func () {
+				err := app.initServiceBindings()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(app.forwardAddrs[0].BindAddress).To(Equal("0.0.0.0"))
+				Expect(app.forwardAddrs[0].SocketPath).To(HaveSuffix(fmt.Sprintf(".s.PGSQL.%d", app.forwardAddrs[0].LocalPort)))
+				Expect(app.runEnv).To(HaveKeyWithValue("PGHOST", "host.docker.internal"))
+				Expect(app.ConnectionInfo()[0].LocalSocket).To(Equal(app.forwardAddrs[0].SocketPath))
+				Expect(app.ConnectionInfo()[0].Credentials.URI()).To(Equal("foo://host.docker.internal:9933/blah"))
+			})
+		})
+
+		When("local TLS is asked for", func () {
+			BeforeEach(func () {
+				app.SetLocalTLS(true)
+				DeferCleanup(app.removeTempDirs)
+			})
+
+			It("serves TLS locally from a TLS tunnel and tells the program how to verify it", func () {
+				err := app.initServiceBindings()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(app.forwardAddrs[0].TLSTunnelPort).To(Equal(int64(9934)))
+				Expect(app.runEnv).To(HaveKeyWithValue("PGPORT", "9934"))
+				Expect(app.localCA.CAFile).To(BeAnExistingFile())
+				Expect(app.runEnv).To(HaveKeyWithValue("PGSSLROOTCERT", app.localCA.CAFile))
+				Expect(app.runEnv).To(HaveKeyWithValue("SSL_CERT_FILE", app.localCA.CAFile))
+				Expect(app.runEnv).To(HaveKeyWithValue("PGSSLMODE", "verify-full"))
+				Expect(app.ConnectionInfo()[0].LocalCAFile).To(Equal(app.localCA.CAFile))
+			})
+		})
+
+		When("TLS tunnels are asked for", func () {
+			BeforeEach(func () {
+				app.SetTLSTunnels(true)
+			})
+
+			It("starts TLS with postgres itself, so the program can connect without it", func () {
+				err := app.initServiceBindings()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(app.forwardAddrs[0].TLSTunnelPort).To(Equal(int64(9934)))
+				Expect(app.runEnv).To(HaveKeyWithValue("PGPORT", "9934"))
+				Expect(app.runEnv).NotTo(HaveKey("PGSSLMODE"))
 			})
 		})
 
@@ -392,6 +509,87 @@ var _ = Describe("Conduit App (internal behaviour)", func() {
 				})
 			})
 
+			When("a script runs both psql and mysql", func () {
+				BeforeEach(func () {
+					app.program = ""
+					app.SetScript([][]string{
+						{"psql", "-c", "select 1"},
+						{"mysqldump", "other-database-123"},
+					})
+				})
+
+				It("sets up the environment for every program", func () {
+					err := app.initServiceBindings()
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(app.runEnv).To(HaveKey("MYSQL_HOME"))
+					Expect(app.runEnv["MYSQL_HOME"] + "/my.cnf").To(BeARegularFile())
+
+					Expect(app.runEnv).To(HaveKeyWithValue("PGUSER", "some-user-xyz"))
+					Expect(app.runEnv).To(HaveKeyWithValue("PGHOST", "127.0.0.1"))
+					Expect(app.runEnv).To(HaveKeyWithValue("PGPORT", "9934"))
+				})
+			})
+
+			When("a script runs a program with no matching service", func () {
+				BeforeEach(func () {
+					app.program = ""
+					app.SetScript([][]string{
+						{"psql", "-c", "select 1"},
+						{"redis-cli"},
+					})
+				})
+
+				It("returns an error", func () {
+					err := app.initServiceBindings()
+					Expect(err).To(MatchError("redis-cli program expects one of the following service types: redis"))
+				})
+			})
+
+			When("the command is run through the shell with a client type", func () {
+				BeforeEach(func () {
+					app.program = "sh"
+					app.SetShell(true)
+					Expect(app.SetClientType("mysql")).To(Succeed())
+				})
+
+				It("sets up the environment for the client type", func () {
+					err := app.initServiceBindings()
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(app.runEnv).To(HaveKey("MYSQL_HOME"))
+					Expect(app.runEnv).ToNot(HaveKey("PGUSER"))
+				})
+			})
+
+			When("the command is run through the shell without a client type", func () {
+				BeforeEach(func () {
+					app.program = "sh"
+					app.SetShell(true)
+				})
+
+				It("only sets VCAP_SERVICES", func () {
+					err := app.initServiceBindings()
+					Expect(err).ToNot(HaveOccurred())
+
+					Expect(app.runEnv).To(HaveKey("VCAP_SERVICES"))
+					Expect(app.runEnv).ToNot(HaveKey("MYSQL_HOME"))
+					Expect(app.runEnv).ToNot(HaveKey("PGUSER"))
+				})
+			})
+
+			When("the client type doesn't match any service instance", func () {
+				BeforeEach(func () {
+					app.program = "redis-benchmark"
+					Expect(app.SetClientType("redis")).To(Succeed())
+				})
+
+				It("returns an error", func () {
+					err := app.initServiceBindings()
+					Expect(err).To(MatchError("Client type redis: none of the service instances are of this type"))
+				})
+			})
+
 			When("there is no supplied program", func () {
 				BeforeEach(func () {
 					app.program = ""
@@ -685,4 +883,39 @@ var _ = Describe("Conduit App (internal behaviour)", func() {
 			})
 		})
 	})
+
+	Describe("sshCode()", func() {
+		var (
+			app *App
+			fakeClient *clientfakes.FakeClient
+		)
+
+		BeforeEach(func() {
+			fakeClient = &clientfakes.FakeClient{}
+			app = &App{cfClient: fakeClient}
+		})
+
+		It("refreshes the access token if it has expired", func() {
+			fakeClient.SSHCodeReturnsOnCall(0, "", fmt.Errorf("invalid token"))
+			fakeClient.SSHCodeReturnsOnCall(1, "abc123", nil)
+
+			Expect(app.sshCode()).To(Equal("abc123"))
+			Expect(fakeClient.RefreshAccessTokenCallCount()).To(Equal(1))
+		})
+
+		It("doesn't refresh the access token if it hasn't expired", func() {
+			fakeClient.SSHCodeReturns("abc123", nil)
+
+			Expect(app.sshCode()).To(Equal("abc123"))
+			Expect(fakeClient.RefreshAccessTokenCallCount()).To(Equal(0))
+		})
+
+		It("fails if the access token can't be refreshed", func() {
+			fakeClient.SSHCodeReturns("", fmt.Errorf("invalid token"))
+			fakeClient.RefreshAccessTokenReturns(fmt.Errorf("not logged in"))
+
+			_, err := app.sshCode()
+			Expect(err).To(MatchError("failed to get ssh code: not logged in"))
+		})
+	})
 })
@@ -1,7 +1,11 @@
 package conduit_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -21,6 +25,7 @@ var _ = Describe("Conduit App", func() {
 		space *cfclient.Space
 		serviceInstances map[string]*cfclient.ServiceInstance
 		status *util.Status
+		events *bytes.Buffer
 		conduitApp *conduit.App
 
 		newAppArgCfClient *clientfakes.FakeClient
@@ -90,6 +95,8 @@ var _ = Describe("Conduit App", func() {
 		})
 
 		status = util.NewStatus(GinkgoWriter, true)
+		events = &bytes.Buffer{}
+		status.AddBackend(util.NewEventStatus(events))
 		DeferCleanup(func() {
 			status.Done()
 		})
@@ -164,12 +171,13 @@ var _ = Describe("Conduit App", func() {
 				"hostname": "123.123.210.210",
 				"port": "6543",
 			}
-			fakeClient.BindServiceReturns(bindingCredentials, nil)
+			fakeClient.BindServiceReturns(bindingCredentials, "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb", nil)
 		})
 
 		JustBeforeEach(func () {
 			By("calling DeployApp", func () {
 				deployAppErr = conduitApp.DeployApp()
+				DeferCleanup(conduitApp.Teardown)
 				if !deployAppAllowErr {
 					Expect(deployAppErr).ToNot(HaveOccurred())
 				}
@@ -185,6 +193,14 @@ var _ = Describe("Conduit App", func() {
 				Expect(arg1).To(Equal("baz-app"))
 				Expect(arg2).To(Equal(space.Guid))
 
+				Expect(fakeClient.UpdateAppMetadataCallCount()).To(Equal(1))
+				arg1, arg2 = fakeClient.UpdateAppMetadataArgsForCall(0)
+				Expect(arg1).To(Equal("aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"))
+				metadata := arg2.(client.Metadata)
+				Expect(metadata.Labels).To(Equal(map[string]string{conduit.ManagedLabel: "true"}))
+				Expect(metadata.Annotations).To(HaveKeyWithValue(conduit.InstancesAnnotation, "my-service-e"))
+				Expect(metadata.Annotations).To(HaveKey(conduit.CreatedAtAnnotation))
+
 				Expect(fakeClient.UploadStaticAppBitsCallCount()).To(Equal(1))
 				arg1 = fakeClient.UploadStaticAppBitsArgsForCall(0)
 				Expect(arg1).To(Equal("aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"))
@@ -211,7 +227,55 @@ var _ = Describe("Conduit App", func() {
 					"seven": "eight",
 				}))
 
-				Expect(len(fakeClient.Invocations())).To(Equal(8))
+				Expect(len(fakeClient.Invocations())).To(Equal(9))
+			})
+
+			It("emitted lifecycle events", func () {
+				names := []string{}
+				for _, line := range strings.Split(strings.TrimSpace(events.String()), "\n") {
+					event := map[string]interface{}{}
+					Expect(json.Unmarshal([]byte(line), &event)).To(Succeed())
+					if event["event"] != "status" {
+						names = append(names, event["event"].(string))
+					}
+				}
+				Expect(names).To(Equal([]string{
+					"org_targeted",
+					"space_targeted",
+					"app_created",
+					"app_started",
+					"binding_created",
+				}))
+			})
+		})
+
+		When("the tunnels are open for a while", func () {
+			BeforeEach(func () {
+				duration := conduit.LeaseDuration
+				conduit.LeaseDuration = 30 * time.Millisecond
+				DeferCleanup(func () {
+					conduit.LeaseDuration = duration
+				})
+			})
+
+			It("marks the app as in use until it's torn down", func () {
+				inUseUntil := func () []string {
+					times := []string{}
+					for i := 0; i < fakeClient.UpdateAppMetadataCallCount(); i++ {
+						_, metadata := fakeClient.UpdateAppMetadataArgsForCall(i)
+						if until, ok := metadata.Annotations[conduit.InUseUntilAnnotation]; ok {
+							times = append(times, until)
+						}
+					}
+					return times
+				}
+				Eventually(func() int {
+					return len(inUseUntil())
+				}).Should(BeNumerically(">", 1))
+
+				Expect(conduitApp.Teardown()).To(Succeed())
+				renewals := fakeClient.UpdateAppMetadataCallCount()
+				Consistently(fakeClient.UpdateAppMetadataCallCount, 50*time.Millisecond).Should(Equal(renewals))
 			})
 		})
 
@@ -284,7 +348,7 @@ var _ = Describe("Conduit App", func() {
 				arg1 = fakeClient.GetServiceInstancesArgsForCall(0)
 				Expect(arg1).To(Equal([]string{"space_guid:22222222-2222-2222-2222-222222222222"}))
 
-				Expect(len(fakeClient.Invocations())).To(Equal(7))
+				Expect(len(fakeClient.Invocations())).To(Equal(8))
 			})
 		})
 	})
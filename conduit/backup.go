@@ -0,0 +1,126 @@
+package conduit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+// BackupProvider is implemented by service providers whose instances can be
+// backed up
+type BackupProvider interface {
+	// BackupFormat names the format of the backups, e.g. pg_dump-custom
+	BackupFormat() string
+	// BackupCommand returns a command which writes a backup of the service
+	// instance with the given credentials to stdout, of only the given
+	// tables if there are any
+	BackupCommand(creds client.Credentials, tables []string) []string
+}
+
+// BackupMetadata describes a backup, and is written alongside it
+type BackupMetadata struct {
+	Instance    string    `json:"instance"`
+	Service     string    `json:"service"`
+	Label       string    `json:"label,omitempty"`
+	Plan        string    `json:"plan,omitempty"`
+	Format      string    `json:"format"`
+	Compression string    `json:"compression"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+	Tool        string    `json:"tool"`
+	ToolVersion string    `json:"tool_version"`
+}
+
+// MetadataPath returns the path of the metadata written alongside a backup
+func MetadataPath(path string) string {
+	return path + ".meta.json"
+}
+
+// WriteBackupMetadata writes the metadata for the backup at path
+func WriteBackupMetadata(path string, meta *BackupMetadata) error {
+	b, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(MetadataPath(path), append(b, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write backup metadata: %s", err)
+	}
+	return nil
+}
+
+// Backup streams a backup of the named service instance to w, compressed
+// with the given algorithm (see util.Compressions)
+func (a *App) Backup(instanceName string, w io.Writer, compression string) (*BackupMetadata, error) {
+	ti, err := a.instanceNamed(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := a.serviceProviders[ti.serviceType].(BackupProvider)
+	if !ok {
+		return nil, fmt.Errorf("backing up %s service instances is not supported", ti.serviceType)
+	}
+	env, err := a.instanceEnv(ti)
+	if err != nil {
+		return nil, err
+	}
+
+	args := provider.BackupCommand(ti.instance.Credentials, nil)
+	meta := &BackupMetadata{
+		Instance:    ti.instance.InstanceName,
+		Service:     ti.serviceType,
+		Label:       ti.instance.Label,
+		Plan:        ti.instance.Plan,
+		Format:      provider.BackupFormat(),
+		Compression: compression,
+		CreatedAt:   time.Now().UTC(),
+		Tool:        args[0],
+		ToolVersion: toolVersion(args[0]),
+	}
+
+	// the checksum and size are of the file as written, so it can be
+	// checked without decompressing it
+	hash := sha256.New()
+	counter := &util.CountingWriter{}
+	compressor, err := util.NewCompressor(io.MultiWriter(w, hash, counter), compression)
+	if err != nil {
+		return nil, err
+	}
+
+	a.status.Text("Backing up", instanceName, "with", args[0])
+	a.status.Event("backup_started", util.Fields{"instance": instanceName, "tool": args[0]})
+
+	exitCode, err := a.runCommand(command{
+		args:   args,
+		env:    env,
+		fields: util.Fields{"instance": instanceName},
+		stdout: compressor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("%s failed with exit code %d", strings.Join(a.redactArgs(args), " "), exitCode)
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %s", err)
+	}
+
+	meta.Size = counter.Count()
+	meta.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	a.status.Event("backup_finished", util.Fields{
+		"instance": instanceName,
+		"size":     meta.Size,
+		"sha256":   meta.SHA256,
+	})
+	return meta, nil
+}
@@ -0,0 +1,129 @@
+//go:build !windows
+
+package conduit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/service"
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+// fakeDumpProvider "backs up" by printing its environment and arguments
+type fakeDumpProvider struct {
+	service.Postgres
+	script string
+}
+
+func (f *fakeDumpProvider) BackupFormat() string {
+	return "fake"
+}
+
+func (f *fakeDumpProvider) BackupCommand(creds client.Credentials, tables []string) []string {
+	return []string{"/bin/sh", "-c", f.script, "fake-dump", creds.Database()}
+}
+
+var _ = Describe("Backup()", func() {
+	var (
+		app      *App
+		provider *fakeDumpProvider
+	)
+
+	BeforeEach(func() {
+		provider = &fakeDumpProvider{script: `printf '%s %s' "$PGHOST" "$1"`}
+		app = &App{
+			status:           util.NewStatus(GinkgoWriter, true),
+			runEnv:           map[string]string{},
+			serviceProviders: map[string]ServiceProvider{},
+			gracePeriod:      DefaultGracePeriod,
+			instances: []tunnelledInstance{{
+				serviceType: "postgres",
+				instance: &client.VcapService{
+					InstanceName: "my-db",
+					Label:        "postgres",
+					Plan:         "tiny",
+					Credentials: client.Credentials{
+						"host": "127.0.0.1",
+						"port": "7080",
+						"name": "my-database",
+					},
+				},
+			}},
+		}
+		app.RegisterServiceProvider("postgres", provider)
+		app.RegisterServiceProvider("influxdb", &service.InfluxDB{})
+	})
+
+	It("streams the backup with the instance's environment", func() {
+		buf := &bytes.Buffer{}
+		meta, err := app.Backup("my-db", buf, "none")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(buf.String()).To(Equal("127.0.0.1 my-database"))
+		sum := sha256.Sum256(buf.Bytes())
+		Expect(meta.SHA256).To(Equal(hex.EncodeToString(sum[:])))
+		Expect(meta.Size).To(Equal(int64(buf.Len())))
+		Expect(meta.Instance).To(Equal("my-db"))
+		Expect(meta.Service).To(Equal("postgres"))
+		Expect(meta.Label).To(Equal("postgres"))
+		Expect(meta.Plan).To(Equal("tiny"))
+		Expect(meta.Format).To(Equal("fake"))
+		Expect(meta.Compression).To(Equal("none"))
+		Expect(meta.Tool).To(Equal("/bin/sh"))
+	})
+
+	It("compresses the backup", func() {
+		buf := &bytes.Buffer{}
+		meta, err := app.Backup("my-db", buf, "gzip")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.Size).To(Equal(int64(buf.Len())))
+
+		r, err := util.NewDecompressor(buf, "gzip")
+		Expect(err).NotTo(HaveOccurred())
+		decompressed := &bytes.Buffer{}
+		_, err = decompressed.ReadFrom(r)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decompressed.String()).To(Equal("127.0.0.1 my-database"))
+	})
+
+	It("fails if the dump tool fails", func() {
+		provider.script = "exit 3"
+		_, err := app.Backup("my-db", &bytes.Buffer{}, "none")
+		Expect(err).To(MatchError(ContainSubstring("failed with exit code 3")))
+	})
+
+	It("fails for an instance which isn't tunnelled", func() {
+		_, err := app.Backup("other-db", &bytes.Buffer{}, "none")
+		Expect(err).To(MatchError("service instance other-db is not tunnelled"))
+	})
+
+	It("fails for a service type which can't be backed up", func() {
+		app.instances[0].serviceType = "influxdb"
+		_, err := app.Backup("my-db", &bytes.Buffer{}, "none")
+		Expect(err).To(MatchError("backing up influxdb service instances is not supported"))
+	})
+
+	It("writes the metadata alongside the backup", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "backup.dump")
+		meta, err := app.Backup("my-db", &bytes.Buffer{}, "none")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(WriteBackupMetadata(path, meta)).To(Succeed())
+
+		b, err := os.ReadFile(path + ".meta.json")
+		Expect(err).NotTo(HaveOccurred())
+		written := &BackupMetadata{}
+		Expect(json.Unmarshal(b, written)).To(Succeed())
+		Expect(written.SHA256).To(Equal(meta.SHA256))
+		Expect(written.CreatedAt.Equal(meta.CreatedAt)).To(BeTrue())
+	})
+})
@@ -0,0 +1,91 @@
+package conduit
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+// socketNames are the names of the Unix domain sockets for the service types
+// which can be connected to with one. Postgres clients look for
+// .s.PGSQL.PORT in the directory given as the host, so its socket is named
+// after the local port, which is the PGPORT commands are given.
+var socketNames = map[string]string{
+	"postgres": ".s.PGSQL.%d",
+	"redis":    "redis.sock",
+}
+
+// SetBindAddress sets the address the tunnels listen on, localhost if it's
+// empty
+func (a *App) SetBindAddress(addr string) {
+	a.bindAddress = addr
+}
+
+// SetCredentialsHost sets the host the credentials given to commands are
+// rewritten to point at, e.g. host.docker.internal for containers. By
+// default it's the bind address, or the loopback address if that's every
+// address.
+func (a *App) SetCredentialsHost(host string) {
+	a.credentialsHost = host
+}
+
+// SetUnixSockets makes the tunnels to service instances which can be
+// connected to with a Unix domain socket listen on one as well, in a
+// temporary directory
+func (a *App) SetUnixSockets(unixSockets bool) {
+	a.unixSockets = unixSockets
+}
+
+// IsLoopback returns whether addr only accepts connections from this machine
+func IsLoopback(addr string) bool {
+	if addr == "" || addr == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsLoopback()
+}
+
+// listenHost is the host the local ports are checked on before listening
+func (a *App) listenHost() string {
+	if a.bindAddress == "" || a.bindAddress == "localhost" {
+		return "127.0.0.1"
+	}
+	return a.bindAddress
+}
+
+// connectHost is the host the credentials point at
+func (a *App) connectHost() string {
+	if a.credentialsHost != "" {
+		return a.credentialsHost
+	}
+	ip := net.ParseIP(a.bindAddress)
+	switch {
+	case ip == nil:
+		return "127.0.0.1"
+	case ip.IsUnspecified() && ip.To4() != nil:
+		return "127.0.0.1"
+	case ip.IsUnspecified():
+		return "::1"
+	default:
+		return a.bindAddress
+	}
+}
+
+// socketPath creates a temporary directory for a service instance's Unix
+// domain socket, returning an empty path if the service type can't be
+// connected to with one
+func (a *App) socketPath(serviceType string, localPort int64) (string, error) {
+	name, ok := socketNames[serviceType]
+	if !a.unixSockets || !ok {
+		return "", nil
+	}
+	if strings.Contains(name, "%d") {
+		name = fmt.Sprintf(name, localPort)
+	}
+	dir, err := a.tempDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
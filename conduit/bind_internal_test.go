@@ -0,0 +1,76 @@
+package conduit
+
+import (
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bind address", func() {
+	It("knows which addresses only accept connections from this machine", func() {
+		Expect(IsLoopback("")).To(BeTrue())
+		Expect(IsLoopback("localhost")).To(BeTrue())
+		Expect(IsLoopback("127.0.0.1")).To(BeTrue())
+		Expect(IsLoopback("::1")).To(BeTrue())
+		Expect(IsLoopback("0.0.0.0")).To(BeFalse())
+		Expect(IsLoopback("192.168.1.10")).To(BeFalse())
+	})
+
+	DescribeTable("the host the credentials point at",
+		func(bindAddress, credentialsHost, expected string) {
+			app := &App{}
+			app.SetBindAddress(bindAddress)
+			app.SetCredentialsHost(credentialsHost)
+			Expect(app.connectHost()).To(Equal(expected))
+		},
+		Entry("by default", "", "", "127.0.0.1"),
+		Entry("bound to localhost", "localhost", "", "127.0.0.1"),
+		Entry("bound to an IPv6 address", "::1", "", "::1"),
+		Entry("bound to every IPv4 address", "0.0.0.0", "", "127.0.0.1"),
+		Entry("bound to every IPv6 address", "::", "", "::1"),
+		Entry("bound to a LAN address", "192.168.1.10", "", "192.168.1.10"),
+		Entry("given a host", "0.0.0.0", "host.docker.internal", "host.docker.internal"),
+	)
+
+	Describe("socketPath()", func() {
+		var app *App
+
+		BeforeEach(func() {
+			app = &App{}
+			app.SetUnixSockets(true)
+			DeferCleanup(func() {
+				Expect(app.removeTempDirs()).To(Succeed())
+				for _, dir := range app.tempDirs {
+					Expect(dir).NotTo(BeADirectory())
+				}
+			})
+		})
+
+		It("uses the name postgres clients look for on the local port", func() {
+			path, err := app.socketPath("postgres", 7081)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filepath.Base(path)).To(Equal(".s.PGSQL.7081"))
+			Expect(filepath.Dir(path)).To(BeADirectory())
+		})
+
+		It("gives each instance its own directory", func() {
+			first, err := app.socketPath("redis", 7082)
+			Expect(err).NotTo(HaveOccurred())
+			second, err := app.socketPath("redis", 7082)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(filepath.Dir(first)).NotTo(Equal(filepath.Dir(second)))
+		})
+
+		It("doesn't create sockets for other services", func() {
+			Expect(app.socketPath("mysql", 7083)).To(BeEmpty())
+			Expect(app.tempDirs).To(BeEmpty())
+		})
+
+		It("doesn't create sockets unless asked to", func() {
+			app.SetUnixSockets(false)
+			Expect(app.socketPath("postgres", 7081)).To(BeEmpty())
+			Expect(app.tempDirs).To(BeEmpty())
+		})
+	})
+})
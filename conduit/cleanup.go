@@ -0,0 +1,208 @@
+package conduit
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/util"
+
+	gocfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+// generatedAppName matches the names conduit gives the apps it creates
+var generatedAppName = regexp.MustCompile(`^__conduit_[A-Za-z0-9]+__$`)
+
+// IsConduitApp returns whether an app was created by conduit
+func IsConduitApp(app client.AppSummary) bool {
+	return app.Metadata.Labels[ManagedLabel] == "true" || generatedAppName.MatchString(app.Name)
+}
+
+// IsKeptApp returns whether an app was created by conduit with --no-delete,
+// so is meant to be left behind
+func IsKeptApp(app client.AppSummary) bool {
+	return app.Metadata.Labels[KeepLabel] == "true"
+}
+
+// OrphanedApp is a conduit app which may have been left behind, with the
+// names of the service instances bound to it. The shared app is only
+// orphaned when every lease on it has expired, and is stopped rather than
+// deleted.
+type OrphanedApp struct {
+	client.AppSummary
+	Bindings []string
+	Shared   bool
+
+	bindingGUIDs []string
+}
+
+// FindOrphanedApps finds the apps created by conduit in the space, or in
+// every space in the org if space is nil, which haven't been in use for
+// olderThan
+func FindOrphanedApps(
+	cfClient client.Client,
+	org *gocfclient.Org,
+	space *gocfclient.Space,
+	olderThan time.Duration,
+	now time.Time,
+) ([]OrphanedApp, error) {
+	query := url.Values{}
+	if space != nil {
+		query.Set("space_guids", space.Guid)
+	} else {
+		query.Set("organization_guids", org.Guid)
+	}
+	apps, err := cfClient.ListApps(query)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceNames := map[string]map[string]string{}
+	orphans := []OrphanedApp{}
+	for _, app := range apps {
+		if !IsConduitApp(app) || IsKeptApp(app) || now.Sub(lastUsed(app)) < olderThan {
+			continue
+		}
+		if app.Name == sharedLockAppName && !lockHolder(app).Expired(now) {
+			continue
+		}
+
+		if _, ok := instanceNames[app.SpaceGuid]; !ok {
+			instances, err := cfClient.GetServiceInstances(fmt.Sprintf("space_guid:%s", app.SpaceGuid))
+			if err != nil {
+				return nil, err
+			}
+			instanceNames[app.SpaceGuid] = map[string]string{}
+			for guid, instance := range instances {
+				instanceNames[app.SpaceGuid][guid] = instance.Name
+			}
+		}
+
+		bindings, err := cfClient.GetServiceBindings(fmt.Sprintf("app_guid:%s", app.Guid))
+		if err != nil {
+			return nil, err
+		}
+		orphan := OrphanedApp{AppSummary: app, Bindings: []string{}}
+		for instanceGUID, binding := range bindings {
+			name, ok := instanceNames[app.SpaceGuid][instanceGUID]
+			if !ok {
+				name = instanceGUID
+			}
+			orphan.Bindings = append(orphan.Bindings, name)
+			orphan.bindingGUIDs = append(orphan.bindingGUIDs, binding.Guid)
+		}
+		sort.Strings(orphan.Bindings)
+
+		if IsSharedApp(app) {
+			// left alone if it was stopped when it was last released
+			orphan.Shared = true
+			if app.State != "STARTED" && len(orphan.Bindings) == 0 && len(SharedLeases(app.Metadata)) == 0 {
+				continue
+			}
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].CreatedAt.Before(orphans[j].CreatedAt)
+	})
+	return orphans, nil
+}
+
+// lastUsed is when an app stops being in use, which is in the future while
+// it's in use: when it was last marked as in use until, or for the shared app
+// when the last lease on it expires
+func lastUsed(app client.AppSummary) time.Time {
+	last := InUseUntil(app)
+	if IsSharedApp(app) {
+		for _, lease := range SharedLeases(app.Metadata) {
+			if lease.ExpiresAt.After(last) {
+				last = lease.ExpiresAt
+			}
+		}
+	}
+	return last
+}
+
+// RemoveOrphanedApp unbinds the app's service instances and deletes it, or
+// if it's the shared app stops it and removes the expired leases on it
+func RemoveOrphanedApp(cfClient client.Client, status *util.Status, app OrphanedApp) error {
+	if app.Shared {
+		return stopOrphanedSharedApp(cfClient, status, app)
+	}
+	return unbindOrphanedApp(cfClient, status, app, func() error {
+		status.Text("Deleting", app.Name)
+		if err := cfClient.DestroyApp(app.Guid); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to delete %s: %s", app.Name, err)
+		}
+		status.Event("app_removed", util.Fields{
+			"app":      app.Name,
+			"app_guid": app.Guid,
+			"space":    app.SpaceName,
+			"bindings": app.Bindings,
+		})
+		return nil
+	})
+}
+
+// stopOrphanedSharedApp stops the shared app with it locked, unless someone
+// has taken a lease on it since it was found
+func stopOrphanedSharedApp(cfClient client.Client, status *util.Status, app OrphanedApp) error {
+	lockGUID, err := lockSharedSpace(cfClient, status, app.SpaceGuid, Lease{ID: "cleanup", Owner: "conduit cleanup"})
+	if err != nil {
+		return err
+	}
+	defer unlockSharedSpace(cfClient, lockGUID)
+
+	current, err := findApp(cfClient, app.SpaceGuid, app.Name)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return nil
+	}
+	if hasLiveLease(SharedLeases(current.Metadata), "", time.Now()) {
+		return fmt.Errorf("%s is in use again, so it was left running", app.Name)
+	}
+
+	return unbindOrphanedApp(cfClient, status, app, func() error {
+		leases := []string{}
+		for _, lease := range SharedLeases(current.Metadata) {
+			leases = append(leases, leaseAnnotationPrefix+lease.ID)
+		}
+		if len(leases) > 0 {
+			if err := cfClient.RemoveAppAnnotations(app.Guid, leases); err != nil {
+				return fmt.Errorf("failed to remove the expired leases on %s: %s", app.Name, err)
+			}
+		}
+		if current.State == "STARTED" {
+			status.Text("Stopping", app.Name)
+			if err := cfClient.StopApp(app.Guid); err != nil {
+				return fmt.Errorf("failed to stop %s: %s", app.Name, err)
+			}
+		}
+		status.Event("app_stopped", util.Fields{
+			"app":      app.Name,
+			"app_guid": app.Guid,
+			"space":    app.SpaceName,
+			"bindings": app.Bindings,
+		})
+		return nil
+	})
+}
+
+// unbindOrphanedApp unbinds the app's service instances and then, if they
+// were all unbound, removes it with remove
+func unbindOrphanedApp(cfClient client.Client, status *util.Status, app OrphanedApp, remove func() error) error {
+	for _, bindingGUID := range app.bindingGUIDs {
+		status.Text("Unbinding", app.Name)
+		// it may have been deleted by a cleanup running at the same time
+		if err := cfClient.DeleteServiceBinding(bindingGUID); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to unbind %s: %s", app.Name, err)
+		}
+	}
+	return remove()
+}
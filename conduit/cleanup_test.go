@@ -0,0 +1,243 @@
+package conduit_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/client/clientfakes"
+	"github.com/alphagov/paas-cf-conduit/conduit"
+	"github.com/alphagov/paas-cf-conduit/util"
+
+	cfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+var _ = Describe("Cleaning up conduit apps", func() {
+	var (
+		fakeClient *clientfakes.FakeClient
+		org        *cfclient.Org
+		space      *cfclient.Space
+		now        time.Time
+	)
+
+	BeforeEach(func() {
+		fakeClient = &clientfakes.FakeClient{}
+		org = &cfclient.Org{Name: "my-org", Guid: "org-guid"}
+		space = &cfclient.Space{Name: "my-space", Guid: "space-guid"}
+		now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		fakeClient.ListAppsReturns([]client.AppSummary{
+			{Guid: "app-1", Name: "__conduit_abcd1234__", SpaceGuid: "space-guid", SpaceName: "my-space", CreatedAt: now.Add(-3 * time.Hour)},
+			{Guid: "app-2", Name: "my-tunnel", SpaceGuid: "space-guid", SpaceName: "my-space", CreatedAt: now.Add(-5 * time.Hour), Metadata: client.Metadata{
+				Labels: map[string]string{conduit.ManagedLabel: "true"},
+			}},
+			{Guid: "app-3", Name: "__conduit_efgh5678__", SpaceGuid: "space-guid", SpaceName: "my-space", CreatedAt: now.Add(-time.Hour)},
+			{Guid: "app-4", Name: "web", SpaceGuid: "space-guid", SpaceName: "my-space", CreatedAt: now.Add(-48 * time.Hour)},
+			{Guid: "app-5", Name: "__conduit_shared__", SpaceGuid: "space-guid", SpaceName: "my-space", CreatedAt: now.Add(-72 * time.Hour), Metadata: client.Metadata{
+				Labels: map[string]string{conduit.ManagedLabel: "true", conduit.SharedLabel: "true"},
+			}},
+		}, nil)
+		fakeClient.GetServiceInstancesReturns(map[string]*cfclient.ServiceInstance{
+			"db-guid":    {Guid: "db-guid", Name: "db"},
+			"cache-guid": {Guid: "cache-guid", Name: "cache"},
+		}, nil)
+		fakeClient.GetServiceBindingsStub = func(filters ...string) (map[string]*cfclient.ServiceBinding, error) {
+			if filters[0] == "app_guid:app-1" {
+				return map[string]*cfclient.ServiceBinding{
+					"db-guid":    {Guid: "binding-1", ServiceInstanceGuid: "db-guid"},
+					"cache-guid": {Guid: "binding-2", ServiceInstanceGuid: "cache-guid"},
+				}, nil
+			}
+			return map[string]*cfclient.ServiceBinding{}, nil
+		}
+	})
+
+	It("finds the apps conduit created which are older than the cutoff, oldest first", func() {
+		apps, err := conduit.FindOrphanedApps(fakeClient, org, space, 2*time.Hour, now)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(apps).To(HaveLen(2))
+		Expect(apps[0].Name).To(Equal("my-tunnel"))
+		Expect(apps[0].Bindings).To(BeEmpty())
+		Expect(apps[1].Name).To(Equal("__conduit_abcd1234__"))
+		Expect(apps[1].Bindings).To(Equal([]string{"cache", "db"}))
+
+		Expect(fakeClient.ListAppsArgsForCall(0)).To(Equal(url.Values{"space_guids": {"space-guid"}}))
+		Expect(fakeClient.GetServiceInstancesCallCount()).To(Equal(1))
+	})
+
+	It("leaves alone the apps conduit was asked not to delete", func() {
+		fakeClient.ListAppsReturns([]client.AppSummary{
+			{Guid: "app-6", Name: "mytunnel", SpaceGuid: "space-guid", SpaceName: "my-space", CreatedAt: now.Add(-48 * time.Hour), Metadata: client.Metadata{
+				Labels: map[string]string{conduit.ManagedLabel: "true", conduit.KeepLabel: "true"},
+			}},
+			{Guid: "app-7", Name: "__conduit_ijkl9012__", SpaceGuid: "space-guid", SpaceName: "my-space", CreatedAt: now.Add(-48 * time.Hour), Metadata: client.Metadata{
+				Labels: map[string]string{conduit.ManagedLabel: "true", conduit.KeepLabel: "true"},
+			}},
+		}, nil)
+
+		Expect(conduit.FindOrphanedApps(fakeClient, org, space, 2*time.Hour, now)).To(BeEmpty())
+	})
+
+	It("judges whether an app is in use from when it was last marked as in use, not when it was created", func() {
+		inUse := func(guid string, until time.Time) client.AppSummary {
+			return client.AppSummary{Guid: guid, Name: "__conduit_" + guid + "__", SpaceGuid: "space-guid", SpaceName: "my-space", CreatedAt: now.Add(-8 * time.Hour), Metadata: client.Metadata{
+				Labels:      map[string]string{conduit.ManagedLabel: "true"},
+				Annotations: map[string]string{conduit.InUseUntilAnnotation: until.Format(time.RFC3339)},
+			}}
+		}
+		fakeClient.ListAppsReturns([]client.AppSummary{
+			inUse("open", now.Add(4*time.Minute)),
+			inUse("recent", now.Add(-time.Hour)),
+			inUse("abandoned", now.Add(-3*time.Hour)),
+		}, nil)
+
+		apps, err := conduit.FindOrphanedApps(fakeClient, org, space, 2*time.Hour, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(apps).To(HaveLen(1))
+		Expect(apps[0].Guid).To(Equal("abandoned"))
+
+		apps, err = conduit.FindOrphanedApps(fakeClient, org, space, 0, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(apps).To(HaveLen(2))
+	})
+
+	It("looks in every space in the org if no space is given", func() {
+		_, err := conduit.FindOrphanedApps(fakeClient, org, nil, 2*time.Hour, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeClient.ListAppsArgsForCall(0)).To(Equal(url.Values{"organization_guids": {"org-guid"}}))
+	})
+
+	It("unbinds and deletes an app", func() {
+		apps, err := conduit.FindOrphanedApps(fakeClient, org, space, 2*time.Hour, now)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(conduit.RemoveOrphanedApp(fakeClient, util.NewStatus(GinkgoWriter, true), apps[1])).To(Succeed())
+
+		Expect(fakeClient.DeleteServiceBindingCallCount()).To(Equal(2))
+		Expect([]string{
+			fakeClient.DeleteServiceBindingArgsForCall(0),
+			fakeClient.DeleteServiceBindingArgsForCall(1),
+		}).To(ConsistOf("binding-1", "binding-2"))
+		Expect(fakeClient.DestroyAppCallCount()).To(Equal(1))
+		Expect(fakeClient.DestroyAppArgsForCall(0)).To(Equal("app-1"))
+	})
+
+	It("doesn't delete an app which can't be unbound", func() {
+		apps, err := conduit.FindOrphanedApps(fakeClient, org, space, 2*time.Hour, now)
+		Expect(err).NotTo(HaveOccurred())
+		fakeClient.DeleteServiceBindingReturns(errors.New("broker unavailable"))
+
+		err = conduit.RemoveOrphanedApp(fakeClient, util.NewStatus(GinkgoWriter, true), apps[1])
+		Expect(err).To(MatchError("failed to unbind __conduit_abcd1234__: broker unavailable"))
+		Expect(fakeClient.DestroyAppCallCount()).To(Equal(0))
+	})
+
+	Describe("the shared app", func() {
+		var leases map[string]string
+
+		lease := func(id string, expiresAt time.Time) string {
+			b, err := json.Marshal(conduit.Lease{ID: id, ExpiresAt: expiresAt})
+			Expect(err).NotTo(HaveOccurred())
+			return string(b)
+		}
+
+		listShared := func(extra ...client.AppSummary) {
+			fakeClient.ListAppsReturns(append([]client.AppSummary{
+				{Guid: "app-5", Name: "__conduit_shared__", SpaceGuid: "space-guid", SpaceName: "my-space", State: "STARTED", CreatedAt: now.Add(-72 * time.Hour), Metadata: client.Metadata{
+					Labels:      map[string]string{conduit.ManagedLabel: "true", conduit.SharedLabel: "true"},
+					Annotations: leases,
+				}},
+			}, extra...), nil)
+		}
+
+		BeforeEach(func() {
+			leases = map[string]string{
+				"conduit.alphagov/lease-a": lease("a", now.Add(-4*time.Hour)),
+				"conduit.alphagov/lease-b": lease("b", now.Add(-3*time.Hour)),
+			}
+			fakeClient.GetServiceBindingsStub = func(filters ...string) (map[string]*cfclient.ServiceBinding, error) {
+				return map[string]*cfclient.ServiceBinding{
+					"db-guid": {Guid: "binding-5", ServiceInstanceGuid: "db-guid"},
+				}, nil
+			}
+			fakeClient.CreateAppReturns("lock-guid", nil)
+		})
+
+		It("is stopped and unbound rather than deleted once every lease has expired", func() {
+			listShared()
+			apps, err := conduit.FindOrphanedApps(fakeClient, org, space, 2*time.Hour, now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apps).To(HaveLen(1))
+			Expect(apps[0].Shared).To(BeTrue())
+			Expect(apps[0].Bindings).To(Equal([]string{"db"}))
+
+			Expect(conduit.RemoveOrphanedApp(fakeClient, util.NewStatus(GinkgoWriter, true), apps[0])).To(Succeed())
+
+			Expect(fakeClient.DeleteServiceBindingArgsForCall(0)).To(Equal("binding-5"))
+			Expect(fakeClient.StopAppCallCount()).To(Equal(1))
+			Expect(fakeClient.StopAppArgsForCall(0)).To(Equal("app-5"))
+			guid, keys := fakeClient.RemoveAppAnnotationsArgsForCall(0)
+			Expect(guid).To(Equal("app-5"))
+			Expect(keys).To(ConsistOf("conduit.alphagov/lease-a", "conduit.alphagov/lease-b"))
+
+			// only the lock is deleted, once it's been stopped
+			name, _ := fakeClient.CreateAppArgsForCall(0)
+			Expect(name).To(Equal("__conduit_shared_lock__"))
+			Expect(fakeClient.DestroyAppCallCount()).To(Equal(1))
+			Expect(fakeClient.DestroyAppArgsForCall(0)).To(Equal("lock-guid"))
+		})
+
+		It("is left alone until its last lease has expired for longer than the cutoff", func() {
+			leases["conduit.alphagov/lease-b"] = lease("b", now.Add(-time.Hour))
+			listShared()
+			Expect(conduit.FindOrphanedApps(fakeClient, org, space, 2*time.Hour, now)).To(BeEmpty())
+		})
+
+		It("is left alone while someone has a lease on it, whatever the cutoff", func() {
+			leases["conduit.alphagov/lease-b"] = lease("b", now.Add(time.Minute))
+			listShared()
+			Expect(conduit.FindOrphanedApps(fakeClient, org, space, 0, now)).To(BeEmpty())
+		})
+
+		It("is left alone if someone takes a lease on it before it's locked", func() {
+			listShared()
+			apps, err := conduit.FindOrphanedApps(fakeClient, org, space, 2*time.Hour, now)
+			Expect(err).NotTo(HaveOccurred())
+
+			leases["conduit.alphagov/lease-c"] = lease("c", time.Now().Add(time.Minute))
+			listShared()
+			err = conduit.RemoveOrphanedApp(fakeClient, util.NewStatus(GinkgoWriter, true), apps[0])
+			Expect(err).To(MatchError("__conduit_shared__ is in use again, so it was left running"))
+			Expect(fakeClient.DeleteServiceBindingCallCount()).To(Equal(0))
+			Expect(fakeClient.StopAppCallCount()).To(Equal(0))
+		})
+
+		It("is left alone once it's been stopped and unbound", func() {
+			leases = map[string]string{}
+			fakeClient.GetServiceBindingsReturns(map[string]*cfclient.ServiceBinding{}, nil)
+			fakeClient.GetServiceBindingsStub = nil
+			fakeClient.ListAppsReturns([]client.AppSummary{
+				{Guid: "app-5", Name: "__conduit_shared__", SpaceGuid: "space-guid", State: "STOPPED", CreatedAt: now.Add(-72 * time.Hour), Metadata: client.Metadata{
+					Labels: map[string]string{conduit.SharedLabel: "true"},
+				}},
+			}, nil)
+			Expect(conduit.FindOrphanedApps(fakeClient, org, space, 2*time.Hour, now)).To(BeEmpty())
+		})
+
+		It("doesn't delete the lock while it's held", func() {
+			listShared(client.AppSummary{Guid: "lock-guid", Name: "__conduit_shared_lock__", SpaceGuid: "space-guid", CreatedAt: now.Add(-time.Minute), Metadata: client.Metadata{
+				Labels: map[string]string{conduit.ManagedLabel: "true"},
+			}})
+			apps, err := conduit.FindOrphanedApps(fakeClient, org, space, 0, now)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(apps).To(HaveLen(1))
+			Expect(apps[0].Name).To(Equal("__conduit_shared__"))
+		})
+	})
+})
@@ -0,0 +1,107 @@
+package conduit
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultGracePeriod is how long a command has to exit after being asked
+// to terminate before it is killed
+const DefaultGracePeriod = 10 * time.Second
+
+// SetGracePeriod sets how long a command is given to exit after a
+// terminating signal is forwarded to it, before it is killed
+func (a *App) SetGracePeriod(gracePeriod time.Duration) {
+	a.gracePeriod = gracePeriod
+}
+
+// startCommand starts proc in its own process group and returns a function
+// which waits for it to exit, forwarding any signals we receive in the
+// meantime. This makes sure the command has exited before we tear anything
+// down.
+func (a *App) startCommand(proc *exec.Cmd) (func() *os.ProcessState, error) {
+	restoreTerminal := configureProcess(proc)
+
+	sigs := make(chan os.Signal, 4)
+	if len(forwardedSignals) > 0 {
+		signal.Notify(sigs, forwardedSignals...)
+	}
+
+	if err := proc.Start(); err != nil {
+		signal.Stop(sigs)
+		restoreTerminal()
+		return nil, err
+	}
+
+	wait := func() *os.ProcessState {
+		defer signal.Stop(sigs)
+		defer restoreTerminal()
+
+		exited := make(chan struct{})
+		go func() {
+			proc.Wait()
+			close(exited)
+		}()
+
+		var kill <-chan time.Time
+		for {
+			select {
+			case <-exited:
+				return proc.ProcessState
+			case sig := <-sigs:
+				logger.Debug("forwarding", sig, "to", proc.Process.Pid)
+				if err := signalProcess(proc.Process, sig); err != nil {
+					logger.Debug("failed to forward", sig, "err:", err)
+				}
+				if isTerminating(sig) && kill == nil {
+					a.interrupted = true
+					kill = time.After(a.gracePeriod)
+				}
+			case <-kill:
+				logger.Warn("command did not exit within", a.gracePeriod, "killing it")
+				if err := killProcess(proc.Process); err != nil {
+					logger.Debug("failed to kill command, err:", err)
+				}
+			}
+		}
+	}
+
+	return wait, nil
+}
+
+// SetShell runs the command given after -- through the user's shell, so it
+// can use pipes and redirection. The service type isn't worked out from the
+// program in this case, so it should be given with SetClientType.
+func (a *App) SetShell(shell bool) {
+	a.shell = shell
+}
+
+// SetClientType sets the service type whose environment and arguments are
+// given to the command, instead of working it out from the program's name.
+// The arguments are only added to the command line of the service type's
+// own clients, other programs get them in CONDUIT_CLIENT_ARGS.
+func (a *App) SetClientType(serviceType string) error {
+	if serviceType == "" {
+		a.clientType = ""
+		return nil
+	}
+	if _, ok := a.serviceProviders[serviceType]; !ok {
+		serviceTypes := []string{}
+		for name := range a.serviceProviders {
+			serviceTypes = append(serviceTypes, name)
+		}
+		sort.Strings(serviceTypes)
+		return fmt.Errorf(
+			"unknown client type %s, expected one of: %s",
+			serviceType,
+			strings.Join(serviceTypes, ", "),
+		)
+	}
+	a.clientType = serviceType
+	return nil
+}
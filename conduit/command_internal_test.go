@@ -0,0 +1,216 @@
+//go:build !windows
+
+package conduit
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/service"
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+var _ = Describe("startCommand()", func() {
+	var app *App
+
+	BeforeEach(func() {
+		app = &App{gracePeriod: DefaultGracePeriod}
+	})
+
+	start := func(script string) func() *os.ProcessState {
+		proc := exec.Command("/bin/sh", "-c", script)
+		wait, err := app.startCommand(proc)
+		Expect(err).NotTo(HaveOccurred())
+		// give the shell time to install its traps
+		time.Sleep(200 * time.Millisecond)
+		return wait
+	}
+
+	It("runs the command in its own process group", func() {
+		proc := exec.Command("/bin/sh", "-c", "exit 0")
+		wait, err := app.startCommand(proc)
+		Expect(err).NotTo(HaveOccurred())
+		pgid, _ := syscall.Getpgid(proc.Process.Pid)
+		state := wait()
+		Expect(pgid).To(Equal(proc.Process.Pid))
+		Expect(processExitCode(state)).To(Equal(0))
+	})
+
+	It("forwards signals to the command and waits for it to exit", func() {
+		wait := start(`trap "exit 3" HUP; while true; do sleep 0.1; done`)
+
+		Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(Succeed())
+
+		state := wait()
+		Expect(processExitCode(state)).To(Equal(3))
+	})
+
+	It("kills the command if it doesn't exit within the grace period", func() {
+		app.SetGracePeriod(200 * time.Millisecond)
+		wait := start(`trap "" HUP; while true; do sleep 0.1; done`)
+
+		Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(Succeed())
+
+		state := wait()
+		Expect(processExitCode(state)).To(Equal(128 + int(syscall.SIGKILL)))
+	})
+})
+
+var _ = Describe("RunScript()", func() {
+	var (
+		app    *App
+		status *util.Status
+	)
+
+	BeforeEach(func() {
+		status = util.NewStatus(GinkgoWriter, true)
+		app = &App{
+			status:      status,
+			runEnv:      map[string]string{},
+			gracePeriod: DefaultGracePeriod,
+		}
+	})
+
+	It("runs every command", func() {
+		app.SetScript([][]string{{"true"}, {"/bin/sh", "-c", "exit 0"}})
+		Expect(app.RunScript(false)).To(Succeed())
+	})
+
+	It("stops at the first failing command", func() {
+		dir := GinkgoT().TempDir()
+		app.SetScript([][]string{
+			{"/bin/sh", "-c", "exit 3"},
+			{"touch", dir + "/ran"},
+		})
+
+		Expect(app.RunScript(false)).To(MatchError(AppExecution{ExitCode: 3}))
+		Expect(dir + "/ran").NotTo(BeAnExistingFile())
+	})
+
+	It("carries on after a failing command if asked to", func() {
+		dir := GinkgoT().TempDir()
+		app.SetScript([][]string{
+			{"/bin/sh", "-c", "exit 3"},
+			{"/bin/sh", "-c", "exit 4"},
+			{"touch", dir + "/ran"},
+		})
+
+		Expect(app.RunScript(true)).To(MatchError(AppExecution{ExitCode: 3}))
+		Expect(dir + "/ran").To(BeAnExistingFile())
+	})
+
+	It("stops even if asked to carry on when a command exits as if interrupted", func() {
+		dir := GinkgoT().TempDir()
+		app.SetScript([][]string{
+			{"/bin/sh", "-c", "exit 130"},
+			{"touch", dir + "/ran"},
+		})
+
+		Expect(app.RunScript(true)).To(MatchError(AppExecution{ExitCode: 130}))
+		Expect(dir + "/ran").NotTo(BeAnExistingFile())
+	})
+
+	It("stops even if asked to carry on when a command is killed by a signal", func() {
+		dir := GinkgoT().TempDir()
+		app.SetScript([][]string{
+			{"/bin/sh", "-c", "kill -TERM $$"},
+			{"touch", dir + "/ran"},
+		})
+
+		Expect(app.RunScript(true)).To(MatchError(AppExecution{ExitCode: 143}))
+		Expect(dir + "/ran").NotTo(BeAnExistingFile())
+	})
+
+	It("fails if a program can't be found", func() {
+		app.SetScript([][]string{{"conduit-no-such-program"}})
+		Expect(app.RunScript(true)).To(MatchError("cannot find 'conduit-no-such-program' in PATH"))
+	})
+})
+
+var _ = Describe("RunCommand()", func() {
+	var app *App
+
+	BeforeEach(func() {
+		app = &App{
+			status:           util.NewStatus(GinkgoWriter, true),
+			runEnv:           map[string]string{},
+			serviceProviders: map[string]ServiceProvider{},
+			gracePeriod:      DefaultGracePeriod,
+			appEnv: &client.Env{
+				SystemEnv: &client.SystemEnv{
+					VcapServices: map[string][]*client.VcapService{
+						"redis": {{
+							InstanceName: "my-redis",
+							Credentials: client.Credentials{
+								"host":     "127.0.0.1",
+								"port":     "7081",
+								"password": "it's a secret",
+							},
+						}},
+					},
+				},
+			},
+		}
+		app.RegisterServiceProvider("redis", &service.Redis{})
+	})
+
+	It("runs the command through the shell", func() {
+		dir := GinkgoT().TempDir()
+		app.runArgs = []string{"echo hello > " + dir + "/out && exit 5"}
+		app.SetShell(true)
+
+		Expect(app.RunCommand()).To(MatchError(AppExecution{ExitCode: 5}))
+		Expect(os.ReadFile(dir + "/out")).To(Equal([]byte("hello\n")))
+	})
+
+	It("keeps the arguments given to the shell apart", func() {
+		dir := GinkgoT().TempDir()
+		app.runArgs = []string{"/bin/sh", "-c", `printf '%s\n' "$@" > ` + dir + "/out", "sh", "a b", "it's"}
+		app.SetShell(true)
+
+		Expect(app.RunCommand()).To(Succeed())
+		Expect(os.ReadFile(dir + "/out")).To(Equal([]byte("a b\nit's\n")))
+	})
+
+	It("gives the shell the client type's arguments", func() {
+		dir := GinkgoT().TempDir()
+		app.runArgs = []string{`eval "set -- $CONDUIT_CLIENT_ARGS" && printf '%s\n' "$@" > ` + dir + "/out"}
+		app.SetShell(true)
+		Expect(app.SetClientType("redis")).To(Succeed())
+
+		Expect(app.RunCommand()).To(Succeed())
+		Expect(os.ReadFile(dir + "/out")).To(Equal([]byte("-h\n127.0.0.1\n-p\n7081\n-a\nit's a secret\n")))
+	})
+
+	It("gives the client type's own clients its arguments", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(dir+"/redis-cli", []byte("#!/bin/sh\nprintf '%s\\n' \"$@\" > "+dir+"/out\n"), 0755)).To(Succeed())
+		GinkgoT().Setenv("PATH", dir+":"+os.Getenv("PATH"))
+		app.runArgs = []string{"redis-cli", "--scan"}
+		app.program = "redis-cli"
+		Expect(app.SetClientType("redis")).To(Succeed())
+
+		Expect(app.RunCommand()).To(Succeed())
+		Expect(os.ReadFile(dir + "/out")).To(Equal([]byte("-h\n127.0.0.1\n-p\n7081\n-a\nit's a secret\n--scan\n")))
+	})
+
+	It("doesn't give other programs the client type's arguments", func() {
+		dir := GinkgoT().TempDir()
+		app.runArgs = []string{"/bin/sh", "-c", `printf '%s\n' "$@" "$CONDUIT_CLIENT_ARGS" > ` + dir + "/out", "sh", "--scan"}
+		app.program = "/bin/sh"
+		Expect(app.SetClientType("redis")).To(Succeed())
+
+		Expect(app.RunCommand()).To(Succeed())
+		Expect(os.ReadFile(dir + "/out")).To(Equal([]byte("--scan\n-h 127.0.0.1 -p 7081 -a 'it'\\''s a secret'\n")))
+	})
+
+	It("fails on an unknown client type", func() {
+		Expect(app.SetClientType("mongodb")).To(MatchError("unknown client type mongodb, expected one of: redis"))
+	})
+})
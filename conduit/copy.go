@@ -0,0 +1,164 @@
+package conduit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+// copyProgressInterval is how often the progress of a copy is reported
+var copyProgressInterval = 5 * time.Second
+
+// NextPort returns the first local port which isn't used by the app's tunnels
+func (a *App) NextPort() int64 {
+	return a.nextPort
+}
+
+// Copy streams the data in the src service instance to the dst service
+// instance, of only the given tables if there are any. The instances can be
+// tunnelled by the same app, or by apps in different spaces. If dst isn't
+// empty, confirm must be its name.
+func Copy(srcApp *App, srcName string, dstApp *App, dstName string, tables []string, confirm string) error {
+	src, err := srcApp.instanceNamed(srcName)
+	if err != nil {
+		return err
+	}
+	dst, err := dstApp.instanceNamed(dstName)
+	if err != nil {
+		return err
+	}
+	if src.serviceType != dst.serviceType {
+		return fmt.Errorf(
+			"can't copy %s, which is a %s service instance, to %s, which is a %s service instance",
+			srcName, src.serviceType, dstName, dst.serviceType,
+		)
+	}
+
+	backupProvider, ok := srcApp.serviceProviders[src.serviceType].(BackupProvider)
+	if !ok {
+		return fmt.Errorf("copying %s service instances is not supported", src.serviceType)
+	}
+	restoreProvider, ok := dstApp.serviceProviders[dst.serviceType].(RestoreProvider)
+	if !ok {
+		return fmt.Errorf("copying %s service instances is not supported", dst.serviceType)
+	}
+
+	dumpArgs := backupProvider.BackupCommand(src.instance.Credentials, tables)
+	srcEnv, err := srcApp.instanceEnv(src)
+	if err != nil {
+		return err
+	}
+	dstEnv, err := dstApp.instanceEnv(dst)
+	if err != nil {
+		return err
+	}
+
+	dstApp.status.Text("Checking", dstName, "is empty")
+	count, err := dstApp.countObjects(dst, restoreProvider, dstEnv)
+	if err != nil {
+		return err
+	}
+	if count > 0 && confirm != dstName {
+		return fmt.Errorf(
+			"%s is not empty (it has %d tables), use --confirm %s to copy to it",
+			dstName, count, dstName,
+		)
+	}
+	restoreArgs, err := restoreProvider.RestoreCommand(dst.instance.Credentials, backupProvider.BackupFormat(), count > 0)
+	if err != nil {
+		return err
+	}
+
+	srcApp.status.Event("copy_started", util.Fields{"source": srcName, "destination": dstName})
+
+	pr, pw := io.Pipe()
+	counter := &util.CountingWriter{}
+
+	waitRestore, err := dstApp.launchCommand(command{
+		args:   restoreArgs,
+		env:    dstEnv,
+		fields: util.Fields{"instance": dstName},
+		stdin:  pr,
+	})
+	if err != nil {
+		return err
+	}
+	restoreExited := make(chan int, 1)
+	go func() {
+		exitCode := waitRestore()
+		// stop the dump if the restore gave up early
+		pr.CloseWithError(errors.New("restore has exited"))
+		restoreExited <- exitCode
+	}()
+
+	waitDump, err := srcApp.launchCommand(command{
+		args:   dumpArgs,
+		env:    srcEnv,
+		fields: util.Fields{"instance": srcName},
+		stdin:  strings.NewReader(""),
+		stdout: io.MultiWriter(pw, counter),
+	})
+	if err != nil {
+		pw.CloseWithError(err)
+		<-restoreExited
+		return err
+	}
+
+	stopProgress := reportCopyProgress(srcApp, counter)
+	dumpExitCode := waitDump()
+	pw.Close()
+	restoreExitCode := <-restoreExited
+	stopProgress()
+
+	if dumpExitCode != 0 {
+		return fmt.Errorf("%s failed with exit code %d", strings.Join(srcApp.redactArgs(dumpArgs), " "), dumpExitCode)
+	}
+	if restoreExitCode != 0 {
+		return fmt.Errorf("%s failed with exit code %d", strings.Join(dstApp.redactArgs(restoreArgs), " "), restoreExitCode)
+	}
+
+	srcApp.status.Event("copy_finished", util.Fields{
+		"source":      srcName,
+		"destination": dstName,
+		"bytes":       counter.Count(),
+	})
+	logger.Info(fmt.Sprintf("Copied %s from %s to %s", formatBytes(counter.Count()), srcName, dstName))
+	return nil
+}
+
+// reportCopyProgress logs how much has been copied every so often, until
+// the returned function is called
+func reportCopyProgress(a *App, counter *util.CountingWriter) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(copyProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				logger.Info(fmt.Sprintf("Copied %s so far", formatBytes(counter.Count())))
+				a.status.Event("copy_progress", util.Fields{"bytes": counter.Count()})
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,89 @@
+//go:build !windows
+
+package conduit
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+var _ = Describe("Copy()", func() {
+	var (
+		srcApp, dstApp *App
+		dumper         *fakeDumpProvider
+		restorer       *fakeRestoreProvider
+	)
+
+	newAppFor := func(instanceName string, database string) *App {
+		return &App{
+			status:           util.NewStatus(GinkgoWriter, true),
+			runEnv:           map[string]string{},
+			serviceProviders: map[string]ServiceProvider{},
+			gracePeriod:      DefaultGracePeriod,
+			instances: []tunnelledInstance{{
+				serviceType: "postgres",
+				instance: &client.VcapService{
+					InstanceName: instanceName,
+					Credentials: client.Credentials{
+						"host": "127.0.0.1",
+						"port": "7080",
+						"name": database,
+					},
+				},
+			}},
+		}
+	}
+
+	BeforeEach(func() {
+		dumper = &fakeDumpProvider{script: `printf 'data from %s' "$1"`}
+		restorer = &fakeRestoreProvider{
+			restoredTo: filepath.Join(GinkgoT().TempDir(), "restored"),
+			count:      "0",
+		}
+
+		srcApp = newAppFor("src-db", "src-database")
+		srcApp.RegisterServiceProvider("postgres", dumper)
+		dstApp = newAppFor("dst-db", "dst-database")
+		dstApp.RegisterServiceProvider("postgres", restorer)
+	})
+
+	It("streams the source into the destination", func() {
+		Expect(Copy(srcApp, "src-db", dstApp, "dst-db", nil, "")).To(Succeed())
+		Expect(os.ReadFile(restorer.restoredTo)).To(Equal([]byte("data from src-database")))
+	})
+
+	It("refuses to copy to a destination which isn't empty", func() {
+		restorer.count = "2"
+		err := Copy(srcApp, "src-db", dstApp, "dst-db", nil, "")
+		Expect(err).To(MatchError("dst-db is not empty (it has 2 tables), use --confirm dst-db to copy to it"))
+		Expect(restorer.restoredTo).NotTo(BeAnExistingFile())
+
+		Expect(Copy(srcApp, "src-db", dstApp, "dst-db", nil, "dst-db")).To(Succeed())
+	})
+
+	It("fails if the dump fails", func() {
+		dumper.script = `printf partial; exit 4`
+		err := Copy(srcApp, "src-db", dstApp, "dst-db", nil, "")
+		Expect(err).To(MatchError(ContainSubstring("failed with exit code 4")))
+	})
+
+	It("refuses to copy between different service types", func() {
+		dstApp.instances[0].serviceType = "mysql"
+		err := Copy(srcApp, "src-db", dstApp, "dst-db", nil, "")
+		Expect(err).To(MatchError("can't copy src-db, which is a postgres service instance, to dst-db, which is a mysql service instance"))
+	})
+})
+
+var _ = Describe("formatBytes()", func() {
+	It("formats sizes for people", func() {
+		Expect(formatBytes(12)).To(Equal("12 B"))
+		Expect(formatBytes(2048)).To(Equal("2.0 KiB"))
+		Expect(formatBytes(5 * 1024 * 1024 * 1024)).To(Equal("5.0 GiB"))
+	})
+})
@@ -0,0 +1,315 @@
+package conduit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	gotls "crypto/tls"
+
+	"github.com/alphagov/paas-cf-conduit/ssh"
+	"github.com/alphagov/paas-cf-conduit/tls"
+	"github.com/alphagov/paas-cf-conduit/util"
+	"github.com/fatih/color"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// CheckStatus is the outcome of one of the doctor's checks
+type CheckStatus string
+
+const (
+	CheckPassed  CheckStatus = "pass"
+	CheckFailed  CheckStatus = "fail"
+	CheckSkipped CheckStatus = "skip"
+)
+
+// CheckResult is the result of one of the doctor's checks, with a hint on
+// how to fix it if it failed
+type CheckResult struct {
+	Name   string      `json:"name"`
+	Status CheckStatus `json:"status"`
+	Detail string      `json:"detail,omitempty"`
+	Hint   string      `json:"hint,omitempty"`
+}
+
+// skipCheck is returned by a check which doesn't apply
+type skipCheck string
+
+func (s skipCheck) Error() string {
+	return string(s)
+}
+
+// Doctor runs a series of checks, each of which tests one step of setting up
+// a tunnel in isolation. Once a check that later checks depend on has
+// failed, the rest are skipped.
+type Doctor struct {
+	Results []CheckResult
+	status  *util.Status
+	blocked string
+}
+
+func NewDoctor(status *util.Status) *Doctor {
+	return &Doctor{status: status}
+}
+
+// Check runs a check which later checks don't depend on. fn returns a detail
+// to show if the check passes.
+func (d *Doctor) Check(name string, hint string, fn func() (string, error)) bool {
+	return d.run(name, hint, false, fn)
+}
+
+// Require runs a check which all later checks depend on
+func (d *Doctor) Require(name string, hint string, fn func() (string, error)) bool {
+	return d.run(name, hint, true, fn)
+}
+
+func (d *Doctor) run(name string, hint string, required bool, fn func() (string, error)) bool {
+	result := CheckResult{Name: name}
+	if d.blocked != "" {
+		result.Status = CheckSkipped
+		result.Detail = fmt.Sprintf("skipped as %s failed", strings.ToLower(d.blocked))
+		d.add(result)
+		return false
+	}
+
+	d.status.Text("Checking", strings.ToLower(name))
+	detail, err := fn()
+	var skip skipCheck
+	switch {
+	case errors.As(err, &skip):
+		result.Status = CheckSkipped
+		result.Detail = skip.Error()
+	case err != nil:
+		result.Status = CheckFailed
+		result.Detail = strings.TrimSpace(err.Error())
+		result.Hint = hint
+		if required {
+			d.blocked = name
+		}
+	default:
+		result.Status = CheckPassed
+		result.Detail = detail
+	}
+	d.add(result)
+	return result.Status == CheckPassed
+}
+
+func (d *Doctor) add(result CheckResult) {
+	d.Results = append(d.Results, result)
+	d.status.Event("check", util.Fields{
+		"name":   result.Name,
+		"status": string(result.Status),
+		"detail": result.Detail,
+	})
+}
+
+// Failed returns whether any of the checks failed
+func (d *Doctor) Failed() bool {
+	for _, result := range d.Results {
+		if result.Status == CheckFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the report of every check's result
+func (d *Doctor) Print(w io.Writer) {
+	fmt.Fprintf(w, "\nConduit doctor:\n\n")
+	for _, result := range d.Results {
+		var status string
+		switch result.Status {
+		case CheckPassed:
+			status = color.GreenString("PASS")
+		case CheckFailed:
+			status = color.RedString("FAIL")
+		default:
+			status = color.YellowString("SKIP")
+		}
+		line := fmt.Sprintf("%s  %s", status, result.Name)
+		if result.Detail != "" {
+			line += ": " + result.Detail
+		}
+		fmt.Fprintln(w, line)
+		if result.Hint != "" {
+			fmt.Fprintf(w, "      hint: %s\n", result.Hint)
+		}
+	}
+}
+
+// Diagnose checks each step of setting up a tunnel to the app's service
+// instance in turn. Only the checks which don't need an app are run if no
+// service instance is given. The app is left for Teardown to delete.
+func (a *App) Diagnose(d *Doctor) {
+	d.Check(
+		"SSH one-time code",
+		"UAA wouldn't give a one-time code for the ssh-proxy, check that `cf ssh-code` works",
+		func() (string, error) {
+			_, err := a.cfClient.SSHCode()
+			return "", err
+		},
+	)
+
+	d.Check(
+		"SSH host key",
+		"the ssh-proxy's host key doesn't match app_ssh_host_key_fingerprint from the API's /v2/info, something between you and the platform may be intercepting SSH connections",
+		func() (string, error) {
+			endpoint := a.cfClient.AppSSHEndpoint()
+			return endpoint, ssh.CheckHostKey(endpoint, a.cfClient.AppSSHHostKeyFingerprint())
+		},
+	)
+
+	d.Require(
+		"Org and space",
+		"check the org and space exist and that you're a member of them, with `cf target` or --org and --space",
+		func() (string, error) {
+			if err := a.Init(); err != nil {
+				return "", err
+			}
+			return a.org.Name + "/" + a.space.Name, nil
+		},
+	)
+
+	d.Check(
+		"Space allows SSH",
+		"a space manager can allow it with `cf allow-space-ssh`",
+		func() (string, error) {
+			if !a.space.AllowSSH {
+				return "", fmt.Errorf("SSH is disabled for space %s", a.space.Name)
+			}
+			return "", nil
+		},
+	)
+
+	if len(a.serviceInstanceNames) == 0 {
+		return
+	}
+
+	d.Require(
+		"Conduit app started",
+		"check the org's quota has room for a 64M app and that the staticfile_buildpack is available (`cf buildpacks`)",
+		func() (string, error) {
+			return a.appName, a.deployApp()
+		},
+	)
+
+	d.Check(
+		"App allows SSH",
+		"check `cf ssh-enabled` for the app, SSH may be disabled for the space or the whole platform",
+		func() (string, error) {
+			enabled, reason, err := a.cfClient.GetAppSSHEnabled(a.appGUID)
+			if err != nil {
+				return "", err
+			}
+			if !enabled {
+				return "", errors.New(reason)
+			}
+			return "", nil
+		},
+	)
+
+	d.Require(
+		"Service binding",
+		"check the service instance exists in this space and has finished being created (`cf services`)",
+		func() (string, error) {
+			if err := a.bindServices(); err != nil {
+				return "", err
+			}
+			if err := a.initServiceBindings(); err != nil {
+				return "", err
+			}
+			return a.instances[0].forwardAddr.RemoteAddr, nil
+		},
+	)
+
+	var sshClient *gossh.Client
+	d.Require(
+		"SSH handshake",
+		"check that your network doesn't block the ssh-proxy's port (usually 2222) and that the app is running (`cf app`)",
+		func() (string, error) {
+			password, err := a.cfClient.SSHCode()
+			if err != nil {
+				return "", err
+			}
+			endpoint := a.cfClient.AppSSHEndpoint()
+			sshClient, err = ssh.Dial(endpoint, a.cfClient.AppSSHHostKeyFingerprint(), a.appGUID, password)
+			return endpoint, err
+		},
+	)
+	if sshClient != nil {
+		defer sshClient.Close()
+	}
+
+	var conn net.Conn
+	d.Require(
+		"Service reachable from app",
+		"the app's container can't connect to the service, check the space's security groups allow it (`cf security-groups`)",
+		func() (string, error) {
+			var err error
+			remoteAddr := a.instances[0].forwardAddr.RemoteAddr
+			conn, err = sshClient.Dial("tcp", remoteAddr)
+			return remoteAddr, err
+		},
+	)
+	if conn != nil {
+		defer conn.Close()
+	}
+
+	d.Check(
+		"TLS handshake with service",
+		"check the service supports the --minimum-tls-version and --cipher-suites given, and that its certificate is trusted by this machine or given with --tls-ca-file, which for Amazon RDS needs the CAs from "+tls.RDSCABundleURL,
+		func() (string, error) {
+			return a.tlsHandshake(conn, a.instances[0])
+		},
+	)
+}
+
+// tlsHandshake starts TLS on a connection to the service the way its
+// clients do, and returns the negotiated version and cipher suite
+func (a *App) tlsHandshake(conn net.Conn, ti tunnelledInstance) (string, error) {
+	// connections through the tunnel don't support deadlines
+	timer := time.AfterFunc(30*time.Second, func() { conn.Close() })
+	defer timer.Stop()
+
+	provider := a.serviceProviders[ti.serviceType]
+	tlsEnabled := provider.IsTLSEnabled(ti.instance.Credentials)
+	switch {
+	case ti.serviceType == "postgres":
+		// postgres clients ask for TLS before starting it on the same
+		// connection, so this works even if the binding doesn't say it's on
+		ok, err := tls.PostgresSSLRequest(conn)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			if !tlsEnabled {
+				return "", skipCheck("TLS isn't enabled for the service instance")
+			}
+			return "", errors.New("the service refused to start TLS")
+		}
+	case ti.serviceType == "mysql":
+		return "", skipCheck("TLS is negotiated by the mysql client, so isn't checked")
+	case !tlsEnabled:
+		return "", skipCheck("TLS isn't enabled for the service instance")
+	}
+
+	config, err := tls.ClientConfig(tls.ServerName(ti.forwardAddr.RemoteAddr), a.tlsInsecure, a.tlsCipherSuites, a.tlsMinVersion)
+	if err != nil {
+		return "", err
+	}
+	creds, err := a.tlsClientCredentials(ti)
+	if err != nil {
+		return "", err
+	}
+	creds.Apply(config)
+	tlsConn := gotls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return "", tls.NewHandshakeError(ti.forwardAddr.RemoteAddr, config.ServerName, tlsConn.ConnectionState(), err)
+	}
+	state := tlsConn.ConnectionState()
+	return fmt.Sprintf("%s, %s", gotls.VersionName(state.Version), gotls.CipherSuiteName(state.CipherSuite)), nil
+}
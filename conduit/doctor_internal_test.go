@@ -0,0 +1,144 @@
+package conduit
+
+import (
+	"bytes"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	gocfclient "github.com/cloudfoundry-community/go-cfclient"
+
+	"github.com/alphagov/paas-cf-conduit/client/clientfakes"
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+var _ = Describe("Doctor", func() {
+	var d *Doctor
+
+	BeforeEach(func() {
+		d = NewDoctor(util.NewStatus(GinkgoWriter, true))
+	})
+
+	It("records each check's result", func() {
+		Expect(d.Check("First", "hint 1", func() (string, error) { return "all good", nil })).To(BeTrue())
+		Expect(d.Check("Second", "hint 2", func() (string, error) { return "", errors.New("broken\n") })).To(BeFalse())
+		Expect(d.Check("Third", "hint 3", func() (string, error) { return "", skipCheck("not relevant") })).To(BeFalse())
+
+		Expect(d.Results).To(Equal([]CheckResult{
+			{Name: "First", Status: CheckPassed, Detail: "all good"},
+			{Name: "Second", Status: CheckFailed, Detail: "broken", Hint: "hint 2"},
+			{Name: "Third", Status: CheckSkipped, Detail: "not relevant"},
+		}))
+		Expect(d.Failed()).To(BeTrue())
+	})
+
+	It("skips every check after a required check fails", func() {
+		d.Check("Independent", "", func() (string, error) { return "", errors.New("broken") })
+		d.Require("Required", "", func() (string, error) { return "", errors.New("broken") })
+		ran := false
+		d.Check("Dependent", "", func() (string, error) {
+			ran = true
+			return "", nil
+		})
+
+		Expect(ran).To(BeFalse())
+		Expect(d.Results[2]).To(Equal(CheckResult{
+			Name:   "Dependent",
+			Status: CheckSkipped,
+			Detail: "skipped as required failed",
+		}))
+	})
+
+	It("doesn't fail if every check passes or is skipped", func() {
+		d.Check("First", "", func() (string, error) { return "", nil })
+		d.Check("Second", "", func() (string, error) { return "", skipCheck("not relevant") })
+		Expect(d.Failed()).To(BeFalse())
+	})
+
+	It("prints a report with hints for the failures", func() {
+		d.Check("First", "hint 1", func() (string, error) { return "all good", nil })
+		d.Require("Second", "hint 2", func() (string, error) { return "", errors.New("broken") })
+		d.Check("Third", "hint 3", func() (string, error) { return "", nil })
+
+		out := &bytes.Buffer{}
+		d.Print(out)
+		Expect(out.String()).To(Equal(
+			"\nConduit doctor:\n\n" +
+				"PASS  First: all good\n" +
+				"FAIL  Second: broken\n" +
+				"      hint: hint 2\n" +
+				"SKIP  Third: skipped as second failed\n",
+		))
+	})
+})
+
+var _ = Describe("Diagnose()", func() {
+	var (
+		fakeClient *clientfakes.FakeClient
+		app        *App
+		d          *Doctor
+	)
+
+	BeforeEach(func() {
+		fakeClient = &clientfakes.FakeClient{}
+		fakeClient.AppSSHEndpointReturns("127.0.0.1:1")
+		fakeClient.GetOrgByNameReturns(&gocfclient.Org{Name: "my-org", Guid: "org-guid"}, nil)
+		fakeClient.GetSpaceByNameReturns(&gocfclient.Space{Name: "my-space", Guid: "space-guid"}, nil)
+
+		status := util.NewStatus(GinkgoWriter, true)
+		d = NewDoctor(status)
+		app = NewApp(
+			fakeClient, status,
+			7080, "my-org", "my-space", "__conduit_test__", true,
+			nil, nil, nil, false, nil, 0,
+		)
+	})
+
+	It("only checks the platform when no service instance is given", func() {
+		fakeClient.SSHCodeReturns("", errors.New("no code for you"))
+
+		app.Diagnose(d)
+
+		Expect(d.Results).To(HaveLen(4))
+		Expect(d.Results[0]).To(Equal(CheckResult{
+			Name:   "SSH one-time code",
+			Status: CheckFailed,
+			Detail: "no code for you",
+			Hint:   "UAA wouldn't give a one-time code for the ssh-proxy, check that `cf ssh-code` works",
+		}))
+		Expect(d.Results[1].Name).To(Equal("SSH host key"))
+		Expect(d.Results[1].Status).To(Equal(CheckFailed))
+		Expect(d.Results[2]).To(Equal(CheckResult{Name: "Org and space", Status: CheckPassed, Detail: "my-org/my-space"}))
+		Expect(d.Results[3]).To(Equal(CheckResult{
+			Name:   "Space allows SSH",
+			Status: CheckFailed,
+			Detail: "SSH is disabled for space my-space",
+			Hint:   "a space manager can allow it with `cf allow-space-ssh`",
+		}))
+		Expect(fakeClient.CreateAppCallCount()).To(Equal(0))
+	})
+
+	It("skips the rest of the checks if the conduit app can't be started", func() {
+		app.serviceInstanceNames = []string{"my-db"}
+		fakeClient.CreateAppReturns("", errors.New("quota exceeded"))
+
+		app.Diagnose(d)
+
+		names := []string{}
+		for _, result := range d.Results[5:] {
+			names = append(names, result.Name)
+			Expect(result.Status).To(Equal(CheckSkipped))
+			Expect(result.Detail).To(Equal("skipped as conduit app started failed"))
+		}
+		Expect(d.Results[4].Detail).To(Equal("quota exceeded"))
+		Expect(names).To(Equal([]string{
+			"App allows SSH",
+			"Service binding",
+			"SSH handshake",
+			"Service reachable from app",
+			"TLS handshake with service",
+		}))
+		Expect(fakeClient.BindServiceCallCount()).To(Equal(0))
+	})
+})
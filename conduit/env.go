@@ -0,0 +1,87 @@
+package conduit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// EnvFormats lists the formats understood by WriteEnv
+var EnvFormats = []string{"sh", "fish", "powershell", "dotenv", "json"}
+
+// SetExportEnv sets up the environment of every service type the tunnelled
+// service instances are of, as with --client-type, as the programs which will
+// use the exported environment aren't known
+func (a *App) SetExportEnv(exportEnv bool) {
+	a.exportEnv = exportEnv
+}
+
+// ExportEnv writes the environment that would be given to a command run
+// through the conduit (including VCAP_SERVICES) in the given format
+func (a *App) ExportEnv(w io.Writer, format string) error {
+	return WriteEnv(w, format, a.runEnv)
+}
+
+// WriteEnv writes env to w so that it can be loaded by a shell (sh, fish,
+// powershell), read as a docker-compose style env file (dotenv) or parsed
+// as a JSON object (json). Keys are written in sorted order.
+func WriteEnv(w io.Writer, format string, env map[string]string) error {
+	if format == "json" {
+		b, err := json.MarshalIndent(env, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+
+	var line func(k, v string) string
+	switch format {
+	case "sh":
+		line = func(k, v string) string {
+			return fmt.Sprintf("export %s='%s'", k, strings.Replace(v, "'", `'\''`, -1))
+		}
+	case "fish":
+		line = func(k, v string) string {
+			v = strings.Replace(v, `\`, `\\`, -1)
+			v = strings.Replace(v, "'", `\'`, -1)
+			return fmt.Sprintf("set -gx %s '%s';", k, v)
+		}
+	case "powershell":
+		line = func(k, v string) string {
+			return fmt.Sprintf("$env:%s = '%s'", k, strings.Replace(v, "'", "''", -1))
+		}
+	case "dotenv":
+		line = func(k, v string) string {
+			// single quoted values are taken literally, which is what we
+			// want for JSON blobs such as VCAP_SERVICES
+			if !strings.ContainsAny(v, "'\n") {
+				return fmt.Sprintf("%s='%s'", k, v)
+			}
+			v = strings.Replace(v, `\`, `\\`, -1)
+			v = strings.Replace(v, `"`, `\"`, -1)
+			v = strings.Replace(v, "\n", `\n`, -1)
+			return fmt.Sprintf(`%s="%s"`, k, v)
+		}
+	default:
+		return fmt.Errorf(
+			"unknown environment format '%s', expected one of: %s",
+			format,
+			strings.Join(EnvFormats, ", "),
+		)
+	}
+
+	keys := []string{}
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, err := fmt.Fprintln(w, line(k, env[k])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package conduit_test
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/conduit"
+)
+
+var _ = Describe("WriteEnv", func() {
+	var (
+		env map[string]string
+		buf *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		env = map[string]string{
+			"PGPASSWORD":    "it's-a-secret",
+			"VCAP_SERVICES": `{"postgres":[{"name":"db"}]}`,
+		}
+		buf = &bytes.Buffer{}
+	})
+
+	It("writes sh exports in sorted order", func() {
+		Expect(conduit.WriteEnv(buf, "sh", env)).To(Succeed())
+		Expect(buf.String()).To(Equal(
+			`export PGPASSWORD='it'\''s-a-secret'` + "\n" +
+				`export VCAP_SERVICES='{"postgres":[{"name":"db"}]}'` + "\n",
+		))
+	})
+
+	It("writes fish variables", func() {
+		Expect(conduit.WriteEnv(buf, "fish", env)).To(Succeed())
+		Expect(buf.String()).To(Equal(
+			`set -gx PGPASSWORD 'it\'s-a-secret';` + "\n" +
+				`set -gx VCAP_SERVICES '{"postgres":[{"name":"db"}]}';` + "\n",
+		))
+	})
+
+	It("writes powershell variables", func() {
+		Expect(conduit.WriteEnv(buf, "powershell", env)).To(Succeed())
+		Expect(buf.String()).To(Equal(
+			`$env:PGPASSWORD = 'it''s-a-secret'` + "\n" +
+				`$env:VCAP_SERVICES = '{"postgres":[{"name":"db"}]}'` + "\n",
+		))
+	})
+
+	It("writes a dotenv file, double quoting only when needed", func() {
+		Expect(conduit.WriteEnv(buf, "dotenv", env)).To(Succeed())
+		Expect(buf.String()).To(Equal(
+			`PGPASSWORD="it's-a-secret"` + "\n" +
+				`VCAP_SERVICES='{"postgres":[{"name":"db"}]}'` + "\n",
+		))
+	})
+
+	It("writes a JSON object", func() {
+		Expect(conduit.WriteEnv(buf, "json", env)).To(Succeed())
+		Expect(buf.String()).To(MatchJSON(`{
+			"PGPASSWORD": "it's-a-secret",
+			"VCAP_SERVICES": "{\"postgres\":[{\"name\":\"db\"}]}"
+		}`))
+	})
+
+	It("rejects unknown formats", func() {
+		err := conduit.WriteEnv(buf, "csh", env)
+		Expect(err).To(MatchError(ContainSubstring("unknown environment format 'csh'")))
+	})
+})
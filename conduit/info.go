@@ -0,0 +1,130 @@
+package conduit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/ssh"
+)
+
+// ConnectionInfo describes how to reach a single tunnelled service instance
+type ConnectionInfo struct {
+	Name          string             `json:"name" yaml:"name"`
+	InstanceName  string             `json:"instance_name" yaml:"instance_name"`
+	Service       string             `json:"service" yaml:"service"`
+	LocalHost     string             `json:"local_host" yaml:"local_host"`
+	LocalPort     int64              `json:"local_port" yaml:"local_port"`
+	TLSTunnelPort int64              `json:"tls_tunnel_port,omitempty" yaml:"tls_tunnel_port,omitempty"`
+	LocalSocket   string             `json:"local_socket,omitempty" yaml:"local_socket,omitempty"`
+	LocalCAFile   string             `json:"local_ca_file,omitempty" yaml:"local_ca_file,omitempty"`
+	Credentials   client.Credentials `json:"credentials" yaml:"credentials"`
+}
+
+// ConnectionInfoDocument is the document written by WriteConnectionInfo
+type ConnectionInfoDocument struct {
+	Instances []ConnectionInfo `json:"instances" yaml:"instances"`
+}
+
+// OutputFormats lists the formats understood by WriteConnectionInfo, a Go
+// text/template can also be given as "template=TEMPLATE"
+var OutputFormats = []string{"json", "yaml", "template=TEMPLATE"}
+
+// tunnelledInstance records which tunnel serves which service instance
+type tunnelledInstance struct {
+	serviceType string
+	instance    *client.VcapService
+	forwardAddr ssh.ForwardAddrs
+	// localTLS is how TLS is negotiated with local clients, if it's served
+	localTLS string
+}
+
+// ConnectionInfo returns the connection details of every tunnelled service
+// instance, ordered by service type and then by the order the instances
+// were bound in
+func (a *App) ConnectionInfo() []ConnectionInfo {
+	info := []ConnectionInfo{}
+	for _, ti := range a.instances {
+		var caFile string
+		if ti.localTLS != "" {
+			caFile = a.localCA.CAFile
+		}
+		info = append(info, ConnectionInfo{
+			Name:          ti.instance.Name,
+			InstanceName:  ti.instance.InstanceName,
+			Service:       ti.serviceType,
+			LocalHost:     ti.instance.Credentials.Host(),
+			LocalPort:     ti.forwardAddr.LocalPort,
+			TLSTunnelPort: ti.forwardAddr.TLSTunnelPort,
+			LocalSocket:   ti.forwardAddr.SocketPath,
+			LocalCAFile:   caFile,
+			Credentials:   ti.instance.Credentials,
+		})
+	}
+	return info
+}
+
+// RedactConnectionInfo returns a copy of info with the secrets in the
+// credentials masked, unless logging.ShowSecrets is set
+func RedactConnectionInfo(info []ConnectionInfo) []ConnectionInfo {
+	redacted := make([]ConnectionInfo, len(info))
+	for i, ci := range info {
+		redacted[i] = ci
+		redacted[i].Credentials = ci.Credentials.Redacted()
+	}
+	return redacted
+}
+
+// ValidateOutputFormat checks that format is understood by
+// WriteConnectionInfo without writing anything
+func ValidateOutputFormat(format string) error {
+	if format == "json" || format == "yaml" {
+		return nil
+	}
+	if strings.HasPrefix(format, "template=") {
+		_, err := template.New("output").Parse(strings.TrimPrefix(format, "template="))
+		if err != nil {
+			return fmt.Errorf("failed to parse output template: %s", err)
+		}
+		return nil
+	}
+	return fmt.Errorf(
+		"unknown output format '%s', expected one of: %s",
+		format,
+		strings.Join(OutputFormats, ", "),
+	)
+}
+
+// WriteConnectionInfo writes the connection details of the tunnelled
+// instances as JSON, YAML or using a text/template ("template=...")
+func WriteConnectionInfo(w io.Writer, format string, info []ConnectionInfo) error {
+	if err := ValidateOutputFormat(format); err != nil {
+		return err
+	}
+	doc := ConnectionInfoDocument{Instances: info}
+
+	switch {
+	case format == "json":
+		b, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case format == "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		tmpl := template.Must(template.New("output").Parse(strings.TrimPrefix(format, "template=")))
+		return tmpl.Execute(w, doc)
+	}
+}
@@ -0,0 +1,85 @@
+package conduit_test
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/conduit"
+)
+
+var _ = Describe("WriteConnectionInfo", func() {
+	var (
+		info []conduit.ConnectionInfo
+		buf  *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		info = []conduit.ConnectionInfo{
+			{
+				Name:          "some-binding",
+				InstanceName:  "my-redis",
+				Service:       "redis",
+				LocalHost:     "127.0.0.1",
+				LocalPort:     7080,
+				TLSTunnelPort: 7081,
+				Credentials: client.Credentials{
+					"host": "127.0.0.1",
+					"port": "7081",
+				},
+			},
+		}
+		buf = &bytes.Buffer{}
+	})
+
+	It("writes JSON", func() {
+		Expect(conduit.WriteConnectionInfo(buf, "json", info)).To(Succeed())
+		Expect(buf.String()).To(MatchJSON(`{
+			"instances": [
+				{
+					"name": "some-binding",
+					"instance_name": "my-redis",
+					"service": "redis",
+					"local_host": "127.0.0.1",
+					"local_port": 7080,
+					"tls_tunnel_port": 7081,
+					"credentials": {"host": "127.0.0.1", "port": "7081"}
+				}
+			]
+		}`))
+	})
+
+	It("writes YAML", func() {
+		Expect(conduit.WriteConnectionInfo(buf, "yaml", info)).To(Succeed())
+		Expect(buf.String()).To(MatchYAML(`
+instances:
+  - name: some-binding
+    instance_name: my-redis
+    service: redis
+    local_host: 127.0.0.1
+    local_port: 7080
+    tls_tunnel_port: 7081
+    credentials:
+      host: 127.0.0.1
+      port: "7081"
+`))
+	})
+
+	It("renders a template", func() {
+		format := `template={{range .Instances}}{{.InstanceName}}={{.LocalHost}}:{{.Credentials.port}}{{"\n"}}{{end}}`
+		Expect(conduit.WriteConnectionInfo(buf, format, info)).To(Succeed())
+		Expect(buf.String()).To(Equal("my-redis=127.0.0.1:7081\n"))
+	})
+
+	It("rejects invalid templates", func() {
+		err := conduit.WriteConnectionInfo(buf, "template={{.Instances", info)
+		Expect(err).To(MatchError(ContainSubstring("failed to parse output template")))
+	})
+
+	It("rejects unknown formats", func() {
+		err := conduit.WriteConnectionInfo(buf, "xml", info)
+		Expect(err).To(MatchError(ContainSubstring("unknown output format 'xml'")))
+	})
+})
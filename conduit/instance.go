@@ -0,0 +1,56 @@
+package conduit
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// UseProviderClients is for commands which run the service providers' own
+// clients, chosen once the type of each service instance is known (e.g.
+// backups). The tunnels are set up to suit those clients, e.g. with a TLS
+// tunnel for redis-cli.
+func (a *App) UseProviderClients() {
+	a.providerClients = true
+}
+
+// instanceNamed returns the tunnelled service instance with the given name
+func (a *App) instanceNamed(name string) (*tunnelledInstance, error) {
+	for i := range a.instances {
+		if a.instances[i].instance.InstanceName == name {
+			return &a.instances[i], nil
+		}
+	}
+	return nil, fmt.Errorf("service instance %s is not tunnelled", name)
+}
+
+// InstanceConnectionInfo returns the connection details of the named
+// tunnelled service instance
+func (a *App) InstanceConnectionInfo(name string) (ConnectionInfo, error) {
+	for _, info := range a.ConnectionInfo() {
+		if info.InstanceName == name {
+			return info, nil
+		}
+	}
+	return ConnectionInfo{}, fmt.Errorf("service instance %s is not tunnelled", name)
+}
+
+// instanceEnv returns the environment a client needs to connect to a single
+// service instance
+func (a *App) instanceEnv(ti *tunnelledInstance) (map[string]string, error) {
+	env := map[string]string{}
+	if err := a.initEnv(a.serviceProviders[ti.serviceType], ti.instance.Credentials, env); err != nil {
+		return nil, fmt.Errorf("failed to set up the environment for %s: %s", ti.instance.InstanceName, err)
+	}
+	return env, nil
+}
+
+// toolVersion returns the first line printed by program --version
+func toolVersion(program string) string {
+	out, err := exec.Command(program, "--version").Output()
+	if err != nil {
+		logger.Debug("failed to get the version of", program, "err:", err)
+		return "unknown"
+	}
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+}
@@ -0,0 +1,85 @@
+package conduit
+
+import (
+	"net"
+
+	"github.com/alphagov/paas-cf-conduit/ssh"
+	"github.com/alphagov/paas-cf-conduit/tls"
+)
+
+// tlsProtocols are how TLS is started with the service types conduit can
+// start it with itself, and serve it locally for. MySQL isn't supported, as
+// the server starts its handshake.
+var tlsProtocols = map[string]string{
+	"postgres": tls.ProtocolPostgres,
+	"redis":    tls.ProtocolTLS,
+}
+
+// SetLocalTLS makes the tunnels serve TLS locally, with a certificate from a
+// CA which only exists for as long as conduit is running
+func (a *App) SetLocalTLS(localTLS bool) {
+	a.localTLS = localTLS
+}
+
+// localTLSProtocol returns how TLS is negotiated with the local clients of
+// a service instance, or an empty string if it's served in plaintext
+func (a *App) localTLSProtocol(serviceType string) string {
+	if !a.localTLS {
+		return ""
+	}
+	protocol, ok := tlsProtocols[serviceType]
+	if !ok {
+		logger.Warn("local TLS isn't supported for", serviceType+", its tunnels are plaintext locally")
+		return ""
+	}
+	// the clients which can't speak TLS are run with a plaintext tunnel
+	if provider, ok := a.serviceProviders[serviceType]; ok {
+		for _, program := range a.programs() {
+			if isKnownClient(program, provider.GetNonTLSClients()) {
+				return ""
+			}
+		}
+	}
+	return protocol
+}
+
+// localCAHosts are the hosts the local certificate is valid for
+func (a *App) localCAHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	for _, host := range []string{a.connectHost(), a.bindAddress} {
+		if host == "" || net.ParseIP(host).IsUnspecified() {
+			continue
+		}
+		hosts = mergeStrings(hosts, []string{host})
+	}
+	return hosts
+}
+
+// serveLocalTLS sets up a tunnel to serve TLS to its local clients,
+// creating the local CA the first time it's needed. TLS tunnels are set up
+// when they're started.
+func (a *App) serveLocalTLS(forwardAddr *ssh.ForwardAddrs, protocol string) error {
+	if a.localCA == nil {
+		dir, err := a.tempDir()
+		if err != nil {
+			return err
+		}
+		ca, err := tls.NewLocalCA(dir, a.localCAHosts())
+		if err != nil {
+			return err
+		}
+		a.localCA = ca
+		a.runEnv["PGSSLROOTCERT"] = ca.CAFile
+		a.runEnv["SSL_CERT_FILE"] = ca.CAFile
+	}
+	if forwardAddr.TLSTunnelPort == 0 {
+		config := a.localCA.ServerConfig
+		forwardAddr.WrapConn = func(conn net.Conn) (net.Conn, error) {
+			return tls.ServerConn(conn, config, protocol)
+		}
+	}
+	if protocol == tls.ProtocolPostgres {
+		a.runEnv["PGSSLMODE"] = "verify-full"
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package conduit
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/service"
+	"github.com/alphagov/paas-cf-conduit/tls"
+)
+
+var _ = Describe("Local TLS", func() {
+	var app *App
+
+	BeforeEach(func() {
+		app = &App{serviceProviders: map[string]ServiceProvider{}}
+		app.RegisterServiceProvider("mysql", &service.MySQL{})
+		app.RegisterServiceProvider("postgres", &service.Postgres{})
+		app.RegisterServiceProvider("redis", &service.Redis{})
+		app.SetLocalTLS(true)
+	})
+
+	DescribeTable("how TLS is negotiated with local clients",
+		func(program, serviceType, expected string) {
+			app.program = program
+			Expect(app.localTLSProtocol(serviceType)).To(Equal(expected))
+		},
+		Entry("postgres", "psql", "postgres", tls.ProtocolPostgres),
+		Entry("redis", "", "redis", tls.ProtocolTLS),
+		Entry("redis with a client which can't speak TLS", "redis-cli", "redis", ""),
+		Entry("mysql, which isn't supported", "mysql", "mysql", ""),
+	)
+
+	It("isn't used unless asked for", func() {
+		app.SetLocalTLS(false)
+		Expect(app.localTLSProtocol("postgres")).To(BeEmpty())
+	})
+
+	DescribeTable("the hosts the certificate is for",
+		func(bindAddress, credentialsHost string, expected []string) {
+			app.SetBindAddress(bindAddress)
+			app.SetCredentialsHost(credentialsHost)
+			Expect(app.localCAHosts()).To(Equal(expected))
+		},
+		Entry("by default", "", "", []string{"localhost", "127.0.0.1", "::1"}),
+		Entry("bound to every address", "0.0.0.0", "", []string{"localhost", "127.0.0.1", "::1"}),
+		Entry("bound to a LAN address", "192.168.1.10", "", []string{"localhost", "127.0.0.1", "::1", "192.168.1.10"}),
+		Entry("given a host", "0.0.0.0", "host.docker.internal", []string{"localhost", "127.0.0.1", "::1", "host.docker.internal"}),
+	)
+})
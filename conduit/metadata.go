@@ -0,0 +1,103 @@
+package conduit
+
+import (
+	"strings"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+)
+
+const (
+	// ManagedLabel is set on every app conduit creates, so that cleanup can
+	// find the ones conduit didn't get the chance to delete
+	ManagedLabel = "conduit.alphagov/managed"
+
+	// SharedLabel is set on the app shared by everyone using --shared in a
+	// space, which is stopped rather than deleted when it's not in use
+	SharedLabel = "conduit.alphagov/shared"
+
+	// KeepLabel is set on the apps conduit was asked not to delete, e.g. to
+	// use again with --existing-app, which cleanup leaves alone
+	KeepLabel = "conduit.alphagov/keep"
+
+	// the annotations say who created an app and what for. They're
+	// annotations rather than labels as label values can't contain e.g. @
+	OwnerAnnotation     = "conduit.alphagov/owner"
+	CreatedAtAnnotation = "conduit.alphagov/created-at"
+	HostnameAnnotation  = "conduit.alphagov/hostname"
+	VersionAnnotation   = "conduit.alphagov/version"
+	InstancesAnnotation = "conduit.alphagov/instances"
+
+	// InUseUntilAnnotation is renewed while the tunnels through an app are
+	// open, so that cleanup can tell the apps which are in use from those
+	// left behind
+	InUseUntilAnnotation = "conduit.alphagov/in-use-until"
+)
+
+// AppOwner is who is running conduit, and where
+type AppOwner struct {
+	User     string
+	Hostname string
+	Version  string
+}
+
+// SetOwner sets who is running conduit, which is recorded on the app
+func (a *App) SetOwner(owner AppOwner) {
+	a.owner = owner
+}
+
+// appMetadata returns the labels and annotations for a new conduit app
+func (a *App) appMetadata(createdAt time.Time) client.Metadata {
+	annotations := map[string]string{
+		CreatedAtAnnotation: createdAt.UTC().Format(time.RFC3339),
+		InstancesAnnotation: strings.Join(a.serviceInstanceNames, ","),
+	}
+	if a.shared {
+		// the leases on the shared app say what each user is using it for
+		delete(annotations, InstancesAnnotation)
+	} else {
+		annotations[InUseUntilAnnotation] = inUseUntil(createdAt)
+	}
+	for key, value := range map[string]string{
+		OwnerAnnotation:    a.owner.User,
+		HostnameAnnotation: a.owner.Hostname,
+		VersionAnnotation:  a.owner.Version,
+	} {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+
+	labels := map[string]string{ManagedLabel: "true"}
+	if a.shared {
+		labels[SharedLabel] = "true"
+	} else if !a.deleteApp {
+		labels[KeepLabel] = "true"
+	}
+	return client.Metadata{
+		Labels:      labels,
+		Annotations: annotations,
+	}
+}
+
+// inUseUntil is when an app stops being in use unless it's marked as in use
+// again, LeaseDuration from now
+func inUseUntil(now time.Time) string {
+	return now.Add(LeaseDuration).UTC().Format(time.RFC3339)
+}
+
+// markInUse says the app is still in use, so that cleanup leaves it alone
+func (a *App) markInUse() error {
+	return a.cfClient.UpdateAppMetadata(a.appGUID, client.Metadata{
+		Annotations: map[string]string{InUseUntilAnnotation: inUseUntil(time.Now())},
+	})
+}
+
+// InUseUntil returns when an app created by conduit stops being in use, or
+// when it was created if it doesn't say
+func InUseUntil(app client.AppSummary) time.Time {
+	if until, err := time.Parse(time.RFC3339, app.Metadata.Annotations[InUseUntilAnnotation]); err == nil && until.After(app.CreatedAt) {
+		return until
+	}
+	return app.CreatedAt
+}
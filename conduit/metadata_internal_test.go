@@ -0,0 +1,65 @@
+package conduit
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+)
+
+var _ = Describe("appMetadata()", func() {
+	createdAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.FixedZone("BST", 3600))
+
+	It("labels the app and says who created it and what for", func() {
+		a := &App{serviceInstanceNames: []string{"db", "cache"}, deleteApp: true}
+		a.SetOwner(AppOwner{User: "jo@example.com", Hostname: "jos-laptop", Version: "0.1.2"})
+
+		Expect(a.appMetadata(createdAt)).To(Equal(client.Metadata{
+			Labels: map[string]string{
+				"conduit.alphagov/managed": "true",
+			},
+			Annotations: map[string]string{
+				"conduit.alphagov/owner":        "jo@example.com",
+				"conduit.alphagov/created-at":   "2024-01-01T11:00:00Z",
+				"conduit.alphagov/hostname":     "jos-laptop",
+				"conduit.alphagov/version":      "0.1.2",
+				"conduit.alphagov/instances":    "db,cache",
+				"conduit.alphagov/in-use-until": "2024-01-01T11:05:00Z",
+			},
+		}))
+	})
+
+	It("labels an app which won't be deleted as one to keep", func() {
+		a := &App{serviceInstanceNames: []string{"db"}, deleteApp: false}
+
+		Expect(a.appMetadata(createdAt).Labels).To(Equal(map[string]string{
+			"conduit.alphagov/managed": "true",
+			"conduit.alphagov/keep":    "true",
+		}))
+	})
+
+	It("leaves out what it doesn't know", func() {
+		a := &App{serviceInstanceNames: []string{"db"}}
+
+		Expect(a.appMetadata(createdAt).Annotations).To(Equal(map[string]string{
+			"conduit.alphagov/created-at":   "2024-01-01T11:00:00Z",
+			"conduit.alphagov/instances":    "db",
+			"conduit.alphagov/in-use-until": "2024-01-01T11:05:00Z",
+		}))
+	})
+
+	It("labels the shared app, leaving the leases to say what it's used for", func() {
+		a := &App{serviceInstanceNames: []string{"db"}}
+		a.SetShared(true)
+
+		metadata := a.appMetadata(createdAt)
+		Expect(metadata.Labels).To(Equal(map[string]string{
+			"conduit.alphagov/managed": "true",
+			"conduit.alphagov/shared":  "true",
+		}))
+		Expect(metadata.Annotations).NotTo(HaveKey("conduit.alphagov/instances"))
+		Expect(metadata.Annotations).NotTo(HaveKey("conduit.alphagov/in-use-until"))
+	})
+})
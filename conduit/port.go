@@ -0,0 +1,74 @@
+package conduit
+
+import (
+	"fmt"
+
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+// maxPort is the highest TCP port
+const maxPort = 65535
+
+// SetLocalPorts sets fixed local ports for some of the service instances,
+// by instance name. The rest are given ports from the local port as usual.
+func (a *App) SetLocalPorts(ports map[string]int64) {
+	a.localPorts = ports
+}
+
+// SetEphemeralPorts lets the OS choose the local ports which aren't fixed,
+// rather than counting up from the local port
+func (a *App) SetEphemeralPorts(ephemeral bool) {
+	a.ephemeralPorts = ephemeral
+}
+
+// localPort returns the local port for a service instance's tunnel, which
+// is its fixed port if it has one
+func (a *App) localPort(instanceName string) (int64, error) {
+	port, ok := a.localPorts[instanceName]
+	if !ok {
+		return a.allocatePort()
+	}
+	if util.PortIsInUseOn(a.listenHost(), int(port)) {
+		return 0, fmt.Errorf("local port %d for %s is already in use", port, instanceName)
+	}
+	return port, nil
+}
+
+// allocatePort returns a local port which isn't in use and hasn't been
+// given to a service instance
+func (a *App) allocatePort() (int64, error) {
+	if a.ephemeralPorts {
+		for {
+			port, err := util.GetRandomPortOn(a.listenHost())
+			if err != nil {
+				return 0, fmt.Errorf("failed to choose a local port: %s", err)
+			}
+			if !a.isFixedPort(int64(port)) {
+				return int64(port), nil
+			}
+		}
+	}
+
+	for a.nextPort <= maxPort {
+		port := a.nextPort
+		a.nextPort++
+		if a.isFixedPort(port) {
+			continue
+		}
+		if util.PortIsInUseOn(a.listenHost(), int(port)) {
+			logger.Debug("skipping local port", port, "as it's in use")
+			continue
+		}
+		return port, nil
+	}
+	return 0, fmt.Errorf("no free local ports left")
+}
+
+func (a *App) isFixedPort(port int64) bool {
+	for _, p := range a.localPorts {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
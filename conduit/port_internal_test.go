@@ -0,0 +1,77 @@
+package conduit
+
+import (
+	"fmt"
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+var _ = Describe("Local ports", func() {
+	var (
+		app      *App
+		basePort int64
+		listener net.Listener
+	)
+
+	BeforeEach(func() {
+		port, err := util.GetRandomPort()
+		Expect(err).NotTo(HaveOccurred())
+		basePort = int64(port)
+		app = &App{nextPort: basePort}
+	})
+
+	AfterEach(func() {
+		if listener != nil {
+			listener.Close()
+			listener = nil
+		}
+	})
+
+	listen := func(port int64) {
+		var err error
+		listener, err = net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("counts up from the local port, skipping the fixed ports", func() {
+		app.SetLocalPorts(map[string]int64{"db": basePort + 1})
+		Expect(app.allocatePort()).To(Equal(basePort))
+		Expect(app.allocatePort()).To(Equal(basePort + 2))
+	})
+
+	It("skips ports which are in use", func() {
+		listen(basePort)
+		Expect(app.allocatePort()).To(Equal(basePort + 1))
+	})
+
+	It("uses an instance's fixed port", func() {
+		app.SetLocalPorts(map[string]int64{"db": basePort + 5})
+		Expect(app.localPort("db")).To(Equal(basePort + 5))
+		Expect(app.localPort("cache")).To(Equal(basePort))
+	})
+
+	It("fails if an instance's fixed port is in use", func() {
+		listen(basePort)
+		app.SetLocalPorts(map[string]int64{"db": basePort})
+		_, err := app.localPort("db")
+		Expect(err).To(MatchError(fmt.Sprintf("local port %d for db is already in use", basePort)))
+	})
+
+	It("fails once there are no ports left", func() {
+		app.nextPort = maxPort + 1
+		_, err := app.allocatePort()
+		Expect(err).To(MatchError("no free local ports left"))
+	})
+
+	It("lets the OS choose ephemeral ports", func() {
+		app.SetEphemeralPorts(true)
+		port, err := app.allocatePort()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(port).To(BeNumerically(">", 0))
+		Expect(app.nextPort).To(Equal(basePort))
+	})
+})
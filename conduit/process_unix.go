@@ -0,0 +1,103 @@
+//go:build !windows
+
+package conduit
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/alphagov/paas-cf-conduit/util"
+
+	"golang.org/x/sys/unix"
+)
+
+// forwardedSignals are passed on to the process group of a running command
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGWINCH}
+
+// isTerminating reports whether sig asks the command to exit, in which case
+// it is killed if it hasn't exited within the grace period
+func isTerminating(sig os.Signal) bool {
+	return sig == syscall.SIGINT || sig == syscall.SIGTERM || sig == syscall.SIGHUP
+}
+
+// configureProcess runs the command in its own process group. If we own the
+// terminal, the command's group is given the foreground so that Ctrl+C and
+// window size changes go straight to it and it can read from the terminal.
+// The returned function gives the terminal back once the command has exited.
+func configureProcess(proc *exec.Cmd) func() {
+	proc.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	ttyFd := int(os.Stdin.Fd())
+	foregroundPgrp, err := unix.IoctlGetInt(ttyFd, unix.TIOCGPGRP)
+	if err != nil || foregroundPgrp != syscall.Getpgrp() {
+		// not a terminal, or we're running in the background
+		return func() {}
+	}
+
+	proc.SysProcAttr.Foreground = true
+	proc.SysProcAttr.Ctty = ttyFd
+
+	return func() {
+		// we're in a background process group at this point, so taking
+		// the terminal back would stop us with SIGTTOU unless ignored
+		signal.Ignore(syscall.SIGTTOU)
+		defer signal.Reset(syscall.SIGTTOU)
+		if err := unix.IoctlSetPointerInt(ttyFd, unix.TIOCSPGRP, foregroundPgrp); err != nil {
+			logger.Debug("failed to restore terminal foreground process group:", err)
+		}
+	}
+}
+
+// signalProcess sends sig to the command's process group
+func signalProcess(proc *os.Process, sig os.Signal) error {
+	return syscall.Kill(-proc.Pid, sig.(syscall.Signal))
+}
+
+// killProcess kills the command's process group
+func killProcess(proc *os.Process) error {
+	return syscall.Kill(-proc.Pid, syscall.SIGKILL)
+}
+
+// processExitCode follows the shell convention of 128+n for commands killed by signal n
+func processExitCode(state *os.ProcessState) int {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return state.ExitCode()
+}
+
+// interruptedExitCode reports whether an exit code means the command was
+// interrupted, i.e. it was killed by a signal asking it to exit (or
+// SIGKILL), or exited with the status the shell gives for one. When the
+// command has the terminal's foreground Ctrl+C only reaches it, not us.
+func interruptedExitCode(code int) bool {
+	if code <= 128 {
+		return false
+	}
+	sig := syscall.Signal(code - 128)
+	return isTerminating(sig) || sig == syscall.SIGKILL
+}
+
+// shellCommand runs args with the user's shell. A single argument is the
+// command line, and more than one are quoted so that the shell gets the
+// same words.
+func shellCommand(args []string) []string {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	line := args[0]
+	if len(args) > 1 {
+		line = util.JoinCommandLine(args)
+	}
+	return []string{shell, "-c", line}
+}
+
+// processAlive returns whether a process with the pid is running
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	// EPERM means it's running as another user
+	return err == nil || err == syscall.EPERM
+}
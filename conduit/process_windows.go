@@ -0,0 +1,75 @@
+//go:build windows
+
+package conduit
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// Console processes on Windows all receive Ctrl+C themselves, and other
+// signals can't be sent to a process, so nothing is forwarded
+var forwardedSignals = []os.Signal{}
+
+func isTerminating(sig os.Signal) bool {
+	return false
+}
+
+func configureProcess(proc *exec.Cmd) func() {
+	return func() {}
+}
+
+func signalProcess(proc *os.Process, sig os.Signal) error {
+	return proc.Signal(sig)
+}
+
+func killProcess(proc *os.Process) error {
+	return proc.Kill()
+}
+
+func processExitCode(state *os.ProcessState) int {
+	return state.ExitCode()
+}
+
+// interruptedExitCode reports whether a console program was stopped by
+// Ctrl+C, from its STATUS_CONTROL_C_EXIT exit code
+func interruptedExitCode(code int) bool {
+	return uint32(code) == uint32(windows.STATUS_CONTROL_C_EXIT)
+}
+
+func shellCommand(args []string) []string {
+	shell := os.Getenv("COMSPEC")
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	line := args[0]
+	if len(args) > 1 {
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = syscall.EscapeArg(arg)
+		}
+		line = strings.Join(quoted, " ")
+	}
+	return []string{shell, "/C", line}
+}
+
+// processAlive returns whether a process with the pid is running
+func processAlive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// access is denied to processes which exist but belong to others
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(h)
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	// STILL_ACTIVE
+	return code == 259
+}
@@ -0,0 +1,221 @@
+package conduit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+// ProfilesFile is the file profiles are read from
+type ProfilesFile struct {
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// Profile is a named set of options for conduit. Instances, Env and
+// Command describe the tunnel, and anything else is the value of a flag.
+type Profile struct {
+	Instances []ProfileInstance      `yaml:"instances"`
+	Env       map[string]string      `yaml:"env"`
+	Command   string                 `yaml:"command"`
+	Flags     map[string]interface{} `yaml:",inline"`
+}
+
+// ProfileInstance is a service instance to tunnel to, optionally on a fixed
+// local port
+type ProfileInstance struct {
+	Name      string `yaml:"name"`
+	LocalPort int64  `yaml:"local-port"`
+}
+
+// UnmarshalYAML lets an instance be given as just its name
+func (pi *ProfileInstance) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		pi.Name = node.Value
+		return nil
+	}
+	type plain ProfileInstance
+	return node.Decode((*plain)(pi))
+}
+
+// DefaultProfilesPath is where profiles are kept, alongside the cf CLI's
+// config
+func DefaultProfilesPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(dir), "conduit.yml"), nil
+}
+
+// LoadProfile reads the named profile from the file at path
+func LoadProfile(path, name string) (*Profile, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no profile %s as %s doesn't exist", name, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file ProfilesFile
+	if err := yaml.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	profile, ok := file.Profiles[name]
+	if !ok || profile == nil {
+		names := []string{}
+		for n := range file.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("no profile %s in %s, expected one of: %s", name, path, strings.Join(names, ", "))
+	}
+
+	for i, instance := range profile.Instances {
+		if instance.Name == "" {
+			return nil, fmt.Errorf("profile %s: instance %d has no name", name, i+1)
+		}
+	}
+	if _, err := parseEnvTemplates(profile.Env); err != nil {
+		return nil, fmt.Errorf("profile %s: %s", name, err)
+	}
+	if _, err := profile.CommandArgs(); err != nil {
+		return nil, fmt.Errorf("profile %s: %s", name, err)
+	}
+	return profile, nil
+}
+
+// Flag returns the value of a flag in the profile as it would be given on
+// the command line. Lists are joined with commas and maps, such as bind
+// parameters, are given as JSON.
+func (p *Profile) Flag(name string) (string, bool, error) {
+	value, ok := p.Flags[name]
+	if !ok || value == nil {
+		return "", false, nil
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true, nil
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ","), true, nil
+	case map[string]interface{}:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to convert %s to JSON: %s", name, err)
+		}
+		return string(b), true, nil
+	default:
+		return fmt.Sprint(v), true, nil
+	}
+}
+
+// FlagNames returns the names of the flags set in the profile
+func (p *Profile) FlagNames() []string {
+	names := []string{}
+	for name := range p.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InstanceNames returns the names of the service instances to tunnel to
+func (p *Profile) InstanceNames() []string {
+	names := []string{}
+	for _, instance := range p.Instances {
+		names = append(names, instance.Name)
+	}
+	return names
+}
+
+// LocalPorts returns the fixed local ports of the service instances which
+// have one
+func (p *Profile) LocalPorts() map[string]int64 {
+	ports := map[string]int64{}
+	for _, instance := range p.Instances {
+		if instance.LocalPort != 0 {
+			ports[instance.Name] = instance.LocalPort
+		}
+	}
+	return ports
+}
+
+// CommandArgs splits the profile's command into its arguments
+func (p *Profile) CommandArgs() ([]string, error) {
+	if p.Command == "" {
+		return []string{}, nil
+	}
+	args, err := util.SplitCommandLine(p.Command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse command: %s", err)
+	}
+	return args, nil
+}
+
+// SetEnvTemplates sets extra environment variables for the command, which
+// are Go text/templates given the connection info document and an
+// `instance NAME` function which returns the connection info of a single
+// service instance
+func (a *App) SetEnvTemplates(env map[string]string) error {
+	templates, err := parseEnvTemplates(env)
+	if err != nil {
+		return err
+	}
+	a.envTemplates = templates
+	return nil
+}
+
+// renderEnvTemplates adds the environment variables from the templates to
+// env once the tunnels have been planned
+func (a *App) renderEnvTemplates(env map[string]string) error {
+	if len(a.envTemplates) == 0 {
+		return nil
+	}
+	doc := ConnectionInfoDocument{Instances: a.ConnectionInfo()}
+	funcs := template.FuncMap{
+		"instance": func(name string) (ConnectionInfo, error) {
+			for _, info := range doc.Instances {
+				if info.InstanceName == name {
+					return info, nil
+				}
+			}
+			return ConnectionInfo{}, fmt.Errorf("service instance %s is not tunnelled", name)
+		},
+	}
+	for name, tmpl := range a.envTemplates {
+		var value strings.Builder
+		if err := tmpl.Funcs(funcs).Execute(&value, doc); err != nil {
+			return fmt.Errorf("failed to render %s: %s", name, err)
+		}
+		env[name] = value.String()
+	}
+	return nil
+}
+
+func parseEnvTemplates(env map[string]string) (map[string]*template.Template, error) {
+	templates := map[string]*template.Template{}
+	for name, text := range env {
+		// the function is replaced when rendering, but has to exist to parse
+		tmpl, err := template.New(name).Funcs(template.FuncMap{
+			"instance": func(string) (ConnectionInfo, error) { return ConnectionInfo{}, nil },
+		}).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse env %s: %s", name, err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
@@ -0,0 +1,96 @@
+package conduit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profiles", func() {
+	var path string
+
+	writeProfiles := func(content string) {
+		path = filepath.Join(GinkgoT().TempDir(), "conduit.yml")
+		Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		writeProfiles(`
+profiles:
+  prod-reporting:
+    org: my-org
+    space: prod
+    local-port: 15000
+    no-delete: true
+    cipher-suites: [TLS_AES_128_GCM_SHA256, TLS_AES_256_GCM_SHA384]
+    bind-parameters:
+      read_only: true
+    instances:
+      - reporting-db
+      - name: cache
+        local-port: 16379
+    env:
+      DATABASE_URL: "{{ (instance \"reporting-db\").Credentials.uri }}"
+    command: psql -c 'select 1'
+`)
+	})
+
+	It("reads the tunnel and the flags from a profile", func() {
+		profile, err := LoadProfile(path, "prod-reporting")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(profile.InstanceNames()).To(Equal([]string{"reporting-db", "cache"}))
+		Expect(profile.LocalPorts()).To(Equal(map[string]int64{"cache": 16379}))
+		Expect(profile.CommandArgs()).To(Equal([]string{"psql", "-c", "select 1"}))
+		Expect(profile.Env).To(HaveKey("DATABASE_URL"))
+		Expect(profile.FlagNames()).To(Equal([]string{
+			"bind-parameters", "cipher-suites", "local-port", "no-delete", "org", "space",
+		}))
+	})
+
+	It("gives flags as they would be given on the command line", func() {
+		profile, err := LoadProfile(path, "prod-reporting")
+		Expect(err).NotTo(HaveOccurred())
+
+		for flag, expected := range map[string]string{
+			"org":             "my-org",
+			"local-port":      "15000",
+			"no-delete":       "true",
+			"cipher-suites":   "TLS_AES_128_GCM_SHA256,TLS_AES_256_GCM_SHA384",
+			"bind-parameters": `{"read_only":true}`,
+		} {
+			value, ok, err := profile.Flag(flag)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal(expected), flag)
+		}
+
+		_, ok, err := profile.Flag("app-name")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeFalse())
+	})
+
+	It("lists the profiles if the one asked for doesn't exist", func() {
+		_, err := LoadProfile(path, "staging")
+		Expect(err).To(MatchError("no profile staging in " + path + ", expected one of: prod-reporting"))
+	})
+
+	It("fails if the file doesn't exist", func() {
+		missing := filepath.Join(GinkgoT().TempDir(), "conduit.yml")
+		_, err := LoadProfile(missing, "prod-reporting")
+		Expect(err).To(MatchError("no profile prod-reporting as " + missing + " doesn't exist"))
+	})
+
+	It("checks the env templates when it's read", func() {
+		writeProfiles(`
+profiles:
+  broken:
+    env:
+      DATABASE_URL: "{{ .Instances"
+`)
+		_, err := LoadProfile(path, "broken")
+		Expect(err).To(MatchError(ContainSubstring("profile broken: failed to parse env DATABASE_URL:")))
+	})
+})
@@ -0,0 +1,149 @@
+package conduit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+// RestoreProvider is implemented by service providers whose instances can
+// be restored from a backup
+type RestoreProvider interface {
+	// RestoreCommand returns a command which restores a backup in the
+	// given format, read from stdin, to the service instance with the
+	// given credentials. If clean is set the service instance isn't empty,
+	// and what's in the backup replaces what's already there.
+	RestoreCommand(creds client.Credentials, format string, clean bool) ([]string, error)
+	// CountCommand returns a command which prints the number of tables (or
+	// the like) in the service instance, to check whether it's empty
+	CountCommand(creds client.Credentials) []string
+}
+
+// ReadBackupMetadata reads the metadata written alongside the backup at path
+func ReadBackupMetadata(path string) (*BackupMetadata, error) {
+	b, err := os.ReadFile(MetadataPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup metadata: %s", err)
+	}
+	meta := &BackupMetadata{}
+	if err := json.Unmarshal(b, meta); err != nil {
+		return nil, fmt.Errorf("failed to parse backup metadata %s: %s", MetadataPath(path), err)
+	}
+	return meta, nil
+}
+
+// VerifyBackup checks the backup at path against the checksum in its metadata
+func VerifyBackup(path string, meta *BackupMetadata) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %s", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %s", err)
+	}
+	if size != meta.Size || hex.EncodeToString(hash.Sum(nil)) != meta.SHA256 {
+		return fmt.Errorf("backup %s doesn't match the checksum in its metadata, it may be incomplete or corrupt", path)
+	}
+	return nil
+}
+
+// Restore restores the backup read from r to the named service instance.
+// The backup must be of the same service type. If the service instance
+// isn't empty, confirm must be its name.
+func (a *App) Restore(instanceName string, r io.Reader, meta *BackupMetadata, confirm string) error {
+	ti, err := a.instanceNamed(instanceName)
+	if err != nil {
+		return err
+	}
+	if meta.Service != "" && meta.Service != ti.serviceType {
+		return fmt.Errorf(
+			"can't restore a %s backup to %s, which is a %s service instance",
+			meta.Service, instanceName, ti.serviceType,
+		)
+	}
+	provider, ok := a.serviceProviders[ti.serviceType].(RestoreProvider)
+	if !ok {
+		return fmt.Errorf("restoring %s service instances is not supported", ti.serviceType)
+	}
+	env, err := a.instanceEnv(ti)
+	if err != nil {
+		return err
+	}
+
+	a.status.Text("Checking", instanceName, "is empty")
+	count, err := a.countObjects(ti, provider, env)
+	if err != nil {
+		return err
+	}
+	if count > 0 && confirm != instanceName {
+		return fmt.Errorf(
+			"%s is not empty (it has %d tables), use --confirm %s to restore over it",
+			instanceName, count, instanceName,
+		)
+	}
+	args, err := provider.RestoreCommand(ti.instance.Credentials, meta.Format, count > 0)
+	if err != nil {
+		return err
+	}
+
+	decompressor, err := util.NewDecompressor(r, meta.Compression)
+	if err != nil {
+		return err
+	}
+	defer decompressor.Close()
+
+	a.status.Text("Restoring", instanceName, "with", args[0])
+	a.status.Event("restore_started", util.Fields{"instance": instanceName, "tool": args[0]})
+
+	exitCode, err := a.runCommand(command{
+		args:   args,
+		env:    env,
+		fields: util.Fields{"instance": instanceName},
+		stdin:  decompressor,
+	})
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("%s failed with exit code %d", strings.Join(a.redactArgs(args), " "), exitCode)
+	}
+
+	a.status.Event("restore_finished", util.Fields{"instance": instanceName})
+	return nil
+}
+
+func (a *App) countObjects(ti *tunnelledInstance, provider RestoreProvider, env map[string]string) (int, error) {
+	args := provider.CountCommand(ti.instance.Credentials)
+	out := &bytes.Buffer{}
+	exitCode, err := a.runCommand(command{
+		args:   args,
+		env:    env,
+		fields: util.Fields{"instance": ti.instance.InstanceName},
+		stdin:  strings.NewReader(""),
+		stdout: out,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if exitCode != 0 {
+		return 0, fmt.Errorf("failed to check whether %s is empty: %s exited with code %d", ti.instance.InstanceName, args[0], exitCode)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(out.String()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to check whether %s is empty: unexpected output from %s: %q", ti.instance.InstanceName, args[0], out.String())
+	}
+	return count, nil
+}
@@ -0,0 +1,169 @@
+//go:build !windows
+
+package conduit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/service"
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+// fakeRestoreProvider "restores" by copying stdin to a file
+type fakeRestoreProvider struct {
+	service.Postgres
+	restoredTo string
+	count      string
+	clean      bool
+}
+
+func (f *fakeRestoreProvider) RestoreCommand(creds client.Credentials, format string, clean bool) ([]string, error) {
+	f.clean = clean
+	return []string{"/bin/sh", "-c", `cat > "$1"`, "fake-restore", f.restoredTo}, nil
+}
+
+func (f *fakeRestoreProvider) CountCommand(creds client.Credentials) []string {
+	return []string{"/bin/sh", "-c", `echo "$1"`, "fake-count", f.count}
+}
+
+var _ = Describe("Restore()", func() {
+	var (
+		app      *App
+		provider *fakeRestoreProvider
+		meta     *BackupMetadata
+	)
+
+	BeforeEach(func() {
+		provider = &fakeRestoreProvider{
+			restoredTo: filepath.Join(GinkgoT().TempDir(), "restored"),
+			count:      "0",
+		}
+		app = &App{
+			status:           util.NewStatus(GinkgoWriter, true),
+			runEnv:           map[string]string{},
+			serviceProviders: map[string]ServiceProvider{},
+			gracePeriod:      DefaultGracePeriod,
+			instances: []tunnelledInstance{{
+				serviceType: "postgres",
+				instance: &client.VcapService{
+					InstanceName: "my-db",
+					Credentials:  client.Credentials{"host": "127.0.0.1", "port": "7080"},
+				},
+			}},
+		}
+		app.RegisterServiceProvider("postgres", provider)
+		app.RegisterServiceProvider("influxdb", &service.InfluxDB{})
+		meta = &BackupMetadata{Service: "postgres", Format: "fake", Compression: "none"}
+	})
+
+	It("restores the backup to an empty service instance", func() {
+		Expect(app.Restore("my-db", strings.NewReader("backup data"), meta, "")).To(Succeed())
+		Expect(os.ReadFile(provider.restoredTo)).To(Equal([]byte("backup data")))
+		Expect(provider.clean).To(BeFalse())
+	})
+
+	It("decompresses the backup", func() {
+		buf := &bytes.Buffer{}
+		w, err := util.NewCompressor(buf, "zstd")
+		Expect(err).NotTo(HaveOccurred())
+		w.Write([]byte("backup data"))
+		Expect(w.Close()).To(Succeed())
+		meta.Compression = "zstd"
+
+		Expect(app.Restore("my-db", buf, meta, "")).To(Succeed())
+		Expect(os.ReadFile(provider.restoredTo)).To(Equal([]byte("backup data")))
+	})
+
+	It("refuses to restore over a service instance which isn't empty", func() {
+		provider.count = "3"
+		err := app.Restore("my-db", strings.NewReader("backup data"), meta, "")
+		Expect(err).To(MatchError("my-db is not empty (it has 3 tables), use --confirm my-db to restore over it"))
+		Expect(provider.restoredTo).NotTo(BeAnExistingFile())
+
+		err = app.Restore("my-db", strings.NewReader("backup data"), meta, "other-db")
+		Expect(err).To(HaveOccurred())
+		Expect(provider.restoredTo).NotTo(BeAnExistingFile())
+	})
+
+	It("restores over a service instance which isn't empty when confirmed", func() {
+		provider.count = "3"
+		Expect(app.Restore("my-db", strings.NewReader("backup data"), meta, "my-db")).To(Succeed())
+		Expect(os.ReadFile(provider.restoredTo)).To(Equal([]byte("backup data")))
+		Expect(provider.clean).To(BeTrue())
+	})
+
+	It("has pg_restore drop what's in the way when restoring over a postgres instance", func() {
+		creds := client.Credentials{"name": "app_db"}
+		args, err := (&service.Postgres{}).RestoreCommand(creds, "pg_dump-custom", false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(args).To(Equal([]string{
+			"pg_restore", "--no-owner", "--no-acl", "--exit-on-error", "--single-transaction",
+			"--dbname", "app_db",
+		}))
+
+		args, err = (&service.Postgres{}).RestoreCommand(creds, "pg_dump-custom", true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(args).To(Equal([]string{
+			"pg_restore", "--no-owner", "--no-acl", "--exit-on-error", "--single-transaction",
+			"--clean", "--if-exists", "--dbname", "app_db",
+		}))
+	})
+
+	It("refuses to restore a backup of a different service type", func() {
+		meta.Service = "mysql"
+		err := app.Restore("my-db", strings.NewReader("backup data"), meta, "my-db")
+		Expect(err).To(MatchError("can't restore a mysql backup to my-db, which is a postgres service instance"))
+	})
+
+	It("fails for a service type which can't be restored", func() {
+		app.instances[0].serviceType = "influxdb"
+		meta.Service = "influxdb"
+		err := app.Restore("my-db", strings.NewReader("backup data"), meta, "")
+		Expect(err).To(MatchError("restoring influxdb service instances is not supported"))
+	})
+
+	It("fails if the count can't be read", func() {
+		provider.count = "ERROR: permission denied"
+		err := app.Restore("my-db", strings.NewReader("backup data"), meta, "")
+		Expect(err).To(MatchError(ContainSubstring("failed to check whether my-db is empty")))
+	})
+})
+
+var _ = Describe("VerifyBackup()", func() {
+	var (
+		path string
+		meta *BackupMetadata
+	)
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "backup.dump")
+		Expect(os.WriteFile(path, []byte("backup data"), 0600)).To(Succeed())
+		meta = &BackupMetadata{
+			Size:   11,
+			SHA256: "d9c38b4a49e99d9a64a34bfec2d42ee152283003487e13460a1d6de6fb853473",
+		}
+	})
+
+	It("reads the metadata written by WriteBackupMetadata", func() {
+		Expect(WriteBackupMetadata(path, meta)).To(Succeed())
+		read, err := ReadBackupMetadata(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read.SHA256).To(Equal(meta.SHA256))
+	})
+
+	It("passes if the backup matches its checksum", func() {
+		Expect(VerifyBackup(path, meta)).To(Succeed())
+	})
+
+	It("fails if the backup doesn't match its checksum", func() {
+		Expect(os.WriteFile(path, []byte("backup dat"), 0600)).To(Succeed())
+		Expect(VerifyBackup(path, meta)).To(MatchError(ContainSubstring("doesn't match the checksum")))
+	})
+})
@@ -0,0 +1,117 @@
+package conduit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/alphagov/paas-cf-conduit/util"
+	"github.com/fatih/color"
+)
+
+// ParseScript reads a script of commands to run against the tunnels, one
+// per line. Words are split and quoted as they would be by a shell, but
+// there's no shell syntax beyond that. Blank lines and lines starting with
+// # are ignored.
+func ParseScript(r io.Reader) ([][]string, error) {
+	commands := [][]string{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words, err := util.SplitCommandLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("script line %d: %s", lineNumber, err)
+		}
+		commands = append(commands, words)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read script: %s", err)
+	}
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("script has no commands")
+	}
+	return commands, nil
+}
+
+// SetScript sets the commands to run with RunScript. It must be called
+// before the app is deployed so that each program's environment is set up.
+func (a *App) SetScript(commands [][]string) {
+	a.script = commands
+}
+
+type scriptStep struct {
+	command  string
+	exitCode int
+	err      error
+	skipped  bool
+}
+
+// RunScript runs each command set by SetScript in turn against the same
+// tunnels. It stops at the first command which fails unless continueOnError
+// is set, and always stops if a command is interrupted, whether the signal
+// was sent to conduit or only to the command. A summary of every step is
+// printed at the end.
+func (a *App) RunScript(continueOnError bool) error {
+	steps := make([]scriptStep, len(a.script))
+	var failed *scriptStep
+	stopped := false
+
+	for i, args := range a.script {
+		step := &steps[i]
+		step.command = strings.Join(a.redactArgs(args), " ")
+		if stopped {
+			step.skipped = true
+			continue
+		}
+
+		step.exitCode, step.err = a.runCommand(command{
+			args:      args,
+			extraArgs: a.getProgramSpecificArgs(args[0]),
+			fields:    util.Fields{"step": i + 1},
+		})
+		if step.err == nil && step.exitCode == 0 {
+			continue
+		}
+		if failed == nil {
+			failed = step
+		}
+		if !continueOnError || a.interrupted || interruptedExitCode(step.exitCode) {
+			stopped = true
+		}
+	}
+
+	printScriptSummary(os.Stderr, steps)
+
+	if failed == nil {
+		return nil
+	}
+	if failed.err != nil {
+		return failed.err
+	}
+	return AppExecution{ExitCode: failed.exitCode}
+}
+
+func printScriptSummary(w io.Writer, steps []scriptStep) {
+	fmt.Fprintf(w, "\nScript summary:\n\n")
+	for i, step := range steps {
+		var result string
+		switch {
+		case step.skipped:
+			result = color.YellowString("SKIPPED")
+		case step.err != nil:
+			result = color.RedString("ERROR") + " " + step.err.Error()
+		case step.exitCode != 0:
+			result = color.RedString("FAILED") + fmt.Sprintf(" (exit code %d)", step.exitCode)
+		default:
+			result = color.GreenString("OK")
+		}
+		fmt.Fprintf(w, "%d. %s: %s\n", i+1, step.command, result)
+	}
+}
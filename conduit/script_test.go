@@ -0,0 +1,36 @@
+package conduit_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/conduit"
+)
+
+var _ = Describe("ParseScript", func() {
+	It("reads one command per line, skipping blank lines and comments", func() {
+		commands, err := conduit.ParseScript(strings.NewReader(`
+# nightly export
+pg_dump -Fc -f 'nightly backup.dump'
+
+  psql -c "select count(*) from users"
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(commands).To(Equal([][]string{
+			{"pg_dump", "-Fc", "-f", "nightly backup.dump"},
+			{"psql", "-c", "select count(*) from users"},
+		}))
+	})
+
+	It("reports the line of a parse error", func() {
+		_, err := conduit.ParseScript(strings.NewReader("psql\npsql -c 'select 1\n"))
+		Expect(err).To(MatchError(ContainSubstring("script line 2: unterminated single quote")))
+	})
+
+	It("fails if there are no commands", func() {
+		_, err := conduit.ParseScript(strings.NewReader("# nothing to do\n\n"))
+		Expect(err).To(MatchError("script has no commands"))
+	})
+})
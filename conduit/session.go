@@ -0,0 +1,273 @@
+package conduit
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/util"
+	"github.com/cloudfoundry/multierror"
+
+	gocfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+// Session is a journal of what a conduit process has created, written to disk
+// as each thing is created so that it can be torn down by a later conduit
+// process if this one dies or fails to tear it down itself
+type Session struct {
+	ID           string    `json:"id"`
+	PID          int       `json:"pid"`
+	StartedAt    time.Time `json:"started_at"`
+	API          string    `json:"api"`
+	Org          string    `json:"org"`
+	Space        string    `json:"space"`
+	AppName      string    `json:"app_name"`
+	AppGUID      string    `json:"app_guid,omitempty"`
+	DeleteApp    bool      `json:"delete_app"`
+	BindingGUIDs []string  `json:"binding_guids,omitempty"`
+	TempDirs     []string  `json:"temp_dirs,omitempty"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// WorkDirProvider is a ServiceProvider which creates temporary directories,
+// which are recorded in the session so they can be removed if it's torn down
+// by another process
+type WorkDirProvider interface {
+	WorkDirs() []string
+}
+
+// SetSession sets the session that everything the app creates is recorded in
+func (a *App) SetSession(s *Session) {
+	a.session = s
+}
+
+// journal updates the app's session, if it has one. Failing to write it
+// isn't fatal, it only matters if conduit doesn't get to tear down.
+func (a *App) journal(fn func(s *Session)) {
+	if a.session == nil {
+		return
+	}
+	if err := a.session.Update(fn); err != nil {
+		logger.Warn("failed to write session:", err)
+	}
+}
+
+// initEnv sets up the environment for a service provider, recording any
+// temporary directories it creates in the session
+func (a *App) initEnv(provider ServiceProvider, creds client.Credentials, env map[string]string) error {
+	err := provider.InitEnv(creds, env)
+	if p, ok := provider.(WorkDirProvider); ok {
+		a.journal(func(s *Session) {
+			s.TempDirs = mergeStrings(s.TempDirs, p.WorkDirs())
+		})
+	}
+	return err
+}
+
+// tempDir creates a temporary directory which is removed when the app is
+// torn down, recording it in the session in case it isn't
+func (a *App) tempDir() (string, error) {
+	dir, err := ioutil.TempDir("", "conduit-")
+	if err != nil {
+		return "", err
+	}
+	a.tempDirs = append(a.tempDirs, dir)
+	a.journal(func(s *Session) {
+		s.TempDirs = mergeStrings(s.TempDirs, []string{dir})
+	})
+	return dir, nil
+}
+
+// removeTempDirs removes the app's temporary directories once the tunnels
+// have stopped
+func (a *App) removeTempDirs() error {
+	for _, dir := range a.tempDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeStrings appends the strings in b which aren't already in a
+func mergeStrings(a []string, b []string) []string {
+	seen := map[string]bool{}
+	for _, s := range a {
+		seen[s] = true
+	}
+	for _, s := range b {
+		if !seen[s] {
+			a = append(a, s)
+			seen[s] = true
+		}
+	}
+	return a
+}
+
+// ConfigDir is where conduit keeps its state, alongside the cf CLI's config
+func ConfigDir() (string, error) {
+	home := os.Getenv("CF_HOME")
+	if home == "" {
+		var err error
+		home, err = os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(home, ".cf", "conduit"), nil
+}
+
+// DefaultSessionDir is where sessions are kept
+func DefaultSessionDir() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sessions"), nil
+}
+
+// NewSession returns the session for this process, which isn't written until
+// there's something in it
+func NewSession(dir, api, org, space, appName string, deleteApp bool) *Session {
+	startedAt := time.Now()
+	// one process can have more than one session, e.g. when copying
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	id := fmt.Sprintf("%s-%d-%x", startedAt.UTC().Format("20060102T150405"), os.Getpid(), suffix)
+	return &Session{
+		ID:        id,
+		PID:       os.Getpid(),
+		StartedAt: startedAt,
+		API:       api,
+		Org:       org,
+		Space:     space,
+		AppName:   appName,
+		DeleteApp: deleteApp,
+		path:      filepath.Join(dir, id+".json"),
+	}
+}
+
+// LoadSessions reads every session in dir, oldest first
+func LoadSessions(dir string) ([]*Session, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []*Session{}
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s := &Session{path: path}
+		if err := json.Unmarshal(b, s); err != nil {
+			return nil, fmt.Errorf("failed to read session %s: %s", path, err)
+		}
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+	return sessions, nil
+}
+
+// Stale returns whether the process which the session belongs to has exited
+func (s *Session) Stale() bool {
+	return s.PID != os.Getpid() && !processAlive(s.PID)
+}
+
+// Update changes the session and writes it to disk
+func (s *Session) Update(fn func(s *Session)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s)
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	// written to a temporary file and renamed, so that it's never half written
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Remove deletes the session once everything in it has been torn down
+func (s *Session) Remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Empty returns whether the session has nothing to tear down, e.g. because
+// the app was an existing one
+func (s *Session) Empty() bool {
+	return !(s.DeleteApp && s.AppGUID != "") && len(s.TempDirs) == 0
+}
+
+// Describe returns a summary of what the session has to tear down
+func (s *Session) Describe() string {
+	things := []string{}
+	if s.DeleteApp && s.AppGUID != "" {
+		things = append(things, fmt.Sprintf("app %s in %s/%s with %d bindings", s.AppName, s.Org, s.Space, len(s.BindingGUIDs)))
+	}
+	if len(s.TempDirs) > 0 {
+		things = append(things, fmt.Sprintf("%d temporary directories", len(s.TempDirs)))
+	}
+	return fmt.Sprintf("session %s (started %s): %s", s.ID, s.StartedAt.Format(time.RFC3339), strings.Join(things, ", "))
+}
+
+// RecoverSession finishes tearing down a stale session: the app's bindings
+// are deleted and then the app itself (unless it wasn't created by conduit
+// or --no-delete was given), and its temporary directories are removed
+func RecoverSession(cfClient client.Client, status *util.Status, s *Session) error {
+	errs := &multierror.MultiError{}
+
+	if s.DeleteApp && s.AppGUID != "" {
+		app := OrphanedApp{
+			AppSummary:   client.AppSummary{Guid: s.AppGUID, Name: s.AppName},
+			bindingGUIDs: s.BindingGUIDs,
+		}
+		if err := RemoveOrphanedApp(cfClient, status, app); err != nil {
+			errs.Add(err)
+		}
+	}
+
+	for _, dir := range s.TempDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			errs.Add(err)
+		}
+	}
+
+	if len(errs.Errors) > 0 {
+		return errs
+	}
+	return s.Remove()
+}
+
+// isNotFound returns whether the error is the API saying something has
+// already been deleted
+func isNotFound(err error) bool {
+	return gocfclient.IsResourceNotFoundError(err) ||
+		gocfclient.IsAppNotFoundError(err) ||
+		gocfclient.IsServiceBindingNotFoundError(err)
+}
@@ -0,0 +1,182 @@
+//go:build !windows
+
+package conduit
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	gocfclient "github.com/cloudfoundry-community/go-cfclient"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/client/clientfakes"
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+var _ = Describe("Session", func() {
+	var (
+		dir        string
+		session    *Session
+		fakeClient *clientfakes.FakeClient
+		status     *util.Status
+	)
+
+	deadPID := func() int {
+		cmd := exec.Command("true")
+		Expect(cmd.Run()).To(Succeed())
+		return cmd.Process.Pid
+	}
+
+	readSession := func() map[string]interface{} {
+		b, err := os.ReadFile(session.path)
+		Expect(err).NotTo(HaveOccurred())
+		fields := map[string]interface{}{}
+		Expect(json.Unmarshal(b, &fields)).To(Succeed())
+		return fields
+	}
+
+	BeforeEach(func() {
+		dir = filepath.Join(GinkgoT().TempDir(), "sessions")
+		session = NewSession(dir, "https://api.example.com", "my-org", "my-space", "__conduit_test__", true)
+		fakeClient = &clientfakes.FakeClient{}
+		status = util.NewStatus(GinkgoWriter, true)
+	})
+
+	It("isn't written until it's updated", func() {
+		Expect(dir).NotTo(BeAnExistingFile())
+
+		Expect(session.Update(func(s *Session) { s.AppGUID = "app-guid" })).To(Succeed())
+
+		info, err := os.Stat(session.path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		Expect(readSession()).To(HaveKeyWithValue("app_guid", "app-guid"))
+		Expect(readSession()).To(HaveKeyWithValue("pid", BeNumerically("==", os.Getpid())))
+	})
+
+	It("can be loaded by another process", func() {
+		Expect(session.Update(func(s *Session) { s.TempDirs = []string{"/tmp/a"} })).To(Succeed())
+		other := NewSession(dir, "https://api.example.com", "my-org", "my-space", "__conduit_other__", true)
+		Expect(other.Update(func(s *Session) {})).To(Succeed())
+
+		sessions, err := LoadSessions(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sessions).To(HaveLen(2))
+		Expect(sessions[0].ID).To(Equal(session.ID))
+		Expect(sessions[0].TempDirs).To(Equal([]string{"/tmp/a"}))
+		Expect(sessions[1].AppName).To(Equal("__conduit_other__"))
+	})
+
+	It("is stale once its process has exited", func() {
+		Expect(session.Stale()).To(BeFalse())
+		session.PID = deadPID()
+		Expect(session.Stale()).To(BeTrue())
+	})
+
+	It("is empty if it has nothing to tear down", func() {
+		Expect(session.Empty()).To(BeTrue())
+		session.AppGUID = "app-guid"
+		Expect(session.Empty()).To(BeFalse())
+		session.DeleteApp = false
+		Expect(session.Empty()).To(BeTrue())
+		session.TempDirs = []string{"/tmp/a"}
+		Expect(session.Empty()).To(BeFalse())
+	})
+
+	Describe("RecoverSession()", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			tempDir = GinkgoT().TempDir()
+			Expect(session.Update(func(s *Session) {
+				s.AppGUID = "app-guid"
+				s.BindingGUIDs = []string{"binding-1", "binding-2"}
+				s.TempDirs = []string{tempDir}
+			})).To(Succeed())
+		})
+
+		It("unbinds and deletes the app, and removes the temporary directories and the session", func() {
+			Expect(RecoverSession(fakeClient, status, session)).To(Succeed())
+
+			Expect(fakeClient.DeleteServiceBindingCallCount()).To(Equal(2))
+			Expect(fakeClient.DeleteServiceBindingArgsForCall(0)).To(Equal("binding-1"))
+			Expect(fakeClient.DeleteServiceBindingArgsForCall(1)).To(Equal("binding-2"))
+			Expect(fakeClient.DestroyAppArgsForCall(0)).To(Equal("app-guid"))
+			Expect(tempDir).NotTo(BeAnExistingFile())
+			Expect(session.path).NotTo(BeAnExistingFile())
+		})
+
+		It("doesn't delete an app it didn't create", func() {
+			session.DeleteApp = false
+			Expect(RecoverSession(fakeClient, status, session)).To(Succeed())
+
+			Expect(fakeClient.DeleteServiceBindingCallCount()).To(Equal(0))
+			Expect(fakeClient.DestroyAppCallCount()).To(Equal(0))
+			Expect(tempDir).NotTo(BeAnExistingFile())
+		})
+
+		It("succeeds if the app has already been deleted", func() {
+			fakeClient.DeleteServiceBindingReturns(gocfclient.CloudFoundryError{Code: 90004})
+			fakeClient.DestroyAppReturns(gocfclient.CloudFoundryError{Code: 10010})
+
+			Expect(RecoverSession(fakeClient, status, session)).To(Succeed())
+			Expect(session.path).NotTo(BeAnExistingFile())
+		})
+
+		It("keeps the session if the app can't be deleted", func() {
+			fakeClient.DestroyAppReturns(gocfclient.CloudFoundryError{Code: 10011, Description: "database error"})
+
+			Expect(RecoverSession(fakeClient, status, session)).To(MatchError(ContainSubstring("failed to delete __conduit_test__")))
+			Expect(session.path).To(BeAnExistingFile())
+		})
+	})
+
+	Describe("journalling an app", func() {
+		var app *App
+
+		BeforeEach(func() {
+			fakeClient.CreateAppReturns("app-guid", nil)
+			fakeClient.GetServiceInstancesReturns(map[string]*gocfclient.ServiceInstance{
+				"instance-guid": {Guid: "instance-guid", Name: "my-db"},
+			}, nil)
+			fakeClient.BindServiceReturns(&client.Credentials{"host": "db.internal", "port": "5432"}, "binding-guid", nil)
+
+			app = NewApp(
+				fakeClient, status,
+				7080, "my-org", "my-space", "__conduit_test__", true,
+				[]string{"my-db"}, nil, nil, false, nil, 0,
+			)
+			app.space = &gocfclient.Space{Name: "my-space", Guid: "space-guid"}
+			app.SetSession(session)
+			DeferCleanup(app.stopRenewing)
+		})
+
+		It("records the app and its bindings as they're created", func() {
+			Expect(app.DeployApp()).To(Succeed())
+
+			Expect(readSession()).To(HaveKeyWithValue("app_guid", "app-guid"))
+			Expect(readSession()).To(HaveKeyWithValue("binding_guids", ConsistOf("binding-guid")))
+		})
+
+		It("removes the session once everything has been torn down", func() {
+			Expect(app.DeployApp()).To(Succeed())
+			Expect(app.Teardown()).To(Succeed())
+
+			Expect(session.path).NotTo(BeAnExistingFile())
+		})
+
+		It("keeps the session if teardown fails", func() {
+			fakeClient.DestroyAppReturns(gocfclient.CloudFoundryError{Code: 10011})
+			fakeClient.RefreshAccessTokenReturns(gocfclient.CloudFoundryError{Code: 10011})
+			Expect(app.DeployApp()).To(Succeed())
+			Expect(app.Teardown()).NotTo(Succeed())
+
+			Expect(session.path).To(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,514 @@
+package conduit
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/util"
+
+	gocfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+// SharedAppName is the name of the app shared by everyone using --shared in
+// a space
+const SharedAppName = "__conduit_shared__"
+
+// sharedLockAppName is the name of the app which exists while someone is
+// creating, binding, starting or stopping the shared app. App names are
+// unique in a space, so only one conduit process can create it.
+const sharedLockAppName = "__conduit_shared_lock__"
+
+const (
+	// LockAnnotation on the lock app says who holds the lock
+	LockAnnotation = "conduit.alphagov/lock"
+
+	// leaseAnnotationPrefix is followed by the ID of each conduit process
+	// using the shared app
+	leaseAnnotationPrefix = "conduit.alphagov/lease-"
+)
+
+var (
+	// LeaseDuration is how long a lease on the shared app lasts if the
+	// conduit process holding it stops renewing it, e.g. because it was
+	// killed
+	LeaseDuration = 5 * time.Minute
+
+	// the lock is only held for as long as it takes to create, bind and
+	// start or unbind and stop the app
+	lockDuration = 5 * time.Minute
+	lockTimeout  = 6 * time.Minute
+	lockRetry    = 2 * time.Second
+)
+
+// Lease is a conduit process's claim on the shared app, which stops it from
+// being stopped or having the service instances the process uses unbound
+type Lease struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner,omitempty"`
+	Hostname  string    `json:"hostname,omitempty"`
+	Instances []string  `json:"instances,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired returns whether the lease has run out
+func (l Lease) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+// Holder says who holds the lease
+func (l Lease) Holder() string {
+	switch {
+	case l.Owner != "" && l.Hostname != "":
+		return l.Owner + " on " + l.Hostname
+	case l.Owner != "":
+		return l.Owner
+	case l.Hostname != "":
+		return l.Hostname
+	}
+	return "another conduit"
+}
+
+// IsSharedApp returns whether an app is the one shared by everyone using
+// --shared in its space
+func IsSharedApp(app client.AppSummary) bool {
+	return app.Metadata.Labels[SharedLabel] == "true" || app.Name == SharedAppName
+}
+
+// SharedLeases returns the leases on the shared app, soonest to expire first.
+// Annotations which can't be read are left out.
+func SharedLeases(metadata client.Metadata) []Lease {
+	leases := []Lease{}
+	for key, value := range metadata.Annotations {
+		if !strings.HasPrefix(key, leaseAnnotationPrefix) {
+			continue
+		}
+		var lease Lease
+		if err := json.Unmarshal([]byte(value), &lease); err != nil {
+			logger.Debug("ignoring invalid lease", key+":", err)
+			continue
+		}
+		leases = append(leases, lease)
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].ExpiresAt.Before(leases[j].ExpiresAt)
+	})
+	return leases
+}
+
+// SetShared makes the app the one shared by everyone using --shared in the
+// space, which is kept between runs rather than created and deleted
+func (a *App) SetShared(shared bool) {
+	a.shared = shared
+	if shared && a.leaseID == "" {
+		id := make([]byte, 8)
+		rand.Read(id)
+		a.leaseID = fmt.Sprintf("%x", id)
+	}
+}
+
+// DeploySharedApp prepares the shared app with it locked, creating it the
+// first time. A lease is taken on it, which is renewed until Teardown, any
+// service instances which aren't already bound to it are bound and it's
+// started if it isn't running.
+func (a *App) DeploySharedApp() error {
+	if err := a.lockSharedApp(); err != nil {
+		return err
+	}
+	defer a.unlockSharedApp()
+
+	a.status.Text("Fetching information about app", a.appName)
+	app, err := a.sharedApp()
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		if err := a.deployApp(); err != nil {
+			// no one else can have found it while it's locked, so it's left
+			// for the next run to create rather than half deployed
+			if a.appGUID != "" {
+				if destroyErr := a.cfClient.DestroyApp(a.appGUID); destroyErr != nil {
+					logger.Warn("failed to delete", a.appName, "after failing to deploy it:", destroyErr)
+				}
+				a.appGUID = ""
+			}
+			return err
+		}
+	} else {
+		a.appGUID = app.Guid
+		a.status.Event("app_found", util.Fields{"app": a.appName, "app_guid": a.appGUID})
+	}
+
+	if err := a.renewLease(); err != nil {
+		return fmt.Errorf("failed to take a lease on %s: %s", a.appName, err)
+	}
+	a.status.Event("lease_acquired", util.Fields{"app": a.appName, "app_guid": a.appGUID, "lease_id": a.leaseID})
+	a.leaseStop = make(chan struct{})
+	a.leaseDone = make(chan struct{})
+	go a.renewLeases(a.leaseStop, a.leaseDone, a.renewLease)
+
+	if err := a.bindSharedServices(); err != nil {
+		return err
+	}
+
+	app, err = a.sharedApp()
+	if err != nil {
+		return err
+	}
+	if app != nil && app.State != "STARTED" {
+		a.status.Text("Starting", a.appName)
+		if err := a.cfClient.StartApp(a.appGUID); err != nil {
+			return err
+		}
+		a.status.Text("Waiting for conduit app to become available")
+		if err := a.cfClient.PollForAppState(a.appGUID, "STARTED", 15); err != nil {
+			return err
+		}
+		a.status.Event("app_started", util.Fields{"app": a.appName, "app_guid": a.appGUID})
+	}
+	return nil
+}
+
+// sharedApp returns the shared app in the space, or nil if there isn't one
+func (a *App) sharedApp() (*client.AppSummary, error) {
+	return findApp(a.cfClient, a.space.Guid, a.appName)
+}
+
+// findApp returns the app in the space with this name, or nil if there
+// isn't one
+func findApp(cfClient client.Client, spaceGUID string, name string) (*client.AppSummary, error) {
+	apps, err := cfClient.ListApps(url.Values{
+		"space_guids": {spaceGUID},
+		"names":       {name},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range apps {
+		if app.Name == name {
+			return &app, nil
+		}
+	}
+	return nil, nil
+}
+
+// sharedAppMetadata returns the labels and annotations of the shared app
+func (a *App) sharedAppMetadata() (client.Metadata, error) {
+	app, err := a.sharedApp()
+	if err != nil {
+		return client.Metadata{}, err
+	}
+	if app == nil {
+		return client.Metadata{}, fmt.Errorf("app %s was deleted", a.appName)
+	}
+	return app.Metadata, nil
+}
+
+// lease is this process's lease on the shared app
+func (a *App) lease(expiresAt time.Time) Lease {
+	return Lease{
+		ID:        a.leaseID,
+		Owner:     a.owner.User,
+		Hostname:  a.owner.Hostname,
+		Instances: a.serviceInstanceNames,
+		ExpiresAt: expiresAt.UTC(),
+	}
+}
+
+// writeAnnotation sets an annotation on the shared app to a lease
+func (a *App) writeAnnotation(key string, lease Lease) error {
+	value, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	return a.cfClient.UpdateAppMetadata(a.appGUID, client.Metadata{
+		Annotations: map[string]string{key: string(value)},
+	})
+}
+
+// renewLease takes or extends this process's lease on the shared app
+func (a *App) renewLease() error {
+	return a.writeAnnotation(leaseAnnotationPrefix+a.leaseID, a.lease(time.Now().Add(LeaseDuration)))
+}
+
+// renewLeases calls renew until stop is closed, well before the lease it
+// renews expires so that a failed renewal can be retried
+func (a *App) renewLeases(stop <-chan struct{}, done chan<- struct{}, renew func() error) {
+	defer close(done)
+	ticker := time.NewTicker(LeaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := renew(); err != nil {
+				logger.Warn("failed to renew the lease on", a.appName+":", err)
+			}
+		}
+	}
+}
+
+// stopRenewing stops renewing the lease on the app, if it's being renewed
+func (a *App) stopRenewing() {
+	if a.leaseStop != nil {
+		close(a.leaseStop)
+		<-a.leaseDone
+		a.leaseStop = nil
+	}
+}
+
+// lockSharedApp waits for anyone else who is changing the shared app to
+// finish, and then locks it
+func (a *App) lockSharedApp() error {
+	guid, err := lockSharedSpace(a.cfClient, a.status, a.space.Guid, a.lease(time.Time{}))
+	if err != nil {
+		return err
+	}
+	a.lockGUID = guid
+	return nil
+}
+
+// unlockSharedApp releases the lock, unless it was broken by someone else
+// after it expired
+func (a *App) unlockSharedApp() {
+	if a.lockGUID == "" {
+		return
+	}
+	unlockSharedSpace(a.cfClient, a.lockGUID)
+	a.lockGUID = ""
+}
+
+// lockSharedSpace locks the shared app in a space by creating the lock app,
+// returning its GUID. Whoever fails to create it because the name is taken
+// waits for it to be deleted, or deletes it once it has expired.
+func lockSharedSpace(cfClient client.Client, status *util.Status, spaceGUID string, holder Lease) (string, error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		guid, err := cfClient.CreateApp(sharedLockAppName, spaceGUID)
+		if err == nil {
+			holder.ExpiresAt = time.Now().Add(lockDuration).UTC()
+			value, err := json.Marshal(holder)
+			if err != nil {
+				return "", err
+			}
+			// until this is written the lock expires lockDuration after it
+			// was created
+			if err := cfClient.UpdateAppMetadata(guid, client.Metadata{
+				Labels:      map[string]string{ManagedLabel: "true"},
+				Annotations: map[string]string{LockAnnotation: string(value)},
+			}); err != nil {
+				logger.Warn("failed to say who holds the lock on", SharedAppName+":", err)
+			}
+			return guid, nil
+		}
+		if !gocfclient.IsAppNameTakenError(err) {
+			return "", fmt.Errorf("failed to lock %s: %s", SharedAppName, err)
+		}
+
+		lock, err := findApp(cfClient, spaceGUID, sharedLockAppName)
+		if err != nil {
+			return "", err
+		}
+		if lock == nil {
+			// it was unlocked in the meantime
+			continue
+		}
+		current := lockHolder(*lock)
+		if current.Expired(time.Now()) {
+			// it's deleted by GUID, so that a lock someone else has taken
+			// since isn't
+			logger.Debug("breaking the lock on", SharedAppName, "held by", current.Holder(), "which expired at", current.ExpiresAt)
+			if err := cfClient.DestroyApp(lock.Guid); err != nil && !isNotFound(err) {
+				return "", fmt.Errorf("failed to break the expired lock on %s: %s", SharedAppName, err)
+			}
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("%s is locked by %s until %s, try again later", SharedAppName, current.Holder(), current.ExpiresAt.Local().Format(time.RFC3339))
+		}
+		status.Text("Waiting for", current.Holder(), "to finish with", SharedAppName)
+		time.Sleep(lockRetry)
+	}
+}
+
+// unlockSharedSpace deletes the lock app
+func unlockSharedSpace(cfClient client.Client, guid string) {
+	if err := cfClient.DestroyApp(guid); err != nil && !isNotFound(err) {
+		logger.Warn("failed to unlock", SharedAppName+":", err)
+	}
+}
+
+// lockHolder returns who holds the lock, from the lock app
+func lockHolder(lock client.AppSummary) Lease {
+	if value, ok := lock.Metadata.Annotations[LockAnnotation]; ok {
+		var holder Lease
+		if err := json.Unmarshal([]byte(value), &holder); err == nil {
+			return holder
+		}
+		logger.Debug("ignoring invalid lock on shared app:", value)
+	}
+	// whoever created it hasn't said who they are yet, or never will
+	return Lease{ExpiresAt: lock.CreatedAt.Add(lockDuration)}
+}
+
+// bindSharedServices binds the service instances which aren't already bound
+// to the shared app. The bindings are left for whoever stops using them last
+// to delete.
+func (a *App) bindSharedServices() error {
+	a.status.Text("Fetching binding infomation")
+	serviceBindings, err := a.cfClient.GetServiceBindings(
+		fmt.Sprintf("app_guid:%s", a.appGUID),
+	)
+	if err != nil {
+		return err
+	}
+
+	a.status.Text("Fetching service infomation")
+	serviceInstances, err := a.cfClient.GetServiceInstances(
+		fmt.Sprintf("space_guid:%s", a.space.Guid),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range a.serviceInstanceNames {
+		found := false
+		for serviceInstanceGUID, serviceInstance := range serviceInstances {
+			if name != serviceInstance.Name {
+				continue
+			}
+			found = true
+			if _, ok := serviceBindings[serviceInstanceGUID]; ok {
+				logger.Debug(name, "is already bound to", a.appName)
+				continue
+			}
+			a.status.Text("Binding", name)
+			creds, _, err := a.cfClient.BindService(a.appGUID, serviceInstanceGUID, a.bindParameters)
+			if err != nil {
+				return err
+			}
+			if creds.Host() == "" || creds.Port() == 0 {
+				return fmt.Errorf("%s service is missing host, hostname or port", name)
+			}
+			a.status.Event("binding_created", util.Fields{
+				"instance":              name,
+				"service_instance_guid": serviceInstanceGUID,
+			})
+		}
+		if !found {
+			return fmt.Errorf("failed to bind service: '%s' was not found in space '%s'", name, a.space.Name)
+		}
+	}
+	return nil
+}
+
+// releaseSharedApp gives up the lease on the shared app. With the app
+// locked, the service instances no one else has a lease on are unbound and
+// the leases which have expired are removed, and if no one else is using the
+// app it's stopped.
+func (a *App) releaseSharedApp() error {
+	a.stopRenewing()
+
+	if err := a.lockSharedApp(); err != nil {
+		// the lease will expire, leaving the next user to tidy up
+		if removeErr := a.cfClient.RemoveAppAnnotations(a.appGUID, []string{leaseAnnotationPrefix + a.leaseID}); removeErr != nil {
+			logger.Debug("failed to remove lease:", removeErr)
+		}
+		return err
+	}
+	defer a.unlockSharedApp()
+
+	metadata, err := a.sharedAppMetadata()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	leases := SharedLeases(metadata)
+	released := []string{leaseAnnotationPrefix + a.leaseID}
+	inUse := map[string]bool{}
+	for _, lease := range leases {
+		if lease.ID == a.leaseID {
+			continue
+		}
+		if lease.Expired(now) {
+			released = append(released, leaseAnnotationPrefix+lease.ID)
+			continue
+		}
+		for _, name := range lease.Instances {
+			inUse[name] = true
+		}
+	}
+	if err := a.cfClient.RemoveAppAnnotations(a.appGUID, released); err != nil {
+		return fmt.Errorf("failed to release the lease on %s: %s", a.appName, err)
+	}
+	a.status.Event("lease_released", util.Fields{"app": a.appName, "app_guid": a.appGUID, "lease_id": a.leaseID})
+
+	if err := a.unbindUnusedServices(inUse); err != nil {
+		return err
+	}
+
+	if hasLiveLease(leases, a.leaseID, now) {
+		logger.Debug("leaving", a.appName, "running for the other leases on it")
+		return nil
+	}
+	a.status.Text("Stopping", a.appName)
+	if err := a.cfClient.StopApp(a.appGUID); err != nil {
+		return fmt.Errorf("failed to stop %s: %s", a.appName, err)
+	}
+	a.status.Event("app_stopped", util.Fields{"app": a.appName, "app_guid": a.appGUID})
+	return nil
+}
+
+// hasLiveLease returns whether anyone but the given lease holds an unexpired
+// lease
+func hasLiveLease(leases []Lease, exceptID string, now time.Time) bool {
+	for _, lease := range leases {
+		if lease.ID != exceptID && !lease.Expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// unbindUnusedServices deletes the shared app's bindings to service
+// instances which aren't in use
+func (a *App) unbindUnusedServices(inUse map[string]bool) error {
+	serviceBindings, err := a.cfClient.GetServiceBindings(
+		fmt.Sprintf("app_guid:%s", a.appGUID),
+	)
+	if err != nil {
+		return err
+	}
+	serviceInstances, err := a.cfClient.GetServiceInstances(
+		fmt.Sprintf("space_guid:%s", a.space.Guid),
+	)
+	if err != nil {
+		return err
+	}
+
+	for serviceInstanceGUID, binding := range serviceBindings {
+		name := serviceInstanceGUID
+		if serviceInstance, ok := serviceInstances[serviceInstanceGUID]; ok {
+			name = serviceInstance.Name
+		}
+		if inUse[name] {
+			continue
+		}
+		a.status.Text("Unbinding", name)
+		if err := a.cfClient.DeleteServiceBinding(binding.Guid); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to unbind %s from %s: %s", name, a.appName, err)
+		}
+		a.status.Event("binding_deleted", util.Fields{
+			"instance":              name,
+			"service_instance_guid": serviceInstanceGUID,
+		})
+	}
+	return nil
+}
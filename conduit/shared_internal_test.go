@@ -0,0 +1,454 @@
+package conduit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/client/clientfakes"
+	"github.com/alphagov/paas-cf-conduit/util"
+
+	cfclient "github.com/cloudfoundry-community/go-cfclient"
+)
+
+// fakeSpace keeps the apps and bindings the fake client is asked to create,
+// so that conduit processes sharing an app can be run against it
+type fakeSpace struct {
+	mu       sync.Mutex
+	apps     map[string]*client.AppSummary
+	created  int
+	bindings map[string]*cfclient.ServiceBinding
+}
+
+func newFakeSpace() *fakeSpace {
+	return &fakeSpace{
+		apps:     map[string]*client.AppSummary{},
+		bindings: map[string]*cfclient.ServiceBinding{},
+	}
+}
+
+// add puts an app in the space, as if someone else had created it
+func (s *fakeSpace) add(app client.AppSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apps[app.Name] = &app
+}
+
+// byGUID returns the app with this GUID, which must be called with mu held
+func (s *fakeSpace) byGUID(guid string) *client.AppSummary {
+	for _, app := range s.apps {
+		if app.Guid == guid {
+			return app
+		}
+	}
+	return nil
+}
+
+func (s *fakeSpace) stub(fakeClient *clientfakes.FakeClient) {
+	fakeClient.ListAppsStub = func(query url.Values) ([]client.AppSummary, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		apps := []client.AppSummary{}
+		for _, name := range query["names"] {
+			if s.apps[name] == nil {
+				continue
+			}
+			app := *s.apps[name]
+			app.Metadata = client.Metadata{Labels: map[string]string{}, Annotations: map[string]string{}}
+			for k, v := range s.apps[name].Metadata.Labels {
+				app.Metadata.Labels[k] = v
+			}
+			for k, v := range s.apps[name].Metadata.Annotations {
+				app.Metadata.Annotations[k] = v
+			}
+			apps = append(apps, app)
+		}
+		return apps, nil
+	}
+	fakeClient.CreateAppStub = func(name string, spaceGUID string) (string, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.apps[name] != nil {
+			return "", cfclient.CloudFoundryError{Code: 100002, ErrorCode: "CF-AppNameTaken", Description: "The app name is taken: " + name}
+		}
+		guid := "shared-guid"
+		if name != SharedAppName {
+			s.created++
+			guid = fmt.Sprintf("%s-guid-%d", name, s.created)
+		}
+		s.apps[name] = &client.AppSummary{Guid: guid, Name: name, SpaceGuid: spaceGUID, State: "STOPPED", CreatedAt: time.Now()}
+		return guid, nil
+	}
+	fakeClient.DestroyAppStub = func(appGuid string) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		app := s.byGUID(appGuid)
+		if app == nil {
+			return cfclient.CloudFoundryError{Code: 100004, ErrorCode: "CF-AppNotFound", Description: "The app could not be found: " + appGuid}
+		}
+		delete(s.apps, app.Name)
+		return nil
+	}
+	fakeClient.UpdateAppMetadataStub = func(appGuid string, metadata client.Metadata) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		app := s.byGUID(appGuid)
+		if app.Metadata.Labels == nil {
+			app.Metadata.Labels = map[string]string{}
+		}
+		if app.Metadata.Annotations == nil {
+			app.Metadata.Annotations = map[string]string{}
+		}
+		for k, v := range metadata.Labels {
+			app.Metadata.Labels[k] = v
+		}
+		for k, v := range metadata.Annotations {
+			app.Metadata.Annotations[k] = v
+		}
+		return nil
+	}
+	fakeClient.RemoveAppAnnotationsStub = func(appGuid string, keys []string) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, key := range keys {
+			delete(s.byGUID(appGuid).Metadata.Annotations, key)
+		}
+		return nil
+	}
+	fakeClient.StartAppStub = func(appGuid string) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.byGUID(appGuid).State = "STARTED"
+		return nil
+	}
+	fakeClient.StopAppStub = func(appGuid string) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.byGUID(appGuid).State = "STOPPED"
+		return nil
+	}
+	fakeClient.GetServiceInstancesReturns(map[string]*cfclient.ServiceInstance{
+		"db-guid":    {Guid: "db-guid", Name: "db"},
+		"cache-guid": {Guid: "cache-guid", Name: "cache"},
+	}, nil)
+	fakeClient.GetServiceBindingsStub = func(filters ...string) (map[string]*cfclient.ServiceBinding, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		bindings := map[string]*cfclient.ServiceBinding{}
+		for k, v := range s.bindings {
+			bindings[k] = v
+		}
+		return bindings, nil
+	}
+	fakeClient.BindServiceStub = func(appGuid string, serviceInstanceGuid string, parameters map[string]interface{}) (*client.Credentials, string, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.bindings[serviceInstanceGuid] = &cfclient.ServiceBinding{Guid: serviceInstanceGuid + "-binding", ServiceInstanceGuid: serviceInstanceGuid}
+		return &client.Credentials{"host": "db.internal", "port": 5432}, serviceInstanceGuid + "-binding", nil
+	}
+	fakeClient.DeleteServiceBindingStub = func(bindingGuid string) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for k, v := range s.bindings {
+			if v.Guid == bindingGuid {
+				delete(s.bindings, k)
+			}
+		}
+		return nil
+	}
+}
+
+func (s *fakeSpace) labels() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	labels := map[string]string{}
+	for k, v := range s.apps[SharedAppName].Metadata.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+func (s *fakeSpace) annotations() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	annotations := map[string]string{}
+	for k, v := range s.apps[SharedAppName].Metadata.Annotations {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+func (s *fakeSpace) state() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apps[SharedAppName].State
+}
+
+// has returns whether there's an app with this name in the space
+func (s *fakeSpace) has(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apps[name] != nil
+}
+
+func (s *fakeSpace) bound() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bound := []string{}
+	for k := range s.bindings {
+		bound = append(bound, k)
+	}
+	return bound
+}
+
+func leaseAnnotation(lease Lease) string {
+	b, err := json.Marshal(lease)
+	Expect(err).NotTo(HaveOccurred())
+	return string(b)
+}
+
+var _ = Describe("Shared app", func() {
+	var (
+		fakeClient *clientfakes.FakeClient
+		space      *fakeSpace
+		restore    func()
+	)
+
+	newSharedApp := func(instances ...string) *App {
+		a := NewApp(
+			fakeClient, util.NewStatus(GinkgoWriter, true),
+			7080, "my-org", "my-space", SharedAppName, false,
+			instances, []string{}, map[string]interface{}{}, false, nil, 0,
+		)
+		a.org = &cfclient.Org{Name: "my-org", Guid: "org-guid"}
+		a.space = &cfclient.Space{Name: "my-space", Guid: "space-guid"}
+		a.SetOwner(AppOwner{User: "jo@example.com", Hostname: "jos-laptop"})
+		a.SetShared(true)
+		return a
+	}
+
+	BeforeEach(func() {
+		fakeClient = &clientfakes.FakeClient{}
+		space = newFakeSpace()
+		space.stub(fakeClient)
+
+		duration, timeout, retry := LeaseDuration, lockTimeout, lockRetry
+		LeaseDuration, lockTimeout, lockRetry = time.Minute, 100*time.Millisecond, 10*time.Millisecond
+		restore = func() {
+			LeaseDuration, lockTimeout, lockRetry = duration, timeout, retry
+		}
+	})
+
+	AfterEach(func() {
+		restore()
+	})
+
+	It("creates the app the first time, labelled as shared, and takes a lease on it", func() {
+		a := newSharedApp("db")
+		Expect(a.DeploySharedApp()).To(Succeed())
+		defer a.Teardown()
+
+		Expect(fakeClient.UploadStaticAppBitsCallCount()).To(Equal(1))
+		Expect(fakeClient.UploadStaticAppBitsArgsForCall(0)).To(Equal("shared-guid"))
+		Expect(space.labels()).To(HaveKeyWithValue(SharedLabel, "true"))
+		Expect(space.state()).To(Equal("STARTED"))
+		Expect(space.bound()).To(ConsistOf("db-guid"))
+		Expect(space.has(sharedLockAppName)).To(BeFalse())
+
+		annotations := space.annotations()
+		leases := SharedLeases(client.Metadata{Annotations: annotations})
+		Expect(leases).To(HaveLen(1))
+		Expect(leases[0].ID).To(Equal(a.leaseID))
+		Expect(leases[0].Owner).To(Equal("jo@example.com"))
+		Expect(leases[0].Instances).To(Equal([]string{"db"}))
+		Expect(leases[0].Expired(time.Now())).To(BeFalse())
+	})
+
+	It("starts the existing app and binds only the service instances which aren't bound", func() {
+		space.add(client.AppSummary{Guid: "shared-guid", Name: SharedAppName, State: "STOPPED"})
+		space.bindings["db-guid"] = &cfclient.ServiceBinding{Guid: "db-binding", ServiceInstanceGuid: "db-guid"}
+
+		a := newSharedApp("db", "cache")
+		Expect(a.DeploySharedApp()).To(Succeed())
+		defer a.Teardown()
+
+		Expect(fakeClient.UploadStaticAppBitsCallCount()).To(Equal(0))
+		Expect(fakeClient.BindServiceCallCount()).To(Equal(1))
+		_, serviceInstanceGUID, _ := fakeClient.BindServiceArgsForCall(0)
+		Expect(serviceInstanceGUID).To(Equal("cache-guid"))
+		Expect(fakeClient.StartAppCallCount()).To(Equal(1))
+		Expect(fakeClient.PollForAppStateCallCount()).To(Equal(1))
+		Expect(space.state()).To(Equal("STARTED"))
+	})
+
+	It("doesn't restart the app if it's running", func() {
+		space.add(client.AppSummary{Guid: "shared-guid", Name: SharedAppName, State: "STARTED"})
+
+		a := newSharedApp("db")
+		Expect(a.DeploySharedApp()).To(Succeed())
+		defer a.Teardown()
+
+		Expect(fakeClient.StartAppCallCount()).To(Equal(0))
+	})
+
+	It("waits for someone else's lock, saying who holds it", func() {
+		space.add(client.AppSummary{Guid: "lock-guid", Name: sharedLockAppName, CreatedAt: time.Now(), Metadata: client.Metadata{
+			Annotations: map[string]string{
+				LockAnnotation: leaseAnnotation(Lease{ID: "other", Owner: "sam@example.com", Hostname: "sams-laptop", ExpiresAt: time.Now().Add(time.Minute)}),
+			},
+		}})
+
+		a := newSharedApp("db")
+		err := a.DeploySharedApp()
+		Expect(err).To(MatchError(ContainSubstring("__conduit_shared__ is locked by sam@example.com on sams-laptop until")))
+		Expect(space.has(SharedAppName)).To(BeFalse())
+		Expect(fakeClient.BindServiceCallCount()).To(Equal(0))
+		Expect(space.has(sharedLockAppName)).To(BeTrue())
+		Expect(a.Teardown()).To(Succeed())
+	})
+
+	It("waits for a lock whose holder hasn't said who they are until it expires", func() {
+		space.add(client.AppSummary{Guid: "lock-guid", Name: sharedLockAppName, CreatedAt: time.Now()})
+
+		a := newSharedApp("db")
+		Expect(a.DeploySharedApp()).To(MatchError(ContainSubstring("is locked by another conduit")))
+
+		space.add(client.AppSummary{Guid: "lock-guid", Name: sharedLockAppName, CreatedAt: time.Now().Add(-lockDuration)})
+		Expect(a.DeploySharedApp()).To(Succeed())
+		defer a.Teardown()
+	})
+
+	It("takes over a lock which has expired", func() {
+		space.add(client.AppSummary{Guid: "lock-guid", Name: sharedLockAppName, Metadata: client.Metadata{
+			Annotations: map[string]string{
+				LockAnnotation: leaseAnnotation(Lease{ID: "other", ExpiresAt: time.Now().Add(-time.Second)}),
+			},
+		}})
+
+		a := newSharedApp("db")
+		Expect(a.DeploySharedApp()).To(Succeed())
+		defer a.Teardown()
+
+		Expect(fakeClient.DestroyAppArgsForCall(0)).To(Equal("lock-guid"))
+		Expect(space.has(sharedLockAppName)).To(BeFalse())
+	})
+
+	It("doesn't break a lock taken since the one which expired", func() {
+		space.add(client.AppSummary{Guid: "lock-guid", Name: sharedLockAppName, Metadata: client.Metadata{
+			Annotations: map[string]string{
+				LockAnnotation: leaseAnnotation(Lease{ID: "other", ExpiresAt: time.Now().Add(-time.Second)}),
+			},
+		}})
+		a := newSharedApp("db")
+		Expect(a.lockSharedApp()).To(Succeed())
+		taken := a.lockGUID
+
+		// someone else saw the expired lock before a broke it
+		Expect(fakeClient.DestroyApp("lock-guid")).To(MatchError(ContainSubstring("could not be found")))
+		Expect(space.has(sharedLockAppName)).To(BeTrue())
+
+		a.unlockSharedApp()
+		Expect(space.has(sharedLockAppName)).To(BeFalse())
+		Expect(fakeClient.DestroyAppArgsForCall(fakeClient.DestroyAppCallCount() - 1)).To(Equal(taken))
+	})
+
+	It("creates the app once when several runs start at the same time", func() {
+		apps := []*App{newSharedApp("db"), newSharedApp("db"), newSharedApp("cache")}
+		errs := make(chan error, len(apps))
+		for _, a := range apps {
+			go func(a *App) {
+				defer GinkgoRecover()
+				errs <- a.DeploySharedApp()
+			}(a)
+		}
+		for range apps {
+			Expect(<-errs).To(Succeed())
+		}
+
+		Expect(fakeClient.UploadStaticAppBitsCallCount()).To(Equal(1))
+		Expect(space.bound()).To(ConsistOf("db-guid", "cache-guid"))
+		Expect(SharedLeases(client.Metadata{Annotations: space.annotations()})).To(HaveLen(3))
+		Expect(space.has(sharedLockAppName)).To(BeFalse())
+		for _, a := range apps {
+			Expect(a.Teardown()).To(Succeed())
+		}
+		Expect(space.state()).To(Equal("STOPPED"))
+	})
+
+	It("deletes the app if it can't be deployed, rather than leaving it for the next run", func() {
+		fakeClient.UploadStaticAppBitsReturns(errors.New("upload failed"))
+
+		a := newSharedApp("db")
+		Expect(a.DeploySharedApp()).To(MatchError("upload failed"))
+		Expect(space.has(SharedAppName)).To(BeFalse())
+		Expect(space.has(sharedLockAppName)).To(BeFalse())
+		Expect(a.Teardown()).To(Succeed())
+	})
+
+	It("unbinds the service instances no one else is using and stops the app when no one is", func() {
+		a := newSharedApp("db", "cache")
+		Expect(a.DeploySharedApp()).To(Succeed())
+		b := newSharedApp("db")
+		Expect(b.DeploySharedApp()).To(Succeed())
+
+		Expect(a.Teardown()).To(Succeed())
+		Expect(space.bound()).To(ConsistOf("db-guid"))
+		Expect(space.state()).To(Equal("STARTED"))
+		Expect(SharedLeases(client.Metadata{Annotations: space.annotations()})).To(HaveLen(1))
+
+		Expect(b.Teardown()).To(Succeed())
+		Expect(space.bound()).To(BeEmpty())
+		Expect(space.state()).To(Equal("STOPPED"))
+		Expect(space.has(sharedLockAppName)).To(BeFalse())
+		Expect(SharedLeases(client.Metadata{Annotations: space.annotations()})).To(BeEmpty())
+		Expect(space.has(SharedAppName)).To(BeTrue())
+	})
+
+	It("ignores and removes leases which have expired", func() {
+		space.add(client.AppSummary{Guid: "shared-guid", Name: SharedAppName, State: "STARTED", Metadata: client.Metadata{
+			Annotations: map[string]string{
+				leaseAnnotationPrefix + "other": leaseAnnotation(Lease{ID: "other", Instances: []string{"cache"}, ExpiresAt: time.Now().Add(-time.Second)}),
+			},
+		}})
+		space.bindings["cache-guid"] = &cfclient.ServiceBinding{Guid: "cache-binding", ServiceInstanceGuid: "cache-guid"}
+
+		a := newSharedApp("db")
+		Expect(a.DeploySharedApp()).To(Succeed())
+		Expect(a.Teardown()).To(Succeed())
+
+		Expect(space.bound()).To(BeEmpty())
+		Expect(space.state()).To(Equal("STOPPED"))
+		Expect(space.annotations()).To(BeEmpty())
+	})
+
+	It("renews the lease until it's torn down", func() {
+		LeaseDuration = 30 * time.Millisecond
+
+		a := newSharedApp("db")
+		Expect(a.DeploySharedApp()).To(Succeed())
+		first := SharedLeases(client.Metadata{Annotations: space.annotations()})[0].ExpiresAt
+
+		Eventually(func() time.Time {
+			return SharedLeases(client.Metadata{Annotations: space.annotations()})[0].ExpiresAt
+		}).Should(BeTemporally(">", first))
+
+		Expect(a.Teardown()).To(Succeed())
+		renewals := fakeClient.UpdateAppMetadataCallCount()
+		Consistently(fakeClient.UpdateAppMetadataCallCount, 50*time.Millisecond).Should(Equal(renewals))
+	})
+})
+
+var _ = Describe("IsSharedApp()", func() {
+	It("recognises the shared app by its label or name", func() {
+		Expect(IsSharedApp(client.AppSummary{Name: "tunnel", Metadata: client.Metadata{Labels: map[string]string{SharedLabel: "true"}}})).To(BeTrue())
+		Expect(IsSharedApp(client.AppSummary{Name: SharedAppName})).To(BeTrue())
+		Expect(IsSharedApp(client.AppSummary{Name: "__conduit_abcd1234__"})).To(BeFalse())
+	})
+})
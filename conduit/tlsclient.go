@@ -0,0 +1,37 @@
+package conduit
+
+import (
+	"fmt"
+
+	"github.com/alphagov/paas-cf-conduit/tls"
+)
+
+// SetTLSClientCredentials sets the CAs and client certificate the TLS
+// tunnels use for every service instance. CAs in the bindings are used as
+// well, and so are client certificates unless one is given here.
+func (a *App) SetTLSClientCredentials(creds *tls.ClientCredentials) {
+	a.tlsClientCreds = creds
+}
+
+// SetTLSTunnels makes conduit start TLS with postgres and TLS-enabled redis
+// instances itself, rather than leaving it to their clients, so that
+// clients which can't verify the service's certificate can connect in
+// plaintext
+func (a *App) SetTLSTunnels(tlsTunnels bool) {
+	a.forceTLSTunnels = tlsTunnels
+}
+
+// tlsClientCredentials returns the credentials TLS connections to a service
+// instance are made with
+func (a *App) tlsClientCredentials(ti tunnelledInstance) (*tls.ClientCredentials, error) {
+	creds := ti.instance.Credentials
+	binding, err := tls.ParseClientCredentials(
+		[]byte(creds.TLSCACertificate()),
+		[]byte(creds.TLSClientCertificate()),
+		[]byte(creds.TLSClientKey()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("service instance %s: %s", ti.instance.InstanceName, err)
+	}
+	return a.tlsClientCreds.Merge(binding), nil
+}
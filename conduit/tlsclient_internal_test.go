@@ -0,0 +1,56 @@
+package conduit
+
+import (
+	"io/ioutil"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/tls"
+)
+
+var _ = Describe("TLS client credentials", func() {
+	var (
+		app    *App
+		caPEM  string
+		flagCA *tls.ClientCredentials
+	)
+
+	BeforeEach(func() {
+		app = &App{}
+		local, err := tls.NewLocalCA(GinkgoT().TempDir(), []string{"localhost"})
+		Expect(err).NotTo(HaveOccurred())
+		b, err := ioutil.ReadFile(local.CAFile)
+		Expect(err).NotTo(HaveOccurred())
+		caPEM = string(b)
+		flagCA, err = tls.LoadClientCredentials(local.CAFile, "", "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	instance := func(creds client.Credentials) tunnelledInstance {
+		return tunnelledInstance{
+			serviceType: "redis",
+			instance:    &client.VcapService{InstanceName: "my-redis", Credentials: creds},
+		}
+	}
+
+	It("uses the CAs given to the app and in the binding", func() {
+		app.SetTLSClientCredentials(flagCA)
+		creds, err := app.tlsClientCredentials(instance(client.Credentials{"ca_certificate": caPEM}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.CAs).To(HaveLen(2))
+	})
+
+	It("ignores fields which aren't PEM", func() {
+		creds, err := app.tlsClientCredentials(instance(client.Credentials{"ca": "true", "tls_cert": "none"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.CAs).To(BeEmpty())
+		Expect(creds.Certificate).To(BeNil())
+	})
+
+	It("fails if the binding's client certificate has no key", func() {
+		_, err := app.tlsClientCredentials(instance(client.Credentials{"client_cert": caPEM}))
+		Expect(err).To(MatchError("service instance my-redis: a client certificate and key must be given together"))
+	})
+})
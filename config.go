@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alphagov/paas-cf-conduit/conduit"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	ProfileName string
+	// profile is the profile named by --profile, if any
+	profile *conduit.Profile
+)
+
+// legacyEnvNames are the environment variables flags could be set with
+// before every flag could be
+var legacyEnvNames = map[string]string{
+	"cipher-suites":       "CF_CONDUIT_CIPHERSUITES",
+	"minimum-tls-version": "CF_CONDUIT_MIN_TLS_VERSION",
+}
+
+// envNames returns the environment variables a flag can be set with, e.g.
+// CF_CONDUIT_LOCAL_PORT for --local-port
+func envNames(flag string) []string {
+	names := []string{"CF_CONDUIT_" + strings.ToUpper(strings.Replace(flag, "-", "_", -1))}
+	if legacy, ok := legacyEnvNames[flag]; ok {
+		names = append(names, legacy)
+	}
+	return names
+}
+
+// lookupEnv returns the value of the first environment variable the flag
+// can be set with which is set
+func lookupEnv(flag string) (string, string, bool) {
+	for _, name := range envNames(flag) {
+		if value, ok := os.LookupEnv(name); ok {
+			return name, value, true
+		}
+	}
+	return "", "", false
+}
+
+// usingProfile returns whether a profile has been asked for, before the
+// flags from the environment have been applied
+func usingProfile() bool {
+	if ProfileName != "" {
+		return true
+	}
+	_, value, ok := lookupEnv("profile")
+	return ok && value != ""
+}
+
+// guardFlags confirm destructive actions, so are never set by environment
+// variables or profiles, only on the command line
+var guardFlags = map[string]bool{
+	"confirm": true,
+	"write":   true,
+}
+
+// configFlag returns the flag with this name which can be set by environment
+// variables and profiles, or nil. Only the flags of the cf command and of
+// conduit itself can be, as the subcommands' flags would be ambiguous between
+// them (e.g. --format). The hidden flags are set by the cf CLI.
+func configFlag(cmd *cobra.Command, name string) *pflag.Flag {
+	if name == "help" || guardFlags[name] {
+		return nil
+	}
+	f := cmd.Root().PersistentFlags().Lookup(name)
+	if f == nil {
+		f = ConnectService.Flags().Lookup(name)
+	}
+	if f == nil || f.Hidden {
+		return nil
+	}
+	return f
+}
+
+// applyConfig sets the flags which weren't given on the command line from
+// CF_CONDUIT_* environment variables, and failing that from the profile
+func applyConfig(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+
+	if f := flags.Lookup("profile"); f != nil && !f.Changed {
+		if name, value, ok := lookupEnv("profile"); ok {
+			if err := f.Value.Set(value); err != nil {
+				return fmt.Errorf("invalid value for %s: %s", name, err)
+			}
+		}
+	}
+	if ProfileName != "" {
+		path, err := conduit.DefaultProfilesPath()
+		if err != nil {
+			return err
+		}
+		profile, err = conduit.LoadProfile(path, ProfileName)
+		if err != nil {
+			return err
+		}
+		for _, name := range profile.FlagNames() {
+			// flags of the main command are allowed, as that's what
+			// profiles are mostly for, but not used by subcommands
+			if configFlag(cmd, name) == nil || name == "profile" {
+				return fmt.Errorf("profile %s: unknown option %s", ProfileName, name)
+			}
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || configFlag(cmd, f.Name) != f || f.Name == "profile" {
+			return
+		}
+		if name, value, ok := lookupEnv(f.Name); ok {
+			if setErr := f.Value.Set(value); setErr != nil {
+				err = fmt.Errorf("invalid value for %s: %s", name, setErr)
+			}
+			return
+		}
+		if profile == nil {
+			return
+		}
+		value, ok, flagErr := profile.Flag(f.Name)
+		if flagErr != nil {
+			err = fmt.Errorf("profile %s: %s", ProfileName, flagErr)
+			return
+		}
+		if ok {
+			if setErr := f.Value.Set(value); setErr != nil {
+				err = fmt.Errorf("profile %s: invalid value for %s: %s", ProfileName, f.Name, setErr)
+			}
+		}
+	})
+	return err
+}
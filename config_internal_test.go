@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	// not dot imported, as its GracePeriod clashes with the flag's
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// root is built once, as the commands and their flags are package variables
+var root = newCommand()
+
+var _ = ginkgo.Describe("Config", func() {
+	setenv := func(name, value string) {
+		Expect(os.Setenv(name, value)).To(Succeed())
+		ginkgo.DeferCleanup(os.Unsetenv, name)
+	}
+
+	parse := func(args ...string) *cobra.Command {
+		cmd, flags, err := root.Find(args)
+		Expect(err).NotTo(HaveOccurred())
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			f.Changed = false
+		})
+		Expect(cmd.ParseFlags(flags)).To(Succeed())
+		return cmd
+	}
+
+	ginkgo.BeforeEach(func() {
+		ProfileName = ""
+		profile = nil
+		ConduitOrg = ""
+		RestoreConfirm = ""
+		RestoreFormat = ""
+		QueryReadWrite = false
+		setenv("CF_HOME", ginkgo.GinkgoT().TempDir())
+	})
+
+	ginkgo.It("sets the flags of conduit from the environment", func() {
+		setenv("CF_CONDUIT_ORG", "my-org")
+
+		Expect(applyConfig(parse("conduit", "restore", "my-db"))).To(Succeed())
+		Expect(ConduitOrg).To(Equal("my-org"))
+	})
+
+	ginkgo.It("doesn't set the flags of subcommands from the environment", func() {
+		setenv("CF_CONDUIT_CONFIRM", "my-db")
+		setenv("CF_CONDUIT_FORMAT", "pg_dump-plain")
+
+		Expect(applyConfig(parse("conduit", "restore", "my-db", "--input", "backup.sql"))).To(Succeed())
+		Expect(RestoreConfirm).To(BeEmpty())
+		Expect(RestoreFormat).To(BeEmpty())
+	})
+
+	ginkgo.It("doesn't let a profile confirm a destructive action", func() {
+		dir := filepath.Join(os.Getenv("CF_HOME"), ".cf")
+		Expect(os.MkdirAll(dir, 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "conduit.yml"), []byte(`
+profiles:
+  prod:
+    org: my-org
+    write: true
+`), 0600)).To(Succeed())
+
+		err := applyConfig(parse("conduit", "query", "my-db", "--profile", "prod"))
+		Expect(err).To(MatchError("profile prod: unknown option write"))
+		Expect(QueryReadWrite).To(BeFalse())
+	})
+})
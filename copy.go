@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/alphagov/paas-cf-conduit/conduit"
+	"github.com/alphagov/paas-cf-conduit/logging"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	CopyTables   []string
+	CopyDstOrg   string
+	CopyDstSpace string
+	CopyConfirm  string
+)
+
+var Copy = &cobra.Command{
+	Use: "copy [flags] SOURCE_SERVICE_INSTANCE DESTINATION_SERVICE_INSTANCE",
+	Example: `  Copy a postgres database to another:
+  cf conduit copy postgres-instance other-postgres-instance
+
+  Copy some tables to a database in another space:
+  cf conduit copy postgres-instance staging-postgres-instance --dst-space staging --tables users,orders
+  `,
+	Short: "copies the data in one service instance to another",
+	Long:  "streams a dump of one service instance straight into another of the same type, without an intermediate file. Service instances which aren't empty are only copied to if --confirm is given.",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		srcName, dstName := args[0], args[1]
+
+		sameSpace := (CopyDstOrg == "" || CopyDstOrg == ConduitOrg) && (CopyDstSpace == "" || CopyDstSpace == ConduitSpace)
+		if sameSpace && srcName == dstName {
+			return errors.New("can't copy a service instance to itself")
+		}
+		if !sameSpace && ConduitExistingApp {
+			return errors.New("--existing-app can't be used to copy between spaces")
+		}
+
+		status, done, err := newStatus()
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		serviceInstanceNames := []string{srcName, dstName}
+		if !sameSpace {
+			serviceInstanceNames = []string{srcName}
+		}
+		srcApp, err := newApp(status, serviceInstanceNames, []string{})
+		if err != nil {
+			return err
+		}
+		srcApp.UseProviderClients()
+
+		defer func() {
+			if err := srcApp.Teardown(); err != nil {
+				logging.Error(err)
+			}
+		}()
+
+		if err := openTunnels(srcApp, status); err != nil {
+			return err
+		}
+
+		dstApp := srcApp
+		if !sameSpace {
+			// the destination needs an app of its own in its space, with
+			// tunnels on the ports after the source's
+			if CopyDstOrg != "" {
+				ConduitOrg = CopyDstOrg
+			}
+			if CopyDstSpace != "" {
+				ConduitSpace = CopyDstSpace
+			}
+			ConduitLocalPort = srcApp.NextPort()
+
+			dstApp, err = newApp(status, []string{dstName}, []string{})
+			if err != nil {
+				return err
+			}
+			dstApp.UseProviderClients()
+
+			defer func() {
+				if err := dstApp.Teardown(); err != nil {
+					logging.Error(err)
+				}
+			}()
+
+			if err := openTunnels(dstApp, status); err != nil {
+				return err
+			}
+		}
+
+		if err := conduit.Copy(srcApp, srcName, dstApp, dstName, CopyTables, CopyConfirm); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "\nCopied %s to %s\n", srcName, dstName)
+		return nil
+	},
+	SilenceUsage: true,
+}
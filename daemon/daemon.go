@@ -0,0 +1,230 @@
+// Package daemon lets conduit sessions run in the background, and be listed
+// and stopped from other conduit processes over a control socket
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/conduit"
+	"github.com/alphagov/paas-cf-conduit/logging"
+)
+
+var logger = logging.For("daemon")
+
+// callTimeout is how long a daemon has to answer a request
+const callTimeout = 5 * time.Second
+
+const (
+	// CommandStatus asks a daemon for its Status
+	CommandStatus = "status"
+	// CommandStop asks a daemon to tear down and exit
+	CommandStop = "stop"
+)
+
+// Status describes a running daemon and the tunnels it's keeping open
+type Status struct {
+	ID        string                   `json:"id"`
+	PID       int                      `json:"pid"`
+	StartedAt time.Time                `json:"started_at"`
+	Org       string                   `json:"org"`
+	Space     string                   `json:"space"`
+	AppName   string                   `json:"app_name"`
+	Instances []conduit.ConnectionInfo `json:"instances"`
+}
+
+// Request is sent to a daemon's control socket, one JSON document per line
+type Request struct {
+	Command string `json:"command"`
+}
+
+// Response is a daemon's answer to a Request
+type Response struct {
+	Status *Status `json:"status,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// Dir is where the control sockets and logs of daemons are kept
+func Dir() (string, error) {
+	dir, err := conduit.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemons"), nil
+}
+
+// SocketPath is the path of the control socket of the daemon with the ID
+func SocketPath(dir, id string) string {
+	return filepath.Join(dir, id+".sock")
+}
+
+// LogPath is the path of the file the daemon with the ID writes its output to
+func LogPath(dir, id string) string {
+	return filepath.Join(dir, id+".log")
+}
+
+// Listen opens the control socket at path, which only the current user can
+// connect to
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// a daemon which was killed leaves its socket behind
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %s", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Serve answers requests on the control socket until it's closed. stop is
+// called once a stop request has been answered.
+func Serve(l net.Listener, status func() Status, stop func()) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			if handle(conn, status) == CommandStop {
+				stop()
+			}
+		}()
+	}
+}
+
+// handle answers a single request, returning its command
+func handle(conn net.Conn, status func() Status) string {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	var req Request
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+	if err != nil {
+		logger.Debug("bad request:", err)
+		return ""
+	}
+
+	resp := Response{}
+	switch req.Command {
+	case CommandStatus, CommandStop:
+		s := status()
+		resp.Status = &s
+	default:
+		resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		logger.Debug("failed to respond:", err)
+	}
+	return req.Command
+}
+
+// Call sends a request to the daemon listening on the socket at path
+func Call(path string, command string) (*Status, error) {
+	conn, err := net.DialTimeout("unix", path, callTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	if err := json.NewEncoder(conn).Encode(Request{Command: command}); err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read response: %s", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	if resp.Status == nil {
+		return nil, errors.New("no status in response")
+	}
+	return resp.Status, nil
+}
+
+// List returns the status of every daemon with a socket in dir, oldest
+// first. The sockets of daemons which have exited are removed.
+func List(dir string) ([]Status, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []Status{}
+	for _, path := range paths {
+		s, err := Call(path, CommandStatus)
+		if err != nil {
+			logger.Debug("removing socket of exited daemon", path+":", err)
+			os.Remove(path)
+			continue
+		}
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].StartedAt.Before(statuses[j].StartedAt)
+	})
+	return statuses, nil
+}
+
+// Find returns the control socket of the daemon with the ID, which can be
+// shortened as long as it's unambiguous
+func Find(dir, id string) (string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	if err != nil {
+		return "", err
+	}
+	matches := []string{}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".sock")
+		if name == id {
+			return path, nil
+		}
+		if strings.HasPrefix(name, id) {
+			matches = append(matches, path)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no background session %s, see `cf conduit list`", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%s matches %d background sessions, give more of the ID", id, len(matches))
+	}
+}
+
+// WaitForExit waits until the daemon with the socket at path stops
+// answering, or the timeout passes
+func WaitForExit(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil
+		}
+		if _, err := Call(path, CommandStatus); err != nil {
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return fmt.Errorf("timed out after %s", timeout)
+}
@@ -0,0 +1,131 @@
+//go:build !windows
+
+package daemon_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/conduit"
+	"github.com/alphagov/paas-cf-conduit/daemon"
+)
+
+var _ = Describe("Control socket", func() {
+	var (
+		dir       string
+		listeners []net.Listener
+		stopped   chan string
+	)
+
+	serve := func(id string, startedAt time.Time) {
+		l, err := daemon.Listen(daemon.SocketPath(dir, id))
+		Expect(err).NotTo(HaveOccurred())
+		listeners = append(listeners, l)
+		go daemon.Serve(l, func() daemon.Status {
+			return daemon.Status{
+				ID:        id,
+				PID:       1234,
+				StartedAt: startedAt,
+				Org:       "my-org",
+				Space:     "my-space",
+				Instances: []conduit.ConnectionInfo{{Name: "db", LocalPort: 7080}},
+			}
+		}, func() {
+			stopped <- id
+		})
+	}
+
+	BeforeEach(func() {
+		var err error
+		// unix socket paths are limited to about 100 characters, which the
+		// Ginkgo temporary directories can exceed
+		dir, err = ioutil.TempDir("/tmp", "conduit")
+		Expect(err).NotTo(HaveOccurred())
+		listeners = nil
+		stopped = make(chan string, 1)
+	})
+
+	AfterEach(func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+		os.RemoveAll(dir)
+	})
+
+	It("only lets the current user connect", func() {
+		serve("abcd1234", time.Now())
+		info, err := os.Stat(daemon.SocketPath(dir, "abcd1234"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+
+	It("answers status requests", func() {
+		serve("abcd1234", time.Now())
+		status, err := daemon.Call(daemon.SocketPath(dir, "abcd1234"), daemon.CommandStatus)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.ID).To(Equal("abcd1234"))
+		Expect(status.Instances).To(HaveLen(1))
+		Expect(status.Instances[0].LocalPort).To(Equal(int64(7080)))
+		Consistently(stopped).ShouldNot(Receive())
+	})
+
+	It("stops once a stop request has been answered", func() {
+		serve("abcd1234", time.Now())
+		status, err := daemon.Call(daemon.SocketPath(dir, "abcd1234"), daemon.CommandStop)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.ID).To(Equal("abcd1234"))
+		Eventually(stopped).Should(Receive(Equal("abcd1234")))
+	})
+
+	It("rejects unknown commands", func() {
+		serve("abcd1234", time.Now())
+		_, err := daemon.Call(daemon.SocketPath(dir, "abcd1234"), "explode")
+		Expect(err).To(MatchError(`unknown command "explode"`))
+	})
+
+	It("lists daemons oldest first, removing the sockets of ones which have exited", func() {
+		serve("newer000", time.Now())
+		serve("older000", time.Now().Add(-time.Hour))
+		dead, err := net.Listen("unix", daemon.SocketPath(dir, "dead0000"))
+		Expect(err).NotTo(HaveOccurred())
+		// leave the socket behind, as a killed daemon would
+		dead.(*net.UnixListener).SetUnlinkOnClose(false)
+		dead.Close()
+
+		statuses, err := daemon.List(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(statuses).To(HaveLen(2))
+		Expect(statuses[0].ID).To(Equal("older000"))
+		Expect(statuses[1].ID).To(Equal("newer000"))
+		Expect(filepath.Join(dir, "dead0000.sock")).NotTo(BeAnExistingFile())
+	})
+
+	It("finds a daemon by a prefix of its ID", func() {
+		serve("abcd1234", time.Now())
+		serve("abef5678", time.Now())
+
+		path, err := daemon.Find(dir, "abc")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(path).To(Equal(daemon.SocketPath(dir, "abcd1234")))
+
+		_, err = daemon.Find(dir, "ab")
+		Expect(err).To(MatchError("ab matches 2 background sessions, give more of the ID"))
+		_, err = daemon.Find(dir, "zz")
+		Expect(err).To(MatchError("no background session zz, see `cf conduit list`"))
+	})
+
+	It("waits for a daemon to exit", func() {
+		serve("abcd1234", time.Now())
+		go func() {
+			time.Sleep(300 * time.Millisecond)
+			listeners[0].Close()
+		}()
+		Expect(daemon.WaitForExit(daemon.SocketPath(dir, "abcd1234"), 5*time.Second)).To(Succeed())
+	})
+})
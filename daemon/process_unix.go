@@ -0,0 +1,20 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Detach starts the daemon in a new session, so that it isn't sent the
+// terminal's signals and keeps running once the terminal is closed
+func Detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// Terminate asks the daemon to tear down and exit
+func Terminate(proc *os.Process) error {
+	return proc.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package daemon
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// Detach starts the daemon without a console, so that it keeps running once
+// the console is closed
+func Detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+// Terminate kills the daemon, as it can't be signalled to exit
+func Terminate(proc *os.Process) error {
+	return proc.Kill()
+}
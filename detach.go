@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/conduit"
+	"github.com/alphagov/paas-cf-conduit/daemon"
+	"github.com/alphagov/paas-cf-conduit/logging"
+	"github.com/alphagov/paas-cf-conduit/util"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// daemonIDEnv is set when conduit is run as a background session by --detach
+	daemonIDEnv = "CF_CONDUIT_DAEMON_ID"
+	// daemonTokenEnv passes the access token to a background session, as
+	// arguments can be seen by other users
+	daemonTokenEnv = "CF_CONDUIT_DAEMON_TOKEN"
+)
+
+var (
+	Detach bool
+	// pluginArgs are the arguments conduit was run with by the cf CLI
+	pluginArgs []string
+	// daemonID is set when running as a background session
+	daemonID string
+	// daemonListener is the background session's control socket, which is
+	// closed once it has torn down
+	daemonListener net.Listener
+)
+
+// validateDetach checks that nothing needs the terminal once conduit is
+// running in the background
+func validateDetach(runningCommands bool) error {
+	if runningCommands {
+		return errors.New("--detach cannot be used when running a command")
+	}
+	if ExportEnvFormat != "" && ExportEnvFile == "" {
+		return errors.New("--detach requires --export-env-file to be set when using --export-env")
+	}
+	if OutputFormat != "" && OutputFile == "" {
+		return errors.New("--detach requires --output-file to be set when using --output")
+	}
+	if strings.HasPrefix(EventsSink, "fd:") {
+		return errors.New("--detach cannot write events to a file descriptor")
+	}
+	return nil
+}
+
+// daemonArgs are the arguments a background session is run with: the same
+// as this process's, with the targeting the cf CLI gave the plugin made
+// explicit
+func daemonArgs(args []string) []string {
+	if len(args) == 0 {
+		return args
+	}
+	daemonArgs := []string{
+		args[0],
+		"--org", ConduitOrg,
+		"--space", ConduitSpace,
+		"--endpoint", ApiEndpoint,
+		"--no-interactive",
+		// a profile or the environment mustn't detach it again
+		"--detach=false",
+	}
+	if ApiInsecure {
+		daemonArgs = append(daemonArgs, "--insecure")
+	}
+	for i, arg := range args[1:] {
+		if arg == "--" {
+			daemonArgs = append(daemonArgs, args[i+1:]...)
+			break
+		}
+		if arg == "--detach" || strings.HasPrefix(arg, "--detach=") {
+			continue
+		}
+		daemonArgs = append(daemonArgs, arg)
+	}
+	return daemonArgs
+}
+
+// startDaemon runs this conduit command again as a background session,
+// waits for its tunnels to be ready and says how to connect to them
+func startDaemon(status *util.Status) error {
+	dir, err := daemon.Dir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	id := GenerateRandomString(8)
+	logPath := daemon.LogPath(dir, id)
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+
+	proc := exec.Command(exe, daemonArgs(pluginArgs)...)
+	proc.Env = append(os.Environ(), daemonIDEnv+"="+id, daemonTokenEnv+"="+ApiToken)
+	proc.Stdin = devNull
+	proc.Stdout = logFile
+	proc.Stderr = logFile
+	daemon.Detach(proc)
+
+	status.Text("Starting background session", id)
+	if err := proc.Start(); err != nil {
+		return fmt.Errorf("failed to start background session: %s", err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- proc.Wait()
+	}()
+
+	socketPath := daemon.SocketPath(dir, id)
+	for {
+		select {
+		case err := <-exited:
+			status.Done()
+			printLogTail(logPath, 20)
+			if err == nil {
+				err = errors.New("exited")
+			}
+			return fmt.Errorf("background session failed: %s, see %s", err, logPath)
+		case <-shutdown:
+			status.Text("Stopping background session", id)
+			if err := daemon.Terminate(proc.Process); err != nil {
+				return err
+			}
+			<-exited
+			return errors.New("interrupted")
+		case <-time.After(500 * time.Millisecond):
+			s, err := daemon.Call(socketPath, daemon.CommandStatus)
+			if err != nil {
+				continue
+			}
+			status.Done()
+			printDaemonStatus(*s)
+			fmt.Fprintf(os.Stderr, "Stop it with `cf conduit stop %s`.\n", s.ID)
+			return nil
+		}
+	}
+}
+
+// printLogTail shows the last lines a background session wrote before it
+// exited
+func printLogTail(path string, lines int) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	log := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(log) > lines {
+		log = log[len(log)-lines:]
+	}
+	fmt.Fprintln(os.Stderr, strings.Join(log, "\n"))
+}
+
+// printDaemonStatus describes a background session and how to connect to
+// its tunnels
+func printDaemonStatus(s daemon.Status) {
+	fmt.Fprintf(os.Stderr, "Background session %s (pid %d) in %s/%s using app %s, started %s\n",
+		s.ID, s.PID, s.Org, s.Space, s.AppName, s.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(os.Stderr, "\nThe following services are ready for you to connect to:\n\n")
+	for _, info := range s.Instances {
+		fmt.Fprintf(os.Stderr, "* service: %s (%s)\n", info.Name, info.Service)
+		if info.LocalSocket != "" {
+			fmt.Fprintf(os.Stderr, "  unix socket: %s\n", info.LocalSocket)
+		}
+		if info.LocalCAFile != "" {
+			fmt.Fprintf(os.Stderr, "  TLS CA: %s\n", info.LocalCAFile)
+		}
+		info.Credentials.Fprint(os.Stderr, "  ")
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// runDaemon runs conduit as a background session started by --detach,
+// without the cf CLI
+func runDaemon(cmd *cobra.Command, id string) {
+	daemonID = id
+	ApiToken = os.Getenv(daemonTokenEnv)
+	// not passed on to anything conduit runs
+	os.Unsetenv(daemonIDEnv)
+	os.Unsetenv(daemonTokenEnv)
+	cmd.PersistentFlags().Lookup("token").Value.Set(ApiToken)
+
+	cmd.SetArgs(os.Args[1:])
+	err := cmd.Execute()
+	if daemonListener != nil {
+		daemonListener.Close()
+	}
+	logging.Close()
+	if err != nil {
+		os.Exit(1)
+	}
+	// the log is only kept if something went wrong
+	if dir, err := daemon.Dir(); err == nil {
+		os.Remove(daemon.LogPath(dir, id))
+	}
+	os.Exit(0)
+}
+
+// serveDaemon answers requests on the background session's control socket
+// until it's asked to stop
+func serveDaemon(app *conduit.App) error {
+	dir, err := daemon.Dir()
+	if err != nil {
+		return err
+	}
+	daemonListener, err = daemon.Listen(daemon.SocketPath(dir, daemonID))
+	if err != nil {
+		return err
+	}
+
+	startedAt := time.Now()
+	stop := make(chan struct{})
+	var once sync.Once
+	go daemon.Serve(daemonListener, func() daemon.Status {
+		return daemon.Status{
+			ID:        daemonID,
+			PID:       os.Getpid(),
+			StartedAt: startedAt,
+			Org:       ConduitOrg,
+			Space:     ConduitSpace,
+			AppName:   ConduitAppName,
+			Instances: app.ConnectionInfo(),
+		}
+	}, func() {
+		once.Do(func() { close(stop) })
+	})
+	logging.Info("background session", daemonID, "is ready")
+
+	select {
+	case <-shutdown:
+	case <-stop:
+	}
+	logging.Info("background session", daemonID, "is stopping")
+	return nil
+}
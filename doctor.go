@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/conduit"
+	"github.com/alphagov/paas-cf-conduit/logging"
+	"github.com/alphagov/paas-cf-conduit/util"
+
+	"github.com/spf13/cobra"
+)
+
+var Doctor = &cobra.Command{
+	Use: "doctor [flags] [SERVICE_INSTANCE]",
+	Example: `  Check that the API, UAA and ssh-proxy are working for the targeted space:
+  cf conduit doctor
+
+  Also deploy a conduit app and check it can reach a service instance:
+  cf conduit doctor postgres-instance
+  `,
+	Short: "checks each step of connecting to a service instance",
+	Long:  "checks each step conduit takes to connect to a service instance in isolation (the API, the access token, UAA's one-time SSH code, the ssh-proxy's host key, whether SSH is allowed, and if a service instance is given, the conduit app, the binding, the SSH connection and whether the service can be reached and TLS started from the app) and reports how to fix any that fail.",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if ConduitExistingApp {
+			return errors.New("--existing-app can't be used with doctor, it always deploys a new app")
+		}
+		if ConduitShared {
+			return errors.New("--shared can't be used with doctor, it always deploys a new app")
+		}
+
+		status, done, err := newStatus()
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		d := conduit.NewDoctor(status)
+		defer func() {
+			status.Done()
+			d.Print(os.Stderr)
+		}()
+
+		var cfClient client.Client
+		if !d.Require(
+			"API info",
+			"check `cf api` is the right API and that it's reachable from here",
+			func() (string, error) {
+				var err error
+				cfClient, err = newClient(status)
+				return ApiEndpoint, err
+			},
+		) {
+			return errors.New("one or more checks failed")
+		}
+
+		d.Require(
+			"Access token",
+			"log in again with `cf login`",
+			func() (string, error) {
+				claims, err := util.ParseToken(ApiToken)
+				if err != nil {
+					return "", err
+				}
+				if claims.Expiry.IsZero() {
+					return claims.UserName, nil
+				}
+				if time.Now().After(claims.Expiry) {
+					return "", fmt.Errorf("expired at %s", claims.Expiry.Format(time.RFC3339))
+				}
+				return fmt.Sprintf("%s, expires in %s", claims.UserName, time.Until(claims.Expiry).Round(time.Second)), nil
+			},
+		)
+
+		app, err := newAppForClient(cfClient, status, args, []string{})
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := app.Teardown(); err != nil {
+				logging.Error(err)
+			}
+		}()
+
+		app.Diagnose(d)
+
+		if d.Failed() {
+			return errors.New("one or more checks failed")
+		}
+		return nil
+	},
+	SilenceUsage: true,
+}
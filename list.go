@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/daemon"
+
+	"github.com/spf13/cobra"
+)
+
+var List = &cobra.Command{
+	Use: "list",
+	Example: `  List the tunnels running in the background:
+  cf conduit list
+  `,
+	Short: "lists the background sessions started with --detach",
+	Long:  "lists the conduit sessions running in the background on this machine, which were started with --detach, and the service instances each is connected to.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := daemon.Dir()
+		if err != nil {
+			return err
+		}
+		statuses, err := daemon.List(dir)
+		if err != nil {
+			return err
+		}
+		if len(statuses) == 0 {
+			fmt.Fprintln(os.Stderr, "No background sessions are running")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tPID\tSTARTED\tSPACE\tSERVICES")
+		for _, s := range statuses {
+			services := []string{}
+			for _, info := range s.Instances {
+				services = append(services, fmt.Sprintf("%s (%s)", info.Name, net.JoinHostPort(info.LocalHost, strconv.FormatInt(info.LocalPort, 10))))
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s/%s\t%s\n",
+				s.ID, s.PID, s.StartedAt.Format(time.RFC3339), s.Org, s.Space, strings.Join(services, ", "))
+		}
+		return w.Flush()
+	},
+	SilenceUsage: true,
+}
@@ -1,20 +1,232 @@
 package logging
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+	LevelTrace
+)
+
+var levelNames = []string{"error", "warn", "info", "debug", "trace"}
+
+func (l Level) String() string {
+	if l < LevelError || l > LevelTrace {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel converts a level name (error, warn, info, debug or trace) to a Level
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("invalid log level: %s, valid levels are %s", name, strings.Join(levelNames, ", "))
+}
+
 var (
+	// Verbose is set by the --verbose flag and reflects whether debug
+	// logging is enabled once Configure has been called
 	Verbose bool
+
+	mu         sync.Mutex
+	configured bool
+	level      = LevelInfo
+	jsonFormat bool
+	output     io.Writer = os.Stderr
+	toFile     bool
+	logFile    *os.File
+	std        = &Logger{}
 )
 
-func Debug(args ...interface{}) {
-	if Verbose {
-		fmt.Fprintln(os.Stderr, args...)
+// Configure sets the log level, the format (text or json) and optionally a
+// file to log to. An empty level means debug when Verbose is set and info
+// otherwise. When logging to a file, warnings and errors are still printed
+// to stderr.
+func Configure(levelName string, format string, path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if levelName == "" {
+		level = LevelInfo
+		if Verbose {
+			level = LevelDebug
+		}
+	} else {
+		l, err := ParseLevel(levelName)
+		if err != nil {
+			return err
+		}
+		level = l
+	}
+	Verbose = level >= LevelDebug
+	configured = true
+
+	switch format {
+	case "", "text":
+		jsonFormat = false
+	case "json":
+		jsonFormat = true
+	default:
+		return fmt.Errorf("invalid log format: %s, valid formats are text, json", format)
 	}
+
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+	output = os.Stderr
+	toFile = false
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %s", err)
+		}
+		logFile = f
+		output = f
+		toFile = true
+	}
+	return nil
+}
+
+// Close closes the log file, if there is one
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if logFile == nil {
+		return nil
+	}
+	err := logFile.Close()
+	logFile = nil
+	output = os.Stderr
+	toFile = false
+	return err
+}
+
+// Enabled reports whether messages at level l are logged
+func Enabled(l Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return l <= currentLevel()
+}
+
+// currentLevel falls back to the Verbose flag if Configure hasn't been called
+func currentLevel() Level {
+	if !configured && Verbose {
+		return LevelDebug
+	}
+	return level
+}
+
+type field struct {
+	key   string
+	value interface{}
 }
 
-func Error(args ...interface{}) {
-	fmt.Fprintln(os.Stderr, args...)
+// Logger writes levelled messages tagged with a component (e.g. tunnel,
+// tls, client or provider) and any fields added with With
+type Logger struct {
+	component string
+	fields    []field
 }
+
+// For returns a logger for the named component
+func For(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// With returns a copy of the logger which adds the given field to every message
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	return &Logger{
+		component: l.component,
+		fields:    append(fields, field{key, value}),
+	}
+}
+
+func (l *Logger) Error(args ...interface{}) { l.log(LevelError, args) }
+func (l *Logger) Warn(args ...interface{})  { l.log(LevelWarn, args) }
+func (l *Logger) Info(args ...interface{})  { l.log(LevelInfo, args) }
+func (l *Logger) Debug(args ...interface{}) { l.log(LevelDebug, args) }
+func (l *Logger) Trace(args ...interface{}) { l.log(LevelTrace, args) }
+
+func (l *Logger) log(lvl Level, args []interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if lvl > currentLevel() {
+		return
+	}
+	msg := Redact(strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+
+	if toFile && lvl <= LevelWarn {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+
+	switch {
+	case jsonFormat:
+		fmt.Fprintln(output, l.jsonLine(lvl, msg))
+	case toFile:
+		fmt.Fprintln(output, l.textLine(lvl, msg))
+	default:
+		// plain messages on the terminal, as we've always done
+		fmt.Fprintln(output, msg+l.fieldsText())
+	}
+}
+
+func (l *Logger) textLine(lvl Level, msg string) string {
+	line := time.Now().UTC().Format(time.RFC3339Nano) + " " + strings.ToUpper(lvl.String())
+	if l.component != "" {
+		line += " [" + l.component + "]"
+	}
+	return line + " " + msg + l.fieldsText()
+}
+
+func (l *Logger) fieldsText() string {
+	text := ""
+	for _, f := range l.fields {
+		text += fmt.Sprintf(" %s=%v", f.key, Redact(fmt.Sprint(f.value)))
+	}
+	return text
+}
+
+func (l *Logger) jsonLine(lvl Level, msg string) string {
+	entry := map[string]interface{}{}
+	for _, f := range l.fields {
+		entry[f.key] = Redact(fmt.Sprint(f.value))
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = lvl.String()
+	entry["msg"] = msg
+	if l.component != "" {
+		entry["component"] = l.component
+	}
+	// map keys are sorted by encoding/json, so lines have a stable layout
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Sprintf(`{"level":"error","msg":"failed to encode log entry: %s"}`, err)
+	}
+	return string(b)
+}
+
+func Error(args ...interface{}) { std.log(LevelError, args) }
+func Warn(args ...interface{})  { std.log(LevelWarn, args) }
+func Info(args ...interface{})  { std.log(LevelInfo, args) }
+func Debug(args ...interface{}) { std.log(LevelDebug, args) }
+func Trace(args ...interface{}) { std.log(LevelTrace, args) }
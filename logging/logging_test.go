@@ -0,0 +1,88 @@
+package logging_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/logging"
+)
+
+var _ = Describe("Logger", func() {
+	var logPath string
+
+	BeforeEach(func() {
+		logPath = filepath.Join(GinkgoT().TempDir(), "conduit.log")
+	})
+
+	AfterEach(func() {
+		Expect(logging.Close()).To(Succeed())
+		logging.Verbose = false
+		Expect(logging.Configure("", "text", "")).To(Succeed())
+	})
+
+	readLines := func() []string {
+		b, err := os.ReadFile(logPath)
+		Expect(err).ToNot(HaveOccurred())
+		return strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+
+	It("rejects unknown levels and formats", func() {
+		Expect(logging.Configure("loud", "text", "")).To(MatchError(ContainSubstring("invalid log level")))
+		Expect(logging.Configure("info", "xml", "")).To(MatchError(ContainSubstring("invalid log format")))
+	})
+
+	It("defaults to debug when verbose", func() {
+		logging.Verbose = true
+		Expect(logging.Configure("", "text", "")).To(Succeed())
+		Expect(logging.Enabled(logging.LevelDebug)).To(BeTrue())
+		Expect(logging.Enabled(logging.LevelTrace)).To(BeFalse())
+	})
+
+	It("sets Verbose from the level", func() {
+		Expect(logging.Configure("trace", "text", "")).To(Succeed())
+		Expect(logging.Verbose).To(BeTrue())
+		Expect(logging.Configure("warn", "text", "")).To(Succeed())
+		Expect(logging.Verbose).To(BeFalse())
+	})
+
+	It("writes text lines with the level, component and fields to the log file", func() {
+		Expect(logging.Configure("debug", "text", logPath)).To(Succeed())
+
+		logging.For("tunnel").With("local", "localhost:7080").Debug("listening")
+		logging.For("tunnel").Trace("not logged")
+
+		lines := readLines()
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(MatchRegexp(`^\S+ DEBUG \[tunnel\] listening local=localhost:7080$`))
+	})
+
+	It("writes JSON lines", func() {
+		Expect(logging.Configure("info", "json", logPath)).To(Succeed())
+
+		logging.For("client").With("status", 201).Info("api request")
+		logging.Debug("not logged")
+
+		lines := readLines()
+		Expect(lines).To(HaveLen(1))
+		entry := map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(lines[0]), &entry)).To(Succeed())
+		Expect(entry).To(HaveKeyWithValue("level", "info"))
+		Expect(entry).To(HaveKeyWithValue("component", "client"))
+		Expect(entry).To(HaveKeyWithValue("msg", "api request"))
+		Expect(entry).To(HaveKeyWithValue("status", "201"))
+		Expect(entry).To(HaveKey("time"))
+	})
+
+	It("redacts secrets in messages and fields", func() {
+		Expect(logging.Configure("info", "text", logPath)).To(Succeed())
+
+		logging.For("client").With("uri", "postgres://u:hunter2@db/x").Info("connecting to postgres://u:hunter2@db/x")
+
+		Expect(readLines()[0]).ToNot(ContainSubstring("hunter2"))
+	})
+})
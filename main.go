@@ -12,7 +12,10 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 
 	"code.cloudfoundry.org/cli/plugin"
+	"github.com/alphagov/paas-cf-conduit/conduit"
 	"github.com/alphagov/paas-cf-conduit/logging"
+	"github.com/alphagov/paas-cf-conduit/query"
+	"github.com/alphagov/paas-cf-conduit/util"
 	"github.com/spf13/cobra"
 )
 
@@ -21,16 +24,40 @@ var (
 	ConduitNoDelete    bool
 	ConduitExistingApp bool
 	ConduitReuse       bool
+	ConduitShared      bool
 	ConduitAppName     string
 	ConduitOrg         string
 	ConduitSpace       string
 	ConduitLocalPort   int64
+	PortMappings       []string
+	EphemeralPorts     bool
+	BindAddress        string
+	CredentialsHost    string
+	UnixSockets        bool
+	LocalTLS           bool
 	ApiEndpoint        string
 	ApiToken           string
 	ApiInsecure        bool
 	RawBindParameters  string
 	CipherSuites       []string
 	MinTLSVersion      string
+	TLSCAFile          string
+	TLSClientCert      string
+	TLSClientKey       string
+	TLSTunnel          bool
+	ExportEnvFormat    string
+	ExportEnvFile      string
+	OutputFormat       string
+	OutputFile         string
+	LogLevel           string
+	LogFormat          string
+	LogFile            string
+	EventsSink         string
+	GracePeriod        time.Duration
+	ScriptFile         string
+	ContinueOnError    bool
+	Shell              bool
+	ClientType         string
 	shutdown           chan struct{}
 )
 
@@ -62,15 +89,25 @@ func GenerateRandomString(length int) string {
 	return string(bytes)
 }
 
-func main() {
-	if terminal.IsTerminal(int(os.Stdout.Fd())) && terminal.IsTerminal(int(os.Stderr.Fd())) {
-		NonInteractive = false
-	} else {
-		NonInteractive = true
+// setup runs after flags are parsed and before any command
+func setup(cmd *cobra.Command, args []string) error {
+	if err := applyConfig(cmd); err != nil {
+		return err
 	}
-	cmd := &cobra.Command{Use: "cf"}
-	cmd.PersistentFlags().BoolVarP(&logging.Verbose, "verbose", "", false, "verbose output")
+	return logging.Configure(LogLevel, LogFormat, LogFile)
+}
+
+// newCommand returns the cf command with the conduit commands and their flags
+func newCommand() *cobra.Command {
+	cmd := &cobra.Command{Use: "cf", PersistentPreRunE: setup}
+	cmd.PersistentFlags().BoolVarP(&logging.Verbose, "verbose", "", false, "verbose output (same as --log-level debug)")
+	cmd.PersistentFlags().StringVar(&LogLevel, "log-level", "", "log level: error, warn, info, debug or trace (default info)")
+	cmd.PersistentFlags().StringVar(&LogFormat, "log-format", "text", "log format: text or json")
+	cmd.PersistentFlags().StringVar(&EventsSink, "events", "", "write newline-delimited JSON progress events to a file or an inherited file descriptor (fd:N)")
+	cmd.PersistentFlags().StringVar(&LogFile, "log-file", "", "write logs to this file, warnings and errors are also shown on stderr")
+	cmd.PersistentFlags().BoolVar(&logging.ShowSecrets, "show-secrets", false, "show passwords and other secrets in connection info and logs")
 	cmd.PersistentFlags().BoolVarP(&NonInteractive, "no-interactive", "", NonInteractive, "disable progress indicator and status output")
+	cmd.PersistentFlags().StringVar(&ProfileName, "profile", "", "use the options in this profile from ~/.cf/conduit.yml, flags override them")
 	cmd.PersistentFlags().StringVarP(&ConduitOrg, "org", "o", "", "target org (defaults to currently targeted org)")
 	cmd.PersistentFlags().StringVarP(&ConduitSpace, "space", "s", "", "target space (defaults to currently targeted space)")
 	cmd.PersistentFlags().BoolVarP(&ConduitExistingApp, "existing-app", "e", false, "use an existing app (named by --app-name) instead of creating one")
@@ -79,7 +116,14 @@ func main() {
 	cmd.PersistentFlags().MarkDeprecated("reuse", "please use --no-delete instead")
 	cmd.PersistentFlags().MarkHidden("reuse")
 	cmd.PersistentFlags().StringVarP(&ConduitAppName, "app-name", "n", "", "app name to use for tunnelling app (must not exist unless --existing-app is used)")
+	cmd.PersistentFlags().BoolVar(&ConduitShared, "shared", false, "use one conduit app shared by everyone in the space who gives --shared, which is stopped rather than deleted when no one is using it")
 	cmd.PersistentFlags().Int64VarP(&ConduitLocalPort, "local-port", "p", 7080, "start selecting local ports from")
+	cmd.PersistentFlags().StringSliceVar(&PortMappings, "port", []string{}, "use a fixed local port for a service instance (INSTANCE=PORT), can be given more than once")
+	cmd.PersistentFlags().BoolVar(&EphemeralPorts, "ephemeral-ports", false, "let the OS choose the local ports which aren't fixed by --port")
+	cmd.PersistentFlags().StringVar(&BindAddress, "bind-address", "", "listen on this address rather than localhost, e.g. :: for IPv6 or 0.0.0.0 for containers (anyone who can reach it can connect)")
+	cmd.PersistentFlags().StringVar(&CredentialsHost, "credentials-host", "", "point the credentials at this host rather than the bind address, e.g. host.docker.internal")
+	cmd.PersistentFlags().BoolVar(&UnixSockets, "unix-sockets", false, "also listen on a Unix domain socket for postgres and redis instances")
+	cmd.PersistentFlags().BoolVar(&LocalTLS, "local-tls", false, "serve TLS on the local ports of postgres and redis instances, with a certificate from a temporary CA")
 	cmd.PersistentFlags().StringVar(&ApiEndpoint, "endpoint", "", "set API endpoint")
 	cmd.PersistentFlags().MarkHidden("endpoint")
 	cmd.PersistentFlags().StringVar(&ApiToken, "token", "", "set API token")
@@ -88,19 +132,65 @@ func main() {
 	cmd.PersistentFlags().MarkHidden("insecure")
 	cmd.PersistentFlags().StringVarP(&RawBindParameters, "bind-parameters", "c", "{}", "bind parameters in JSON format")
 	cmd.PersistentFlags().StringSliceVar(&CipherSuites, "cipher-suites", []string{}, "list of cipher suites to use")
-	cmd.PersistentFlags().StringVar(&MinTLSVersion, "minimum-tls-version", "", "set minimum TLS version (e.g. TLS13)")
+	cmd.PersistentFlags().StringVar(&MinTLSVersion, "minimum-tls-version", "TLS12", "set minimum TLS version (e.g. TLS13)")
+	cmd.PersistentFlags().StringVar(&TLSCAFile, "tls-ca-file", "", "PEM file of CA certificates to verify services with, as well as the system's")
+	cmd.PersistentFlags().StringVar(&TLSClientCert, "tls-client-cert", "", "PEM file of a client certificate to authenticate to services with")
+	cmd.PersistentFlags().StringVar(&TLSClientKey, "tls-client-key", "", "PEM file of the key of --tls-client-cert")
+	cmd.PersistentFlags().BoolVar(&TLSTunnel, "tls-tunnel", false, "start TLS with postgres and TLS-enabled redis instances in conduit, so that clients can connect without it")
+	cmd.PersistentFlags().StringVar(&ExportEnvFormat, "export-env", "", "keep the tunnel open and write the connection environment instead of running a command (sh, fish, powershell, dotenv or json)")
+	cmd.PersistentFlags().StringVar(&ExportEnvFile, "export-env-file", "", "write the environment exported by --export-env to this file instead of stdout")
+	cmd.PersistentFlags().StringVar(&OutputFormat, "output", "", "write connection info once the tunnels are up (json, yaml or template=TEMPLATE)")
+	cmd.PersistentFlags().StringVar(&OutputFile, "output-file", "", "write the connection info requested by --output to this file instead of stdout")
+	cmd.PersistentFlags().DurationVar(&GracePeriod, "grace-period", conduit.DefaultGracePeriod, "how long the command has to exit after being interrupted before it is killed")
+	cmd.PersistentFlags().StringVar(&ScriptFile, "script", "", "run each command in this file in turn against the same tunnels, one per line")
+	cmd.PersistentFlags().BoolVar(&ContinueOnError, "continue-on-error", false, "carry on running the --script after a command fails")
+	cmd.PersistentFlags().BoolVar(&Shell, "shell", false, "run the command through your shell, so it can use pipes and redirection")
+	cmd.PersistentFlags().StringVar(&ClientType, "client-type", "", "the service type (e.g. postgres) whose environment the command is given, rather than working it out from the program")
+	Backup.Flags().StringVar(&BackupFile, "file", "", "file to write the backup to")
+	Backup.Flags().StringVar(&BackupCompression, "compress", "", "compress the backup with "+strings.Join(util.Compressions, ", ")+" (default from the file extension: .gz or .zst)")
+	ConnectService.AddCommand(Backup)
+	Restore.Flags().StringVar(&RestoreFile, "input", "", "backup file to restore")
+	Restore.Flags().StringVar(&RestoreConfirm, "confirm", "", "name of the service instance, to restore to it even though it isn't empty")
+	Restore.Flags().StringVar(&RestoreFormat, "format", "", "format of a backup with no metadata: pg_dump-custom, pg_dump-plain or mysqldump-sql")
+	Restore.Flags().StringVar(&RestoreCompression, "compress", "", "compression of a backup with no metadata: "+strings.Join(util.Compressions, ", ")+" (default from the file extension: .gz or .zst)")
+	ConnectService.AddCommand(Restore)
+	Copy.Flags().StringSliceVar(&CopyTables, "tables", []string{}, "only copy these tables")
+	Copy.Flags().StringVar(&CopyDstOrg, "dst-org", "", "org of the destination service instance (defaults to --org)")
+	Copy.Flags().StringVar(&CopyDstSpace, "dst-space", "", "space of the destination service instance (defaults to --space)")
+	Copy.Flags().StringVar(&CopyConfirm, "confirm", "", "name of the destination service instance, to copy to it even though it isn't empty")
+	ConnectService.AddCommand(Copy)
+	Query.Flags().StringVar(&QuerySQL, "sql", "", "the query to run")
+	Query.Flags().StringArrayVar(&QueryParams, "param", []string{}, "a parameter for the query, can be given more than once")
+	Query.Flags().StringVar(&QueryFormat, "format", "table", "output format: "+strings.Join(query.Formats, ", "))
+	Query.Flags().BoolVar(&QueryReadWrite, "write", false, "run the query in a read-write transaction, which is committed if it succeeds")
+	ConnectService.AddCommand(Query)
+	ConnectService.AddCommand(Doctor)
+	Cleanup.Flags().BoolVar(&CleanupAllSpaces, "all-spaces", false, "clean up every space in the org, not just --space")
+	Cleanup.Flags().DurationVar(&CleanupOlderThan, "older-than", 2*time.Hour, "only delete apps which haven't been in use for this long, so that tunnels in use aren't closed")
+	Cleanup.Flags().BoolVar(&CleanupDryRun, "dry-run", false, "list the apps which would be deleted without deleting them")
+	ConnectService.AddCommand(Cleanup)
+	ConnectService.Flags().BoolVar(&Detach, "detach", false, "keep the tunnels open in a background session, which can be stopped with `cf conduit stop`")
+	ConnectService.AddCommand(List)
+	ConnectService.AddCommand(Status)
+	Stop.Flags().BoolVar(&StopAll, "all", false, "stop every background session")
+	Stop.Flags().DurationVar(&StopTimeout, "timeout", 2*time.Minute, "how long to wait for each session to tear down")
+	ConnectService.AddCommand(Stop)
 	cmd.AddCommand(ConnectService)
 	cmd.AddCommand(Uninstall)
+	return cmd
+}
 
-	if len(CipherSuites) == 0 && os.Getenv("CF_CONDUIT_CIPHERSUITES") != "" {
-		CipherSuites = strings.Split(os.Getenv("CF_CONDUIT_CIPHERSUITES"), ",")
+func main() {
+	if terminal.IsTerminal(int(os.Stdout.Fd())) && terminal.IsTerminal(int(os.Stderr.Fd())) {
+		NonInteractive = false
+	} else {
+		NonInteractive = true
 	}
+	cmd := newCommand()
 
-	if MinTLSVersion == "" {
-		MinTLSVersion = "TLS12"
-		if os.Getenv("CF_CONDUIT_MIN_TLS_VERSION") != "" {
-			MinTLSVersion = os.Getenv("CF_CONDUIT_MIN_TLS_VERSION")
-		}
+	if id := os.Getenv(daemonIDEnv); id != "" {
+		runDaemon(cmd, id)
+		return
 	}
 
 	plugin.Start(&Plugin{cmd})
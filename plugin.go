@@ -9,8 +9,16 @@ import (
 	"code.cloudfoundry.org/cli/plugin"
 
 	"github.com/alphagov/paas-cf-conduit/conduit"
+	"github.com/alphagov/paas-cf-conduit/logging"
 )
 
+// Version is the version of the plugin
+var Version = plugin.VersionType{
+	Major: 0,
+	Minor: 1,
+	Build: 2,
+}
+
 type Plugin struct {
 	cmd *cobra.Command
 }
@@ -50,9 +58,12 @@ func (p *Plugin) Run(conn plugin.CliConnection, args []string) {
 		p.cmd.PersistentFlags().Lookup("insecure").Value.Set("true")
 	}
 	// parse
+	pluginArgs = args
 	p.cmd.SetArgs(args)
 	exitCode := 1
-	if err := p.cmd.Execute(); err != nil {
+	err = p.cmd.Execute()
+	logging.Close()
+	if err != nil {
 		if exitError, ok := err.(conduit.AppExecution); ok {
 			exitCode = exitError.ExitCode
 		}
@@ -65,12 +76,8 @@ func (p *Plugin) Run(conn plugin.CliConnection, args []string) {
 
 func (p *Plugin) GetMetadata() plugin.PluginMetadata {
 	meta := plugin.PluginMetadata{
-		Name: "conduit",
-		Version: plugin.VersionType{
-			Major: 0,
-			Minor: 1,
-			Build: 2,
-		},
+		Name:    "conduit",
+		Version: Version,
 		MinCliVersion: plugin.VersionType{
 			Major: 6,
 			Minor: 26,
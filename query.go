@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/alphagov/paas-cf-conduit/logging"
+	"github.com/alphagov/paas-cf-conduit/query"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	QuerySQL       string
+	QueryParams    []string
+	QueryFormat    string
+	QueryReadWrite bool
+)
+
+var Query = &cobra.Command{
+	Use: "query [flags] SERVICE_INSTANCE --sql QUERY",
+	Example: `  Run a query against a postgres database:
+  cf conduit query postgres-instance --sql "SELECT id, email FROM users LIMIT 10"
+
+  Pass parameters ($1, $2... for postgres or ? for mysql) and write CSV:
+  cf conduit query mysql-instance --sql "SELECT * FROM orders WHERE status = ?" --param pending --format csv
+  `,
+	Short: "runs a SQL query against a service instance",
+	Long:  "runs a SQL query against a postgres or mysql service instance without needing a client installed, and writes the results as a table, CSV, JSON or NDJSON. Queries run in a read-only transaction unless --write is given.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instanceName := args[0]
+
+		if QuerySQL == "" {
+			return errors.New("--sql is required")
+		}
+		rowWriter, err := query.NewRowWriter(os.Stdout, QueryFormat)
+		if err != nil {
+			return err
+		}
+
+		status, done, err := newStatus()
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		app, err := newApp(status, []string{instanceName}, []string{})
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := app.Teardown(); err != nil {
+				logging.Error(err)
+			}
+		}()
+
+		if err := openTunnels(app, status); err != nil {
+			return err
+		}
+
+		info, err := app.InstanceConnectionInfo(instanceName)
+		if err != nil {
+			return err
+		}
+		db, err := query.Open(info.Service, info.Credentials)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-shutdown:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		if err := query.Run(ctx, db, QuerySQL, QueryParams, QueryReadWrite, rowWriter); err != nil {
+			return fmt.Errorf("query failed: %s", err)
+		}
+		return nil
+	},
+	SilenceUsage: true,
+}
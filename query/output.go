@@ -0,0 +1,200 @@
+package query
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Formats lists the output formats understood by NewRowWriter
+var Formats = []string{"table", "csv", "json", "ndjson"}
+
+// RowWriter writes the results of a query
+type RowWriter interface {
+	Columns(columns []string) error
+	Row(values []interface{}) error
+	// Close finishes the output, but doesn't close the underlying writer
+	Close() error
+}
+
+// NewRowWriter returns a RowWriter for the given format
+func NewRowWriter(w io.Writer, format string) (RowWriter, error) {
+	switch format {
+	case "table":
+		return &tableWriter{w: w}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case "json":
+		return &jsonWriter{w: w}, nil
+	case "ndjson":
+		return &jsonWriter{w: w, ndjson: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown query output format '%s', expected one of: %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+func formatText(v interface{}, null string) string {
+	switch v := v.(type) {
+	case nil:
+		return null
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// tableWriter lines up the rows in columns, so has to see them all first
+type tableWriter struct {
+	w       io.Writer
+	columns []string
+	rows    [][]string
+}
+
+func (t *tableWriter) Columns(columns []string) error {
+	t.columns = columns
+	return nil
+}
+
+func (t *tableWriter) Row(values []interface{}) error {
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = formatText(v, "NULL")
+	}
+	t.rows = append(t.rows, row)
+	return nil
+}
+
+func (t *tableWriter) Close() error {
+	if len(t.columns) == 0 {
+		return nil
+	}
+
+	widths := make([]int, len(t.columns))
+	for i, c := range t.columns {
+		widths[i] = utf8.RuneCountInString(c)
+	}
+	for _, row := range t.rows {
+		for i, v := range row {
+			if n := utf8.RuneCountInString(v); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	line := func(cells []string) string {
+		padded := make([]string, len(cells))
+		for i, c := range cells {
+			padded[i] = c + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c))
+		}
+		return strings.TrimRight(strings.Join(padded, " | "), " ") + "\n"
+	}
+	separators := make([]string, len(widths))
+	for i, w := range widths {
+		separators[i] = strings.Repeat("-", w)
+	}
+
+	out := line(t.columns) + strings.Join(separators, "-+-") + "\n"
+	for _, row := range t.rows {
+		out += line(row)
+	}
+	if len(t.rows) == 1 {
+		out += "(1 row)\n"
+	} else {
+		out += fmt.Sprintf("(%d rows)\n", len(t.rows))
+	}
+	_, err := io.WriteString(t.w, out)
+	return err
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Columns(columns []string) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	return c.w.Write(columns)
+}
+
+func (c *csvWriter) Row(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = formatText(v, "")
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonWriter writes each row as an object keyed by column name, either in
+// an array or one per line
+type jsonWriter struct {
+	w       io.Writer
+	ndjson  bool
+	columns []string
+	count   int
+}
+
+func (j *jsonWriter) Columns(columns []string) error {
+	j.columns = columns
+	if j.ndjson {
+		return nil
+	}
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+func (j *jsonWriter) Row(values []interface{}) error {
+	// built by hand to keep the columns in the order they were selected
+	fields := make([]string, len(values))
+	for i, v := range values {
+		name, err := json.Marshal(j.columns[i])
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fields[i] = string(name) + ":" + string(value)
+	}
+	b := "{" + strings.Join(fields, ",") + "}"
+
+	prefix := ""
+	switch {
+	case j.ndjson:
+	case j.count == 0:
+		prefix = "\n  "
+	default:
+		prefix = ",\n  "
+	}
+	j.count++
+
+	suffix := ""
+	if j.ndjson {
+		suffix = "\n"
+	}
+	_, err := io.WriteString(j.w, prefix+b+suffix)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if j.ndjson {
+		return nil
+	}
+	end := "]\n"
+	if j.count > 0 {
+		end = "\n]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
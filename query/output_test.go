@@ -0,0 +1,84 @@
+package query_test
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/query"
+)
+
+var _ = Describe("RowWriter", func() {
+	var buf *bytes.Buffer
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+	})
+
+	write := func(format string, columns []string, rows ...[]interface{}) string {
+		w, err := query.NewRowWriter(buf, format)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Columns(columns)).To(Succeed())
+		for _, row := range rows {
+			Expect(w.Row(row)).To(Succeed())
+		}
+		Expect(w.Close()).To(Succeed())
+		return buf.String()
+	}
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	It("writes a table", func() {
+		Expect(write("table", []string{"id", "name", "created"},
+			[]interface{}{int64(1), "alice", created},
+			[]interface{}{int64(22), nil, created},
+		)).To(Equal(
+			"id | name  | created\n" +
+				"---+-------+---------------------\n" +
+				"1  | alice | 2020-01-02T03:04:05Z\n" +
+				"22 | NULL  | 2020-01-02T03:04:05Z\n" +
+				"(2 rows)\n",
+		))
+	})
+
+	It("writes an empty table", func() {
+		Expect(write("table", []string{"id"})).To(Equal("id\n--\n(0 rows)\n"))
+	})
+
+	It("writes nothing for a statement which returns no rows", func() {
+		Expect(write("table", []string{})).To(BeEmpty())
+		Expect(write("csv", []string{})).To(BeEmpty())
+	})
+
+	It("writes CSV", func() {
+		Expect(write("csv", []string{"id", "name"},
+			[]interface{}{int64(1), "smith, alice"},
+			[]interface{}{int64(2), nil},
+		)).To(Equal("id,name\n1,\"smith, alice\"\n2,\n"))
+	})
+
+	It("writes JSON with the columns in order", func() {
+		Expect(write("json", []string{"name", "id"},
+			[]interface{}{"alice", int64(1)},
+			[]interface{}{nil, int64(2)},
+		)).To(Equal("[\n  {\"name\":\"alice\",\"id\":1},\n  {\"name\":null,\"id\":2}\n]\n"))
+	})
+
+	It("writes an empty JSON array", func() {
+		Expect(write("json", []string{"id"})).To(Equal("[]\n"))
+	})
+
+	It("writes NDJSON", func() {
+		Expect(write("ndjson", []string{"id"},
+			[]interface{}{int64(1)},
+			[]interface{}{int64(2)},
+		)).To(Equal("{\"id\":1}\n{\"id\":2}\n"))
+	})
+
+	It("rejects unknown formats", func() {
+		_, err := query.NewRowWriter(buf, "xml")
+		Expect(err).To(MatchError("unknown query output format 'xml', expected one of: table, csv, json, ndjson"))
+	})
+})
@@ -0,0 +1,133 @@
+// Package query runs SQL against tunnelled postgres and mysql service
+// instances using Go's database drivers, so no client needs to be installed
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+)
+
+// ServiceTypes lists the service types which can be queried
+var ServiceTypes = []string{"postgres", "mysql"}
+
+// Open connects to a tunnelled service instance of the given type. The
+// credentials must have been pointed at the tunnel.
+func Open(serviceType string, creds client.Credentials) (*sql.DB, error) {
+	driver, dsn, err := DataSourceName(serviceType, creds)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open(driver, dsn)
+}
+
+// DataSourceName returns the driver name and DSN for connecting to a
+// service instance. The server's certificate isn't verified as it's reached
+// through the tunnel on localhost, which its certificate won't be for.
+func DataSourceName(serviceType string, creds client.Credentials) (string, string, error) {
+	host := creds.Host()
+	port := strconv.FormatInt(creds.Port(), 10)
+
+	switch serviceType {
+	case "postgres":
+		sslMode := "prefer"
+		if creds.IsTLSEnabled() {
+			sslMode = "require"
+		}
+		u := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(creds.Username(), creds.Password()),
+			Host:     host + ":" + port,
+			Path:     "/" + creds.Database(),
+			RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+		}
+		return "postgres", u.String(), nil
+	case "mysql":
+		cfg := mysql.NewConfig()
+		cfg.User = creds.Username()
+		cfg.Passwd = creds.Password()
+		cfg.Net = "tcp"
+		cfg.Addr = host + ":" + port
+		cfg.DBName = creds.Database()
+		cfg.TLSConfig = "preferred"
+		if creds.IsTLSEnabled() {
+			cfg.TLSConfig = "skip-verify"
+		}
+		return "mysql", cfg.FormatDSN(), nil
+	default:
+		return "", "", fmt.Errorf("querying %s service instances is not supported", serviceType)
+	}
+}
+
+// Run runs a query with the given parameters in a transaction, and writes
+// any rows it returns to w. The transaction is read-only unless readWrite
+// is set, in which case it's committed if the query succeeds.
+func Run(ctx context.Context, db *sql.DB, query string, params []string, readWrite bool, w RowWriter) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: !readWrite})
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	args := make([]interface{}, len(params))
+	for i, p := range params {
+		args[i] = p
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if err := writeRows(rows, w); err != nil {
+		rows.Close()
+		return err
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func writeRows(rows *sql.Rows, w RowWriter) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if err := w.Columns(columns); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		row := make([]interface{}, len(values))
+		for i, v := range values {
+			// drivers return text as bytes
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			row[i] = v
+		}
+		if err := w.Row(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/alphagov/paas-cf-conduit/conduit"
+	"github.com/alphagov/paas-cf-conduit/logging"
+	"github.com/alphagov/paas-cf-conduit/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	RestoreFile        string
+	RestoreConfirm     string
+	RestoreFormat      string
+	RestoreCompression string
+)
+
+var Restore = &cobra.Command{
+	Use: "restore [flags] SERVICE_INSTANCE --input FILE",
+	Example: `  Restore a backup taken with cf conduit backup to an empty database:
+  cf conduit restore postgres-instance --input backup.dump
+
+  Restore over a database which already has tables:
+  cf conduit restore postgres-instance --input backup.dump --confirm postgres-instance
+
+  Restore a plain SQL file which wasn't taken with cf conduit backup:
+  cf conduit restore postgres-instance --input backup.sql --format pg_dump-plain
+  `,
+	Short: "restores a service instance from a backup",
+	Long:  "restores a backup taken with cf conduit backup to a service instance of the same type, after checking it against the checksum in its metadata. Service instances which aren't empty are only restored to if --confirm is given.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		instanceName := args[0]
+
+		if RestoreFile == "" {
+			return errors.New("--input is required")
+		}
+
+		meta, err := restoreMetadata()
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(RestoreFile)
+		if err != nil {
+			return fmt.Errorf("failed to open backup: %s", err)
+		}
+		defer f.Close()
+
+		status, done, err := newStatus()
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		app, err := newApp(status, []string{instanceName}, []string{})
+		if err != nil {
+			return err
+		}
+		app.UseProviderClients()
+
+		defer func() {
+			if err := app.Teardown(); err != nil {
+				logging.Error(err)
+			}
+		}()
+
+		if err := openTunnels(app, status); err != nil {
+			return err
+		}
+
+		if err := app.Restore(instanceName, f, meta, RestoreConfirm); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "\nRestored %s to %s\n", RestoreFile, instanceName)
+		return nil
+	},
+	SilenceUsage: true,
+}
+
+// restoreMetadata reads and checks the metadata of the backup being
+// restored, or makes it up from the flags if the backup doesn't have any
+func restoreMetadata() (*conduit.BackupMetadata, error) {
+	if _, err := os.Stat(conduit.MetadataPath(RestoreFile)); os.IsNotExist(err) {
+		if RestoreFormat == "" {
+			return nil, fmt.Errorf(
+				"%s has no metadata, use --format to restore a backup which wasn't taken with cf conduit backup",
+				RestoreFile,
+			)
+		}
+		compression := RestoreCompression
+		if compression == "" {
+			compression = util.CompressionForPath(RestoreFile)
+		}
+		return &conduit.BackupMetadata{Format: RestoreFormat, Compression: compression}, nil
+	}
+
+	meta, err := conduit.ReadBackupMetadata(RestoreFile)
+	if err != nil {
+		return nil, err
+	}
+	if RestoreFormat != "" && RestoreFormat != meta.Format {
+		return nil, fmt.Errorf("--format %s doesn't match the %s format in the backup metadata", RestoreFormat, meta.Format)
+	}
+	if RestoreCompression != "" && RestoreCompression != meta.Compression {
+		return nil, fmt.Errorf("--compress %s doesn't match the %s compression in the backup metadata", RestoreCompression, meta.Compression)
+	}
+	if err := conduit.VerifyBackup(RestoreFile, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
@@ -10,9 +10,10 @@ import (
 	"github.com/alphagov/paas-cf-conduit/logging"
 )
 
+var logger = logging.For("provider")
+
 type MySQL struct {
-	workDir    string
-	serviceCnt int
+	workDirs []string
 }
 
 func (m *MySQL) IsTLSEnabled(creds client.Credentials) bool {
@@ -20,17 +21,12 @@ func (m *MySQL) IsTLSEnabled(creds client.Credentials) bool {
 }
 
 func (m *MySQL) InitEnv(creds client.Credentials, env map[string]string) error {
-	// We will only set the configuration for the first service
-	if m.serviceCnt > 0 {
-		return nil
-	}
-
-	var err error
-	m.workDir, err = ioutil.TempDir("", "conduit")
+	workDir, err := ioutil.TempDir("", "conduit")
 	if err != nil {
 		return err
 	}
-	mycnfPath := filepath.Join(m.workDir, "my.cnf")
+	m.workDirs = append(m.workDirs, workDir)
+	mycnfPath := filepath.Join(workDir, "my.cnf")
 	mycnf := "[mysql]\n"
 	mycnf += fmt.Sprintf("user = %s\n", creds.Username())
 	mycnf += fmt.Sprintf("password = %s\n", creds.Password())
@@ -42,20 +38,27 @@ func (m *MySQL) InitEnv(creds client.Credentials, env map[string]string) error {
 	mycnf += fmt.Sprintf("password = %s\n", creds.Password())
 	mycnf += fmt.Sprintf("host = %s\n", creds.Host())
 	mycnf += fmt.Sprintf("port = %d\n", creds.Port())
-	if err := ioutil.WriteFile(mycnfPath, []byte(mycnf), 0644); err != nil {
+	if err := ioutil.WriteFile(mycnfPath, []byte(mycnf), 0600); err != nil {
 		return fmt.Errorf("failed to create temporary mysql config: %s", err)
 	}
-	env["MYSQL_HOME"] = m.workDir
-	m.serviceCnt++
+	env["MYSQL_HOME"] = workDir
 
 	return nil
 }
 
+// WorkDirs returns the temporary directories made by InitEnv
+func (m *MySQL) WorkDirs() []string {
+	return m.workDirs
+}
+
 func (m *MySQL) Teardown() error {
-	if m.workDir != "" {
-		logging.Debug("deleting", m.workDir)
-		return os.RemoveAll(m.workDir)
+	for _, workDir := range m.workDirs {
+		logger.Debug("deleting", workDir)
+		if err := os.RemoveAll(workDir); err != nil {
+			return err
+		}
 	}
+	m.workDirs = nil
 	return nil
 }
 
@@ -70,3 +73,28 @@ func (m *MySQL) GetKnownClients() []string {
 func (m *MySQL) AdditionalProgramArgs(serviceInstances []*client.VcapService) []string {
 	return []string{}
 }
+
+func (m *MySQL) BackupFormat() string {
+	return "mysqldump-sql"
+}
+
+func (m *MySQL) BackupCommand(creds client.Credentials, tables []string) []string {
+	// the connection details come from the my.cnf written by InitEnv
+	args := []string{"mysqldump", "--single-transaction", creds.Database()}
+	return append(args, tables...)
+}
+
+func (m *MySQL) RestoreCommand(creds client.Credentials, format string, clean bool) ([]string, error) {
+	if format != "mysqldump-sql" {
+		return nil, fmt.Errorf("can't restore %s backups to mysql, expected mysqldump-sql", format)
+	}
+	// mysqldump drops each table before creating it, so clean is the default
+	return []string{"mysql"}, nil
+}
+
+func (m *MySQL) CountCommand(creds client.Credentials) []string {
+	return []string{
+		"mysql", "--skip-column-names", "--execute",
+		"SELECT count(*) FROM information_schema.tables WHERE table_schema = database()",
+	}
+}
@@ -7,7 +7,6 @@ import (
 )
 
 type Postgres struct {
-	serviceCnt int
 }
 
 func (p *Postgres) IsTLSEnabled(creds client.Credentials) bool {
@@ -15,18 +14,11 @@ func (p *Postgres) IsTLSEnabled(creds client.Credentials) bool {
 }
 
 func (p *Postgres) InitEnv(creds client.Credentials, env map[string]string) error {
-	// We will only set the configuration for the first service
-	if p.serviceCnt > 0 {
-		return nil
-	}
-
 	env["PGDATABASE"] = creds.Database()
 	env["PGHOST"] = creds.Host()
 	env["PGPORT"] = fmt.Sprintf("%d", creds.Port())
 	env["PGUSER"] = creds.Username()
 	env["PGPASSWORD"] = creds.Password()
-
-	p.serviceCnt++
 	return nil
 }
 
@@ -45,3 +37,41 @@ func (p *Postgres) GetKnownClients() []string {
 func (p *Postgres) AdditionalProgramArgs(serviceInstances []*client.VcapService) []string {
 	return []string{}
 }
+
+func (p *Postgres) BackupFormat() string {
+	return "pg_dump-custom"
+}
+
+func (p *Postgres) BackupCommand(creds client.Credentials, tables []string) []string {
+	// the connection details come from the environment set by InitEnv
+	args := []string{"pg_dump", "--format=custom"}
+	for _, table := range tables {
+		args = append(args, "--table", table)
+	}
+	return args
+}
+
+func (p *Postgres) RestoreCommand(creds client.Credentials, format string, clean bool) ([]string, error) {
+	switch format {
+	case "pg_dump-custom":
+		args := []string{"pg_restore", "--no-owner", "--no-acl", "--exit-on-error", "--single-transaction"}
+		if clean {
+			// drop what's in the way first, rather than failing on it
+			args = append(args, "--clean", "--if-exists")
+		}
+		return append(args, "--dbname", creds.Database()), nil
+	case "pg_dump-plain":
+		// plain dumps only drop what's in the way if they were made with
+		// pg_dump --clean
+		return []string{"psql", "--quiet", "--single-transaction", "--set", "ON_ERROR_STOP=1"}, nil
+	default:
+		return nil, fmt.Errorf("can't restore %s backups to postgres, expected pg_dump-custom or pg_dump-plain", format)
+	}
+}
+
+func (p *Postgres) CountCommand(creds client.Credentials) []string {
+	return []string{
+		"psql", "--no-align", "--tuples-only", "--command",
+		"SELECT count(*) FROM information_schema.tables WHERE table_schema NOT IN ('pg_catalog', 'information_schema')",
+	}
+}
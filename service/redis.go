@@ -35,9 +35,22 @@ func (r *Redis) AdditionalProgramArgs(serviceInstances []*client.VcapService) []
 		return []string{}
 	}
 
+	return connectionArgs(serviceInstances[0].Credentials)
+}
+
+func (r *Redis) BackupFormat() string {
+	return "redis-rdb"
+}
+
+func (r *Redis) BackupCommand(creds client.Credentials, tables []string) []string {
+	args := append([]string{"redis-cli"}, connectionArgs(creds)...)
+	return append(args, "--rdb", "-")
+}
+
+func connectionArgs(creds client.Credentials) []string {
 	return []string{
-		"-h", serviceInstances[0].Credentials.Host(),
-		"-p", fmt.Sprintf("%d", serviceInstances[0].Credentials.Port()),
-		"-a", serviceInstances[0].Credentials.Password(),
+		"-h", creds.Host(),
+		"-p", fmt.Sprintf("%d", creds.Port()),
+		"-a", creds.Password(),
 	}
 }
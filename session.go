@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alphagov/paas-cf-conduit/client"
+	"github.com/alphagov/paas-cf-conduit/conduit"
+	"github.com/alphagov/paas-cf-conduit/logging"
+	"github.com/alphagov/paas-cf-conduit/service"
+	"github.com/alphagov/paas-cf-conduit/tls"
+	"github.com/alphagov/paas-cf-conduit/util"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// newStatus creates the status writer for a command, which also writes
+// progress events if --events is given. The returned function must be
+// called once the command has finished.
+func newStatus() (*util.Status, func(), error) {
+	status := util.NewStatus(os.Stderr, NonInteractive)
+	if EventsSink == "" {
+		return status, status.Done, nil
+	}
+
+	sink, err := util.OpenEventSink(EventsSink)
+	if err != nil {
+		return nil, nil, err
+	}
+	status.AddBackend(util.NewEventStatus(sink))
+	return status, func() {
+		status.Done()
+		sink.Close()
+	}, nil
+}
+
+// newClient creates an API client from the command line flags
+func newClient(status *util.Status) (client.Client, error) {
+	tlsCipherSuites, err := util.CipherSuiteNamesToIDs(CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	versionID, err := util.TLSVersionToID(MinTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	// create a client
+	status.Text("Connecting client")
+	return client.NewClient(ApiEndpoint, ApiToken, ApiInsecure, tlsCipherSuites, versionID)
+}
+
+// newApp creates a conduit app for the given service instances from the
+// command line flags, with all of the service providers registered
+func newApp(status *util.Status, serviceInstanceNames []string, runargs []string) (*conduit.App, error) {
+	// checked before connecting, as that takes a while
+	if _, err := localPorts(); err != nil {
+		return nil, err
+	}
+	if err := checkBindAddress(); err != nil {
+		return nil, err
+	}
+	if _, err := tlsClientCredentials(); err != nil {
+		return nil, err
+	}
+	if err := checkShared(); err != nil {
+		return nil, err
+	}
+
+	cfClient, err := newClient(status)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAppForClient(cfClient, status, serviceInstanceNames, runargs)
+}
+
+// newAppForClient is newApp for an API client which has already been
+// created
+func newAppForClient(cfClient client.Client, status *util.Status, serviceInstanceNames []string, runargs []string) (*conduit.App, error) {
+	if ConduitShared {
+		// the shared app is stopped rather than deleted
+		ConduitAppName = conduit.SharedAppName
+		ConduitNoDelete = true
+	}
+
+	if ConduitNoDelete || ConduitReuse || ConduitExistingApp {
+		// propagate alias, force on if ConduitExistingApp
+		ConduitNoDelete = true
+		ConduitReuse = true
+	}
+
+	if ConduitAppName == "" {
+		if ConduitExistingApp {
+			return nil, errors.New("must specify --app-name of existing app to reuse")
+		}
+
+		ConduitAppName = fmt.Sprintf("__conduit_%s__", GenerateRandomString(8))
+	}
+
+	// already checked by newClient
+	tlsCipherSuites, _ := util.CipherSuiteNamesToIDs(CipherSuites)
+	versionID, _ := util.TLSVersionToID(MinTLSVersion)
+
+	var bindParams map[string]interface{}
+	if err := json.Unmarshal([]byte(RawBindParameters), &bindParams); err != nil {
+		return nil, fmt.Errorf("Could not parse bind parameters as JSON: %s", err)
+	}
+
+	sessionDir, err := conduit.DefaultSessionDir()
+	if err != nil {
+		return nil, err
+	}
+	offerToRecoverSessions(cfClient, status, sessionDir)
+
+	app := conduit.NewApp(
+		cfClient, status,
+		ConduitLocalPort, ConduitOrg, ConduitSpace, ConduitAppName, !ConduitNoDelete,
+		serviceInstanceNames, runargs, bindParams, ApiInsecure, tlsCipherSuites, versionID,
+	)
+
+	app.SetGracePeriod(GracePeriod)
+	app.SetOwner(appOwner())
+	app.SetSession(conduit.NewSession(sessionDir, ApiEndpoint, ConduitOrg, ConduitSpace, ConduitAppName, !ConduitNoDelete))
+	localPorts, err := localPorts()
+	if err != nil {
+		return nil, err
+	}
+	app.SetLocalPorts(localPorts)
+	app.SetEphemeralPorts(EphemeralPorts)
+	app.SetBindAddress(BindAddress)
+	app.SetCredentialsHost(CredentialsHost)
+	app.SetUnixSockets(UnixSockets)
+	app.SetLocalTLS(LocalTLS)
+	tlsCreds, err := tlsClientCredentials()
+	if err != nil {
+		return nil, err
+	}
+	app.SetTLSClientCredentials(tlsCreds)
+	app.SetTLSTunnels(TLSTunnel)
+	app.SetShared(ConduitShared)
+	app.SetExportEnv(ExportEnvFormat != "")
+	if profile != nil {
+		if err := app.SetEnvTemplates(profile.Env); err != nil {
+			return nil, err
+		}
+	}
+
+	app.RegisterServiceProvider("mysql", &service.MySQL{})
+	app.RegisterServiceProvider("postgres", &service.Postgres{})
+	app.RegisterServiceProvider("redis", &service.Redis{})
+	app.RegisterServiceProvider("influxdb", &service.InfluxDB{})
+
+	return app, nil
+}
+
+// localPorts returns the fixed local ports of service instances from the
+// profile and --port, which overrides it
+func localPorts() (map[string]int64, error) {
+	ports := map[string]int64{}
+	if profile != nil {
+		ports = profile.LocalPorts()
+	}
+	for _, mapping := range PortMappings {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid --port %s, expected INSTANCE=PORT", mapping)
+		}
+		port, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid --port %s, the port must be between 1 and 65535", mapping)
+		}
+		ports[parts[0]] = port
+	}
+	return ports, nil
+}
+
+// bindAddressWarning is only given once, when more than one app is created
+var bindAddressWarning sync.Once
+
+// checkBindAddress checks --bind-address is an address which can be
+// listened on, warning if other machines will be able to connect
+func checkBindAddress() error {
+	if BindAddress != "" && BindAddress != "localhost" && net.ParseIP(BindAddress) == nil {
+		return fmt.Errorf("invalid --bind-address %s, expected an IP address", BindAddress)
+	}
+	if !conduit.IsLoopback(BindAddress) {
+		bindAddressWarning.Do(func() {
+			logging.Warn("the tunnels will listen on", BindAddress, "so anyone who can reach this machine on it can connect to the service instances")
+		})
+	}
+	return nil
+}
+
+// checkShared checks --shared isn't given with the flags which choose some
+// other app or change how it's bound
+func checkShared() error {
+	if !ConduitShared {
+		return nil
+	}
+	if ConduitExistingApp {
+		return errors.New("--shared can't be used with --existing-app")
+	}
+	if ConduitAppName != "" && ConduitAppName != conduit.SharedAppName {
+		return errors.New("--shared can't be used with --app-name, the shared app is always " + conduit.SharedAppName)
+	}
+	// the service instances may already be bound to the shared app by
+	// someone else, with other parameters
+	var bindParams map[string]interface{}
+	if err := json.Unmarshal([]byte(RawBindParameters), &bindParams); err == nil && len(bindParams) > 0 {
+		return errors.New("--shared can't be used with --bind-parameters, as the bindings to the shared app are shared too")
+	}
+	return nil
+}
+
+// tlsClientCredentials reads the CA bundle and client certificate given by
+// --tls-ca-file, --tls-client-cert and --tls-client-key
+func tlsClientCredentials() (*tls.ClientCredentials, error) {
+	if (TLSClientCert == "") != (TLSClientKey == "") {
+		return nil, errors.New("--tls-client-cert and --tls-client-key must be given together")
+	}
+	if TLSCAFile == "" && TLSClientCert == "" {
+		return nil, nil
+	}
+	return tls.LoadClientCredentials(TLSCAFile, TLSClientCert, TLSClientKey)
+}
+
+// staleSessions returns the sessions on this API of conduit processes which
+// exited without tearing everything down. Sessions with nothing left to tear
+// down are removed.
+func staleSessions(sessionDir string) []*conduit.Session {
+	sessions, err := conduit.LoadSessions(sessionDir)
+	if err != nil {
+		logging.Warn("failed to read sessions:", err)
+		return nil
+	}
+
+	stale := []*conduit.Session{}
+	for _, s := range sessions {
+		if s.API != ApiEndpoint || !s.Stale() {
+			continue
+		}
+		if s.Empty() {
+			s.Remove()
+			continue
+		}
+		stale = append(stale, s)
+	}
+	return stale
+}
+
+// offerToRecoverSessions asks whether to finish tearing down stale sessions,
+// or if there's no one to ask, says how to
+func offerToRecoverSessions(cfClient client.Client, status *util.Status, sessionDir string) {
+	stale := staleSessions(sessionDir)
+	if len(stale) == 0 {
+		return
+	}
+
+	status.Done()
+	fmt.Fprintf(os.Stderr, "Found %d conduit sessions which weren't torn down:\n", len(stale))
+	for _, s := range stale {
+		fmt.Fprintf(os.Stderr, "  * %s\n", s.Describe())
+	}
+	if NonInteractive || !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintln(os.Stderr, "Run `cf conduit cleanup` to tear them down.")
+		return
+	}
+
+	fmt.Fprint(os.Stderr, "Tear them down now? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		return
+	}
+	for _, s := range stale {
+		if err := conduit.RecoverSession(cfClient, status, s); err != nil {
+			logging.Error("failed to tear down session", s.ID+":", err)
+		}
+	}
+	status.Done()
+}
+
+// appOwner returns who is running conduit, from the access token, and where
+func appOwner() conduit.AppOwner {
+	owner := conduit.AppOwner{
+		Version: fmt.Sprintf("%d.%d.%d", Version.Major, Version.Minor, Version.Build),
+	}
+	if claims, err := util.ParseToken(ApiToken); err == nil {
+		owner.User = claims.UserName
+		if owner.User == "" {
+			// client credentials tokens don't have a user
+			owner.User = claims.ClientID
+		}
+	}
+	owner.Hostname, _ = os.Hostname()
+	return owner
+}
+
+// openTunnels targets the org and space, deploys the conduit app (or
+// prepares the existing or shared one) and opens the tunnels to the service instances
+func openTunnels(app *conduit.App, status *util.Status) error {
+	if err := app.Init(); err != nil {
+		return err
+	}
+
+	if ConduitShared {
+		if err := app.DeploySharedApp(); err != nil {
+			return err
+		}
+	} else if ConduitExistingApp {
+		if err := app.PrepareForExistingApp(); err != nil {
+			return err
+		}
+	} else {
+		if err := app.DeployApp(); err != nil {
+			return err
+		}
+	}
+
+	if err := app.SetupTunnels(); err != nil {
+		return err
+	}
+
+	status.Done()
+	status.Event("tunnels_ready", util.Fields{"instances": len(app.ConnectionInfo())})
+	return nil
+}
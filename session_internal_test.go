@@ -0,0 +1,33 @@
+package main
+
+import (
+	// not dot imported, as its GracePeriod clashes with the flag's
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("checkShared()", func() {
+	ginkgo.BeforeEach(func() {
+		ConduitShared = true
+		ConduitExistingApp = false
+		ConduitAppName = ""
+		RawBindParameters = "{}"
+		ginkgo.DeferCleanup(func() {
+			ConduitShared = false
+		})
+	})
+
+	ginkgo.It("allows the default bind parameters", func() {
+		Expect(checkShared()).To(Succeed())
+	})
+
+	ginkgo.It("rejects bind parameters, as the bindings are shared", func() {
+		RawBindParameters = `{"read_only":true}`
+		Expect(checkShared()).To(MatchError(ContainSubstring("--bind-parameters")))
+	})
+
+	ginkgo.It("rejects another app", func() {
+		ConduitAppName = "my-app"
+		Expect(checkShared()).To(MatchError(ContainSubstring("--app-name")))
+	})
+})
@@ -1,9 +1,11 @@
 package ssh
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,16 +20,57 @@ type ForwardAddrs struct {
 	LocalPort     int64
 	TLSTunnelPort int64
 	RemoteAddr    string
+	// BindAddress is the address the local ports listen on, localhost if
+	// it isn't set
+	BindAddress string
+	// SocketPath is a Unix domain socket which is forwarded as well as the
+	// local port, if it's set
+	SocketPath string
+	// WrapConn, if it's set, is given each local connection before it's
+	// forwarded, e.g. to terminate TLS
+	WrapConn func(net.Conn) (net.Conn, error)
 }
 
 const keepaliveName = "keepalive@github.com/alphagov/paas-cf-conduit"
 
+var logger = logging.For("tunnel")
+
 func (f ForwardAddrs) LocalAddress() string {
-	return fmt.Sprintf("localhost:%d", f.LocalPort)
+	return net.JoinHostPort(f.bindHost(), strconv.FormatInt(f.LocalPort, 10))
 }
 
 func (f ForwardAddrs) TLSTunnelAddress() string {
-	return fmt.Sprintf("localhost:%d", f.TLSTunnelPort)
+	return net.JoinHostPort(f.bindHost(), strconv.FormatInt(f.TLSTunnelPort, 10))
+}
+
+// LocalDialAddress is the address to connect to the local port on, which is
+// the loopback address when listening on every address
+func (f ForwardAddrs) LocalDialAddress() string {
+	return net.JoinHostPort(f.dialHost(), strconv.FormatInt(f.LocalPort, 10))
+}
+
+// TLSTunnelDialAddress is LocalDialAddress for the TLS tunnel's port
+func (f ForwardAddrs) TLSTunnelDialAddress() string {
+	return net.JoinHostPort(f.dialHost(), strconv.FormatInt(f.TLSTunnelPort, 10))
+}
+
+func (f ForwardAddrs) bindHost() string {
+	if f.BindAddress == "" {
+		return "localhost"
+	}
+	return f.BindAddress
+}
+
+func (f ForwardAddrs) dialHost() string {
+	ip := net.ParseIP(f.BindAddress)
+	switch {
+	case ip == nil || !ip.IsUnspecified():
+		return f.bindHost()
+	case ip.To4() != nil:
+		return "127.0.0.1"
+	default:
+		return "::1"
+	}
 }
 
 func (f ForwardAddrs) ConnectAddress() string {
@@ -66,7 +109,7 @@ func (t *Tunnel) passwordPipe() {
 		for {
 			pass, err := t.PasswordFunc()
 			if err != nil {
-				logging.Error(err)
+				logger.Error(err)
 			}
 			t.passwords <- pass
 		}
@@ -81,22 +124,31 @@ func (t *Tunnel) Start() error {
 	}
 	t.passwordPipe()
 	for _, fwd := range t.ForwardAddrs {
-		listener, err := t.forward(fwd)
+		listener, err := t.forward(fwd, "tcp", fwd.LocalAddress())
 		if err != nil {
 			return err
 		}
 		t.listeners = append(t.listeners, listener)
+		// the socket is served by the TLS tunnel if there is one
+		if fwd.SocketPath != "" && fwd.TLSTunnelPort == 0 {
+			listener, err := t.forward(fwd, "unix", fwd.SocketPath)
+			if err != nil {
+				return err
+			}
+			t.listeners = append(t.listeners, listener)
+		}
 	}
 	t.shutdownChan = make(chan struct{})
 	return nil
 }
 
-func (t *Tunnel) forward(fwd ForwardAddrs) (net.Listener, error) {
-	localListener, err := net.Listen("tcp", fwd.LocalAddress())
+func (t *Tunnel) forward(fwd ForwardAddrs, network string, address string) (net.Listener, error) {
+	localListener, err := net.Listen(network, address)
 	if err != nil {
 		return nil, err
 	}
-	logging.Debug("listening", fwd.LocalAddress())
+	log := logger.With("local", address).With("remote", fwd.RemoteAddr)
+	log.Debug("listening", address)
 	go func() {
 		for {
 			localConn, err := localListener.Accept()
@@ -106,54 +158,107 @@ func (t *Tunnel) forward(fwd ForwardAddrs) (net.Listener, error) {
 				t.Unlock()
 				return
 			}
-			// We try several times to make the connection here to workaround
-			// flakey connections that timeout. Once the connection is established
-			// TCP takes care of keeping it working.
-			err = util.Retry(func() error {
-				password := <-t.passwords
-				cfg := &ssh.ClientConfig{
-					User: "cf:" + t.AppGuid + "/0",
-					Auth: []ssh.AuthMethod{ssh.Password(password)},
-					HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-						valid, possible := checkSSHFingerprint(key, t.TunnelHostKey)
-
-						if !valid {
-							return fmt.Errorf(
-								"remote hostkey fingerprint %q did not match any possible values %q",
-								t.TunnelHostKey, possible,
-							)
-						}
-
-						return nil
-					},
-				}
-				logging.Debug("ssh: connecting:", cfg.User, t.TunnelAddr, fmt.Sprintf("'%s'", password))
-				sshConn, err := ssh.Dial("tcp", t.TunnelAddr, cfg)
-				if err != nil {
-					logging.Debug("ssh: connection attempt failed:", err)
-					return fmt.Errorf("error dialing ssh: %s\n", err)
-				}
-				logging.Debug("ssh: connected!:", cfg.User, t.TunnelAddr)
-				go t.startKeepalive(cfg.User, sshConn)
-				logging.Debug("remote: connecting", fwd)
-				remoteConn, err := sshConn.Dial("tcp", fwd.RemoteAddr)
-				if err != nil {
-					logging.Debug("remote: connection attempt failed:", err, fwd)
-					return err
-				}
-				go copyConn(fwd, localConn, remoteConn)
-				go copyConn(fwd, remoteConn, localConn)
-				return nil
-			})
-			if err != nil {
-				logging.Debug("remote: connection fail", err, fwd)
-				localConn.Close()
-			}
+			log.Trace("accepted connection from", localConn.RemoteAddr())
+			go t.handle(fwd, log, localConn)
 		}
 	}()
 	return localListener, nil
 }
 
+// handle forwards a local connection over a new ssh connection
+func (t *Tunnel) handle(fwd ForwardAddrs, log *logging.Logger, localConn net.Conn) {
+	if fwd.WrapConn != nil {
+		wrapped, err := fwd.WrapConn(localConn)
+		if err != nil {
+			log.Warn("local connection failed:", err)
+			localConn.Close()
+			return
+		}
+		localConn = wrapped
+	}
+	// We try several times to make the connection here to workaround
+	// flakey connections that timeout. Once the connection is established
+	// TCP takes care of keeping it working.
+	err := util.Retry(func() error {
+		password := <-t.passwords
+		user := "cf:" + t.AppGuid + "/0"
+		log.Debug("ssh: connecting:", user, t.TunnelAddr, fmt.Sprintf("'%s'", logging.Secret(password)))
+		sshConn, err := Dial(t.TunnelAddr, t.TunnelHostKey, t.AppGuid, password)
+		if err != nil {
+			log.Debug("ssh: connection attempt failed:", err)
+			return fmt.Errorf("error dialing ssh: %s\n", err)
+		}
+		log.Debug("ssh: connected!:", user, t.TunnelAddr)
+		go t.startKeepalive(user, sshConn)
+		log.Debug("remote: connecting", fwd)
+		remoteConn, err := sshConn.Dial("tcp", fwd.RemoteAddr)
+		if err != nil {
+			log.Debug("remote: connection attempt failed:", err, fwd)
+			return err
+		}
+		go copyConn(fwd, localConn, remoteConn)
+		go copyConn(fwd, remoteConn, localConn)
+		return nil
+	})
+	if err != nil {
+		log.Warn("remote: connection fail", err, fwd)
+		localConn.Close()
+	}
+}
+
+// Dial connects to the ssh-proxy at addr as the first instance of the app,
+// checking that its host key matches the fingerprint
+func Dial(addr string, hostKeyFingerprint string, appGuid string, password string) (*ssh.Client, error) {
+	cfg := &ssh.ClientConfig{
+		User:            "cf:" + appGuid + "/0",
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: hostKeyCallback(hostKeyFingerprint),
+		Timeout:         30 * time.Second,
+	}
+	return ssh.Dial("tcp", addr, cfg)
+}
+
+// errHostKeyChecked stops a handshake once the host key has been checked
+var errHostKeyChecked = errors.New("host key checked")
+
+// CheckHostKey connects to the ssh-proxy at addr just far enough to check
+// that its host key matches the fingerprint, without authenticating
+func CheckHostKey(addr string, hostKeyFingerprint string) error {
+	var keyErr error
+	check := hostKeyCallback(hostKeyFingerprint)
+	cfg := &ssh.ClientConfig{
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			keyErr = check(hostname, remote, key)
+			return errHostKeyChecked
+		},
+		Timeout: 30 * time.Second,
+	}
+	conn, err := ssh.Dial("tcp", addr, cfg)
+	if err == nil {
+		conn.Close()
+		return errors.New("handshake finished without checking the host key")
+	}
+	if !errors.Is(err, errHostKeyChecked) {
+		return err
+	}
+	return keyErr
+}
+
+func hostKeyCallback(hostKeyFingerprint string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		valid, possible := checkSSHFingerprint(key, hostKeyFingerprint)
+
+		if !valid {
+			return fmt.Errorf(
+				"remote hostkey fingerprint %q did not match any possible values %q",
+				hostKeyFingerprint, possible,
+			)
+		}
+
+		return nil
+	}
+}
+
 func (t *Tunnel) WaitChan() chan error {
 	ch := make(chan error)
 	go func() {
@@ -186,7 +291,7 @@ func (t *Tunnel) startKeepalive(user string, sshConnection *ssh.Client) {
 	for {
 		<-ticker.C
 		if _, _, err := sshConnection.SendRequest(keepaliveName, true, make([]byte, 0)); err != nil {
-			logging.Debug("failed to send keepalive message", user, t.TunnelAddr, err)
+			logger.Debug("failed to send keepalive message", user, t.TunnelAddr, err)
 			return
 		}
 	}
@@ -197,10 +302,10 @@ func copyConn(fwd ForwardAddrs, dst, src net.Conn) {
 	_, err := io.Copy(dst, src)
 	if err != nil {
 		if err == io.EOF {
-			logging.Debug("copy failed: EOF:", fwd)
+			logger.Debug("copy failed: EOF:", fwd)
 			return
 		} else {
-			logging.Error("io.Copy error", err)
+			logger.Error("io.Copy error", err)
 		}
 	}
 }
@@ -232,7 +337,7 @@ func checkSSHFingerprint(expected ssh.PublicKey, actual string) (
 
 	match := sha256Match || md5Match
 
-	logging.Debug(fmt.Sprintf(
+	logger.Debug(fmt.Sprintf(
 		"Fingerprint: [Match: %t ; Expected: %q ; Actual: %q ]",
 		match, possibleVals, actualWithoutColons,
 	))
@@ -1,9 +1,14 @@
 package ssh
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"net"
+	"strings"
 
 	"golang.org/x/crypto/ssh"
 
@@ -96,3 +101,106 @@ JdwgL8l1TITjsP9sIve4oy1IHbAa8s6j9U1J5gRWRnhys3w26XZw1u6YMmM9GPFQ
 		Expect(compatible).To(Equal(false))
 	})
 })
+
+var _ = Describe("Connecting to the ssh-proxy", func() {
+	var (
+		addr        string
+		fingerprint string
+		listener    net.Listener
+	)
+
+	BeforeEach(func() {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := ssh.NewSignerFromKey(private)
+		Expect(err).NotTo(HaveOccurred())
+		fingerprint = strings.TrimPrefix(ssh.FingerprintSHA256(signer.PublicKey()), "SHA256:")
+
+		config := &ssh.ServerConfig{
+			PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+				if conn.User() == "cf:app-guid/0" && string(password) == "one-time-code" {
+					return nil, nil
+				}
+				return nil, errors.New("access denied")
+			},
+		}
+		config.AddHostKey(signer)
+
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		addr = listener.Addr().String()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+					if err != nil {
+						return
+					}
+					defer sshConn.Close()
+					go ssh.DiscardRequests(reqs)
+					for ch := range chans {
+						ch.Reject(ssh.Prohibited, "no channels")
+					}
+				}()
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	Describe("CheckHostKey", func() {
+		It("succeeds if the host key matches", func() {
+			Expect(CheckHostKey(addr, fingerprint)).To(Succeed())
+		})
+
+		It("fails if the host key doesn't match", func() {
+			err := CheckHostKey(addr, "AM/+fDBqPFHaaWhdRb2Y7uvQFBXGs9BCUbe6zXzegtc")
+			Expect(err).To(MatchError(ContainSubstring("did not match any possible values")))
+		})
+	})
+
+	Describe("Dial", func() {
+		It("authenticates as the app with the one-time code", func() {
+			client, err := Dial(addr, fingerprint, "app-guid", "one-time-code")
+			Expect(err).NotTo(HaveOccurred())
+			client.Close()
+		})
+
+		It("fails with the wrong one-time code", func() {
+			_, err := Dial(addr, fingerprint, "app-guid", "wrong")
+			Expect(err).To(MatchError(ContainSubstring("unable to authenticate")))
+		})
+	})
+})
+
+var _ = Describe("ForwardAddrs", func() {
+	It("listens on localhost unless a bind address is given", func() {
+		fwd := ForwardAddrs{LocalPort: 7080, TLSTunnelPort: 7081}
+		Expect(fwd.LocalAddress()).To(Equal("localhost:7080"))
+		Expect(fwd.TLSTunnelAddress()).To(Equal("localhost:7081"))
+		Expect(fwd.LocalDialAddress()).To(Equal("localhost:7080"))
+	})
+
+	It("brackets IPv6 bind addresses", func() {
+		fwd := ForwardAddrs{LocalPort: 7080, BindAddress: "::1"}
+		Expect(fwd.LocalAddress()).To(Equal("[::1]:7080"))
+		Expect(fwd.LocalDialAddress()).To(Equal("[::1]:7080"))
+	})
+
+	It("connects to the loopback address when listening on every address", func() {
+		fwd := ForwardAddrs{LocalPort: 7080, TLSTunnelPort: 7081, BindAddress: "0.0.0.0"}
+		Expect(fwd.LocalAddress()).To(Equal("0.0.0.0:7080"))
+		Expect(fwd.LocalDialAddress()).To(Equal("127.0.0.1:7080"))
+		Expect(fwd.TLSTunnelDialAddress()).To(Equal("127.0.0.1:7081"))
+
+		fwd.BindAddress = "::"
+		Expect(fwd.LocalDialAddress()).To(Equal("[::1]:7080"))
+	})
+})
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/alphagov/paas-cf-conduit/daemon"
+
+	"github.com/spf13/cobra"
+)
+
+var Status = &cobra.Command{
+	Use: "status ID",
+	Example: `  Show how to connect to the tunnels of a background session:
+  cf conduit status abcd1234
+  `,
+	Short: "shows how to connect to a background session's tunnels",
+	Long:  "shows the connection details of the service instances a conduit session started with --detach is connected to. The ID can be shortened as long as it's unambiguous.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := daemon.Dir()
+		if err != nil {
+			return err
+		}
+		socketPath, err := daemon.Find(dir, args[0])
+		if err != nil {
+			return err
+		}
+		s, err := daemon.Call(socketPath, daemon.CommandStatus)
+		if err != nil {
+			return err
+		}
+		printDaemonStatus(*s)
+		return nil
+	},
+	SilenceUsage: true,
+}
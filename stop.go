@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/daemon"
+
+	"github.com/cloudfoundry/multierror"
+	"github.com/spf13/cobra"
+)
+
+var (
+	StopAll     bool
+	StopTimeout time.Duration
+)
+
+var Stop = &cobra.Command{
+	Use: "stop [flags] ID",
+	Example: `  Stop a background session:
+  cf conduit stop abcd1234
+
+  Stop every background session:
+  cf conduit stop --all
+  `,
+	Short: "stops background sessions started with --detach",
+	Long:  "stops conduit sessions started with --detach, which close their tunnels and delete their apps as they would on Ctrl+C. The ID can be shortened as long as it's unambiguous.",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if StopAll == (len(args) == 1) {
+			return errors.New("either an ID or --all must be given")
+		}
+
+		dir, err := daemon.Dir()
+		if err != nil {
+			return err
+		}
+		socketPaths := []string{}
+		if StopAll {
+			statuses, err := daemon.List(dir)
+			if err != nil {
+				return err
+			}
+			for _, s := range statuses {
+				socketPaths = append(socketPaths, daemon.SocketPath(dir, s.ID))
+			}
+		} else {
+			socketPath, err := daemon.Find(dir, args[0])
+			if err != nil {
+				return err
+			}
+			socketPaths = append(socketPaths, socketPath)
+		}
+
+		status, done, err := newStatus()
+		if err != nil {
+			return err
+		}
+		defer done()
+
+		// they're all asked to stop first, so they tear down at the same time
+		stopping := map[string]*daemon.Status{}
+		errs := &multierror.MultiError{}
+		for _, socketPath := range socketPaths {
+			s, err := daemon.Call(socketPath, daemon.CommandStop)
+			if err != nil {
+				errs.Add(err)
+				continue
+			}
+			stopping[socketPath] = s
+		}
+		for socketPath, s := range stopping {
+			status.Text("Stopping background session", s.ID)
+			if err := daemon.WaitForExit(socketPath, StopTimeout); err != nil {
+				errs.Add(fmt.Errorf("background session %s didn't stop: %s", s.ID, err))
+				continue
+			}
+			status.Done()
+			fmt.Fprintf(os.Stderr, "Stopped background session %s\n", s.ID)
+		}
+		status.Done()
+
+		if len(socketPaths) == 0 {
+			fmt.Fprintln(os.Stderr, "No background sessions are running")
+		}
+		if len(errs.Errors) > 0 {
+			return errs
+		}
+		return nil
+	},
+	SilenceUsage: true,
+}
@@ -0,0 +1,106 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// localCAValidity is how long the local certificates are valid for, which
+// is long enough for a tunnel left open in the background
+const localCAValidity = 7 * 24 * time.Hour
+
+// LocalCA is a certificate authority which only exists for as long as
+// conduit is running, and a certificate it has signed for the local tunnels
+type LocalCA struct {
+	// CAFile is the CA certificate, for clients to verify the tunnels with
+	CAFile string
+	// ServerConfig serves TLS with the certificate for the tunnels
+	ServerConfig *tls.Config
+}
+
+// NewLocalCA creates a CA and a certificate for the hosts the tunnels can
+// be connected to on, writing the CA certificate to dir. The private keys
+// are only kept in memory.
+func NewLocalCA(dir string, hosts []string) (*LocalCA, error) {
+	now := time.Now()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "conduit local CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(localCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create local CA: %s", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(localCAValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create local certificate: %s", err)
+	}
+
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	if err := ioutil.WriteFile(caFile, caPEM, 0644); err != nil {
+		return nil, err
+	}
+
+	return &LocalCA{
+		CAFile: caFile,
+		ServerConfig: &tls.Config{
+			Certificates: []tls.Certificate{{
+				Certificate: [][]byte{der, caDER},
+				PrivateKey:  key,
+			}},
+			MinVersion: tls.VersionTLS12,
+		},
+	}, nil
+}
+
+func serialNumber() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
@@ -0,0 +1,49 @@
+package tls_test
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/tls"
+)
+
+var _ = Describe("NewLocalCA", func() {
+	var (
+		ca    *tls.LocalCA
+		roots *x509.CertPool
+	)
+
+	BeforeEach(func() {
+		var err error
+		ca, err = tls.NewLocalCA(GinkgoT().TempDir(), []string{"localhost", "127.0.0.1", "::1", "host.docker.internal"})
+		Expect(err).NotTo(HaveOccurred())
+
+		pem, err := ioutil.ReadFile(ca.CAFile)
+		Expect(err).NotTo(HaveOccurred())
+		roots = x509.NewCertPool()
+		Expect(roots.AppendCertsFromPEM(pem)).To(BeTrue())
+	})
+
+	DescribeTable("issues a certificate the CA file verifies",
+		func(host string) {
+			cert, err := x509.ParseCertificate(ca.ServerConfig.Certificates[0].Certificate[0])
+			Expect(err).NotTo(HaveOccurred())
+			_, err = cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+			Expect(err).NotTo(HaveOccurred())
+		},
+		Entry("for localhost", "localhost"),
+		Entry("for the IPv4 loopback address", "127.0.0.1"),
+		Entry("for the IPv6 loopback address", "::1"),
+		Entry("for another host", "host.docker.internal"),
+	)
+
+	It("doesn't issue a certificate for other hosts", func() {
+		cert, err := x509.ParseCertificate(ca.ServerConfig.Certificates[0].Certificate[0])
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cert.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots})
+		Expect(err).To(HaveOccurred())
+	})
+})
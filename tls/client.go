@@ -0,0 +1,151 @@
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// ClientCredentials are the CAs a service's certificate is verified with,
+// as well as the system's, and the certificate conduit authenticates to it
+// with
+type ClientCredentials struct {
+	CAs         []*x509.Certificate
+	Certificate *tls.Certificate
+}
+
+// LoadClientCredentials reads a CA bundle and a client certificate and key
+// from PEM files, any of which can be empty
+func LoadClientCredentials(caFile, certFile, keyFile string) (*ClientCredentials, error) {
+	var caPEM, certPEM, keyPEM []byte
+	if caFile != "" {
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %s", err)
+		}
+		caPEM = b
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("a client certificate and key must be given together")
+	}
+	if certFile != "" {
+		b, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client certificate: %s", err)
+		}
+		certPEM = b
+		b, err = ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client key: %s", err)
+		}
+		keyPEM = b
+	}
+	return ParseClientCredentials(caPEM, certPEM, keyPEM)
+}
+
+// ParseClientCredentials is LoadClientCredentials for PEM which has already
+// been read, e.g. from a service binding
+func ParseClientCredentials(caPEM, certPEM, keyPEM []byte) (*ClientCredentials, error) {
+	creds := &ClientCredentials{}
+	if len(caPEM) > 0 {
+		cas, err := parseCertificates(caPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CA certificates: %s", err)
+		}
+		creds.CAs = cas
+	}
+	if (len(certPEM) == 0) != (len(keyPEM) == 0) {
+		return nil, errors.New("a client certificate and key must be given together")
+	}
+	if len(certPEM) > 0 {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %s", err)
+		}
+		creds.Certificate = &cert
+	}
+	return creds, nil
+}
+
+func parseCertificates(pemData []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for len(pemData) > 0 {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
+
+// Merge returns credentials with the CAs of both, and the client
+// certificate of c if it has one and other's if not
+func (c *ClientCredentials) Merge(other *ClientCredentials) *ClientCredentials {
+	if c == nil {
+		return other
+	}
+	if other == nil {
+		return c
+	}
+	merged := &ClientCredentials{
+		CAs:         append(append([]*x509.Certificate{}, c.CAs...), other.CAs...),
+		Certificate: c.Certificate,
+	}
+	if merged.Certificate == nil {
+		merged.Certificate = other.Certificate
+	}
+	return merged
+}
+
+// Apply adds the credentials to a client config
+func (c *ClientCredentials) Apply(config *tls.Config) {
+	if c == nil {
+		return
+	}
+	if len(c.CAs) > 0 {
+		roots := config.RootCAs
+		if roots == nil {
+			system, err := x509.SystemCertPool()
+			if err != nil {
+				system = x509.NewCertPool()
+			}
+			roots = system
+		} else {
+			roots = roots.Clone()
+		}
+		for _, ca := range c.CAs {
+			roots.AddCert(ca)
+		}
+		config.RootCAs = roots
+	}
+	if c.Certificate != nil {
+		config.Certificates = []tls.Certificate{*c.Certificate}
+	}
+}
+
+// ServerName returns the name a service's certificate is checked against,
+// which is the host of its address
+func ServerName(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = strings.Trim(addr, "[]")
+	}
+	return strings.TrimSuffix(host, ".")
+}
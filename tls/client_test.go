@@ -0,0 +1,232 @@
+package tls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/tls"
+)
+
+// testCert is a certificate and its key, PEM encoded
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certPEM  []byte
+	keyPEM   []byte
+	keyPair  gotls.Certificate
+	template *x509.Certificate
+}
+
+// newTestCert creates a certificate signed by parent, or a CA if parent is
+// nil
+func newTestCert(parent *testCert, name string, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = []net.IP{ip}
+		} else {
+			template.DNSNames = []string{name}
+		}
+		signer, signerKey = parent.template, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certPEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:   pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		template: template,
+	}
+	tc.keyPair, err = gotls.X509KeyPair(tc.certPEM, tc.keyPEM)
+	Expect(err).NotTo(HaveOccurred())
+	return tc
+}
+
+var _ = Describe("Client credentials", func() {
+	var (
+		ca     *testCert
+		client *testCert
+	)
+
+	BeforeEach(func() {
+		ca = newTestCert(nil, "private CA", 0)
+		client = newTestCert(ca, "conduit", x509.ExtKeyUsageClientAuth)
+	})
+
+	Describe("ParseClientCredentials", func() {
+		It("parses the CAs and client certificate", func() {
+			creds, err := tls.ParseClientCredentials(ca.certPEM, client.certPEM, client.keyPEM)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(creds.CAs).To(HaveLen(1))
+			Expect(creds.CAs[0].Subject.CommonName).To(Equal("private CA"))
+			Expect(creds.Certificate).NotTo(BeNil())
+		})
+
+		It("needs a key with the certificate", func() {
+			_, err := tls.ParseClientCredentials(nil, client.certPEM, nil)
+			Expect(err).To(MatchError("a client certificate and key must be given together"))
+		})
+
+		It("fails if there are no CA certificates", func() {
+			_, err := tls.ParseClientCredentials([]byte("not PEM"), nil, nil)
+			Expect(err).To(MatchError("invalid CA certificates: no certificates found"))
+		})
+
+		It("fails if the key doesn't match the certificate", func() {
+			other := newTestCert(ca, "other", x509.ExtKeyUsageClientAuth)
+			_, err := tls.ParseClientCredentials(nil, client.certPEM, other.keyPEM)
+			Expect(err).To(MatchError(HavePrefix("invalid client certificate:")))
+		})
+	})
+
+	Describe("LoadClientCredentials", func() {
+		It("reads the files", func() {
+			dir := GinkgoT().TempDir()
+			for name, data := range map[string][]byte{"ca.pem": ca.certPEM, "cert.pem": client.certPEM, "key.pem": client.keyPEM} {
+				Expect(ioutil.WriteFile(filepath.Join(dir, name), data, 0600)).To(Succeed())
+			}
+			creds, err := tls.LoadClientCredentials(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(creds.CAs).To(HaveLen(1))
+			Expect(creds.Certificate).NotTo(BeNil())
+		})
+
+		It("fails if a file doesn't exist", func() {
+			_, err := tls.LoadClientCredentials("/does/not/exist.pem", "", "")
+			Expect(err).To(MatchError(HavePrefix("failed to read CA file:")))
+		})
+	})
+
+	Describe("Merge", func() {
+		It("keeps the CAs of both and prefers the first client certificate", func() {
+			first, err := tls.ParseClientCredentials(ca.certPEM, client.certPEM, client.keyPEM)
+			Expect(err).NotTo(HaveOccurred())
+			otherCA := newTestCert(nil, "other CA", 0)
+			other := newTestCert(otherCA, "other", x509.ExtKeyUsageClientAuth)
+			second, err := tls.ParseClientCredentials(otherCA.certPEM, other.certPEM, other.keyPEM)
+			Expect(err).NotTo(HaveOccurred())
+
+			merged := first.Merge(second)
+			Expect(merged.CAs).To(HaveLen(2))
+			Expect(merged.Certificate).To(Equal(first.Certificate))
+
+			var none *tls.ClientCredentials
+			Expect(none.Merge(second)).To(Equal(second))
+		})
+	})
+
+	DescribeTable("ServerName",
+		func(addr, expected string) {
+			Expect(tls.ServerName(addr)).To(Equal(expected))
+		},
+		Entry("a host and port", "redis.example.com:6379", "redis.example.com"),
+		Entry("an IPv6 address and port", "[2001:db8::1]:6379", "2001:db8::1"),
+		Entry("a fully qualified name", "redis.example.com.:6379", "redis.example.com"),
+		Entry("just a host", "redis.example.com", "redis.example.com"),
+	)
+
+	Describe("a tunnel to a service which needs a client certificate", func() {
+		var (
+			server     net.Listener
+			remoteAddr string
+		)
+
+		BeforeEach(func() {
+			serverCert := newTestCert(ca, "127.0.0.1", x509.ExtKeyUsageServerAuth)
+			clientCAs := x509.NewCertPool()
+			clientCAs.AddCert(ca.cert)
+			var err error
+			server, err = gotls.Listen("tcp", "127.0.0.1:0", &gotls.Config{
+				Certificates: []gotls.Certificate{serverCert.keyPair},
+				ClientCAs:    clientCAs,
+				ClientAuth:   gotls.RequireAndVerifyClientCert,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(server.Close)
+			remoteAddr = server.Addr().String()
+
+			go func() {
+				for {
+					conn, err := server.Accept()
+					if err != nil {
+						return
+					}
+					go io.Copy(conn, conn)
+				}
+			}()
+		})
+
+		startTunnel := func(creds *tls.ClientCredentials) (net.Conn, chan error) {
+			probe, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			localAddr := probe.Addr().String()
+			probe.Close()
+
+			tunnel := tls.NewTunnel(localAddr, remoteAddr, remoteAddr, false, nil, gotls.VersionTLS12)
+			tunnel.SetClientCredentials(creds)
+			errs, err := tunnel.Start()
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(tunnel.Stop)
+
+			conn, err := net.Dial("tcp", localAddr)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(conn.Close)
+			return conn, errs
+		}
+
+		It("connects with the client credentials", func() {
+			creds, err := tls.ParseClientCredentials(ca.certPEM, client.certPEM, client.keyPEM)
+			Expect(err).NotTo(HaveOccurred())
+			conn, _ := startTunnel(creds)
+
+			_, err = conn.Write([]byte("PING\n"))
+			Expect(err).NotTo(HaveOccurred())
+			b := make([]byte, 5)
+			_, err = io.ReadFull(conn, b)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(b)).To(Equal("PING\n"))
+		})
+
+		It("can't verify the service without the CA", func() {
+			_, errs := startTunnel(nil)
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(err.Error()).To(ContainSubstring("TLS handshake with " + remoteAddr + " failed"))
+			Expect(err.Error()).To(ContainSubstring("the certificate is signed by CN=private CA, give its CA with --tls-ca-file"))
+		})
+	})
+})
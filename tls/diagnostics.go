@@ -0,0 +1,244 @@
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alphagov/paas-cf-conduit/logging"
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+// keyLogEnv is the environment variable Wireshark and browsers read TLS
+// secrets from
+const keyLogEnv = "SSLKEYLOGFILE"
+
+// RDSCABundleURL is where to get the CAs which sign the certificates of
+// Amazon RDS databases, which aren't in the system's CAs
+const RDSCABundleURL = "https://truststore.pki.rds.amazonaws.com/global/global-bundle.pem"
+
+// the alerts services send which have a likely cause
+const (
+	alertHandshakeFailure     tls.AlertError = 40
+	alertBadCertificate       tls.AlertError = 42
+	alertProtocolVersion      tls.AlertError = 70
+	alertInsufficientSecurity tls.AlertError = 71
+	alertCertificateRequired  tls.AlertError = 116
+)
+
+// CertificateInfo describes a certificate a service presented
+type CertificateInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	IPAddresses []string  `json:"ip_addresses,omitempty"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+}
+
+func describeCertificates(certs []*x509.Certificate) []CertificateInfo {
+	info := []CertificateInfo{}
+	for _, cert := range certs {
+		ci := CertificateInfo{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			DNSNames:  cert.DNSNames,
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+		}
+		for _, ip := range cert.IPAddresses {
+			ci.IPAddresses = append(ci.IPAddresses, ip.String())
+		}
+		info = append(info, ci)
+	}
+	return info
+}
+
+// HandshakeStats describes a TLS handshake with a service
+type HandshakeStats struct {
+	Client      string            `json:"client"`
+	ServerName  string            `json:"server_name"`
+	StartedAt   time.Time         `json:"started_at"`
+	Duration    time.Duration     `json:"duration"`
+	Version     string            `json:"version,omitempty"`
+	CipherSuite string            `json:"cipher_suite,omitempty"`
+	Resumed     bool              `json:"resumed"`
+	Chain       []CertificateInfo `json:"chain,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// HandshakeError is a failed TLS handshake with a service, with what was
+// negotiated and the certificates it presented, if it got that far
+type HandshakeError struct {
+	Addr        string
+	ServerName  string
+	Version     string
+	CipherSuite string
+	Chain       []CertificateInfo
+	Err         error
+}
+
+// NewHandshakeError describes a failed handshake with the service at addr
+func NewHandshakeError(addr string, serverName string, state tls.ConnectionState, err error) *HandshakeError {
+	he := &HandshakeError{
+		Addr:       addr,
+		ServerName: serverName,
+		Err:        err,
+	}
+	if state.Version != 0 {
+		he.Version = tls.VersionName(state.Version)
+	}
+	if state.CipherSuite != 0 {
+		he.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	}
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) {
+		he.Chain = describeCertificates(verifyErr.UnverifiedCertificates)
+	} else {
+		he.Chain = describeCertificates(state.PeerCertificates)
+	}
+	return he
+}
+
+func (e *HandshakeError) Error() string {
+	msg := fmt.Sprintf("TLS handshake with %s failed: %s", e.Addr, e.Err)
+	if reason := e.Reason(); reason != "" {
+		msg += " (" + reason + ")"
+	}
+	return msg
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// Reason explains why the handshake failed, and what might fix it
+func (e *HandshakeError) Reason() string {
+	var (
+		hostnameErr  x509.HostnameError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		alert        tls.AlertError
+	)
+	switch {
+	case errors.As(e.Err, &hostnameErr):
+		return fmt.Sprintf("the certificate is for %s, not %s", strings.Join(certificateNames(hostnameErr.Certificate), ", "), e.ServerName)
+	case errors.As(e.Err, &authorityErr):
+		issuer := "an unknown CA"
+		if authorityErr.Cert != nil {
+			issuer = authorityErr.Cert.Issuer.String()
+		}
+		if strings.Contains(issuer, "Amazon RDS") {
+			return fmt.Sprintf("the certificate is signed by %s, give the Amazon RDS CAs from %s with --tls-ca-file", issuer, RDSCABundleURL)
+		}
+		return fmt.Sprintf("the certificate is signed by %s, give its CA with --tls-ca-file", issuer)
+	case errors.As(e.Err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return fmt.Sprintf("the certificate was valid from %s to %s", invalidErr.Cert.NotBefore.Format(time.RFC3339), invalidErr.Cert.NotAfter.Format(time.RFC3339))
+	case errors.As(e.Err, &alert) && (alert == alertProtocolVersion || alert == alertInsufficientSecurity):
+		return "the service doesn't support the TLS version, see --minimum-tls-version"
+	case errors.As(e.Err, &alert) && alert == alertHandshakeFailure:
+		return "the service didn't accept any of the cipher suites or TLS versions, see --cipher-suites and --minimum-tls-version"
+	case errors.As(e.Err, &alert) && (alert == alertBadCertificate || alert == alertCertificateRequired):
+		return "the service needs a client certificate, give one with --tls-client-cert and --tls-client-key"
+	}
+	return ""
+}
+
+// Fields describes the handshake for progress events
+func (s HandshakeStats) Fields() util.Fields {
+	fields := util.Fields{
+		"client":      s.Client,
+		"server_name": s.ServerName,
+		"duration_ms": s.Duration.Milliseconds(),
+		"resumed":     s.Resumed,
+		"ok":          s.Error == "",
+	}
+	if s.Version != "" {
+		fields["version"] = s.Version
+	}
+	if s.CipherSuite != "" {
+		fields["cipher_suite"] = s.CipherSuite
+	}
+	if len(s.Chain) > 0 {
+		fields["chain"] = s.Chain
+	}
+	if s.Error != "" {
+		fields["error"] = s.Error
+	}
+	return fields
+}
+
+func certificateNames(cert *x509.Certificate) []string {
+	if cert == nil {
+		return []string{"no names"}
+	}
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	if len(names) == 0 {
+		names = append(names, "no names")
+	}
+	return names
+}
+
+// handshake starts TLS on a connection to a service, describing what
+// happened
+func handshake(conn net.Conn, addr string, config *tls.Config, client string) (*tls.Conn, HandshakeStats, error) {
+	stats := HandshakeStats{
+		Client:     client,
+		ServerName: config.ServerName,
+		StartedAt:  time.Now(),
+	}
+	tlsConn := tls.Client(conn, config)
+	err := tlsConn.Handshake()
+	stats.Duration = time.Since(stats.StartedAt)
+	state := tlsConn.ConnectionState()
+	if err != nil {
+		he := NewHandshakeError(addr, config.ServerName, state, err)
+		stats.Version = he.Version
+		stats.CipherSuite = he.CipherSuite
+		stats.Chain = he.Chain
+		stats.Error = he.Error()
+		return nil, stats, he
+	}
+	stats.Version = tls.VersionName(state.Version)
+	stats.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	stats.Resumed = state.DidResume
+	stats.Chain = describeCertificates(state.PeerCertificates)
+	return tlsConn, stats, nil
+}
+
+var (
+	keyLogOnce   sync.Once
+	keyLogWriter io.Writer
+)
+
+// KeyLogWriter returns where the TLS secrets of connections to services are
+// written for Wireshark, which is SSLKEYLOGFILE in verbose mode
+func KeyLogWriter() io.Writer {
+	path := os.Getenv(keyLogEnv)
+	if path == "" || !logging.Enabled(logging.LevelDebug) {
+		return nil
+	}
+	keyLogOnce.Do(func() {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			logger.Warn("failed to open", keyLogEnv+":", err)
+			return
+		}
+		logger.Warn("writing TLS secrets to", path, "so anyone who can read it can decrypt the tunnels' traffic")
+		keyLogWriter = f
+	})
+	return keyLogWriter
+}
@@ -0,0 +1,155 @@
+package tls_test
+
+import (
+	gotls "crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/logging"
+	"github.com/alphagov/paas-cf-conduit/tls"
+)
+
+var _ = Describe("Handshake diagnostics", func() {
+	var (
+		ca         *testCert
+		creds      *tls.ClientCredentials
+		serverName string
+		remoteAddr string
+		tunnel     *tls.Tunnel
+		localAddr  string
+		errs       chan error
+		handshakes chan tls.HandshakeStats
+	)
+
+	BeforeEach(func() {
+		ca = newTestCert(nil, "private CA", 0)
+		serverName = "127.0.0.1"
+		var err error
+		creds, err = tls.ParseClientCredentials(ca.certPEM, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	JustBeforeEach(func() {
+		serverCert := newTestCert(ca, serverName, x509.ExtKeyUsageServerAuth)
+		server, err := gotls.Listen("tcp", "127.0.0.1:0", &gotls.Config{
+			Certificates: []gotls.Certificate{serverCert.keyPair},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(server.Close)
+		remoteAddr = server.Addr().String()
+		go func() {
+			for {
+				conn, err := server.Accept()
+				if err != nil {
+					return
+				}
+				go io.Copy(conn, conn)
+			}
+		}()
+
+		probe, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		localAddr = probe.Addr().String()
+		probe.Close()
+
+		tunnel = tls.NewTunnel(localAddr, remoteAddr, remoteAddr, false, nil, gotls.VersionTLS12)
+		tunnel.SetClientCredentials(creds)
+		handshakes = make(chan tls.HandshakeStats, 1)
+		tunnel.SetHandshakeFunc(func(stats tls.HandshakeStats) {
+			handshakes <- stats
+		})
+		errs, err = tunnel.Start()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(tunnel.Stop)
+	})
+
+	connect := func() {
+		conn, err := net.Dial("tcp", localAddr)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(conn.Close)
+		_, err = conn.Write([]byte("hi"))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("records the stats of each handshake", func() {
+		connect()
+		var stats tls.HandshakeStats
+		Eventually(handshakes).Should(Receive(&stats))
+		Expect(stats.ServerName).To(Equal("127.0.0.1"))
+		Expect(stats.Version).To(Equal("TLS 1.3"))
+		Expect(stats.CipherSuite).NotTo(BeEmpty())
+		Expect(stats.Error).To(BeEmpty())
+		Expect(stats.Chain).To(HaveLen(1))
+		Expect(stats.Chain[0].IPAddresses).To(Equal([]string{"127.0.0.1"}))
+		Expect(stats.Fields()).To(HaveKeyWithValue("ok", true))
+	})
+
+	When("the certificate is for another host", func() {
+		BeforeEach(func() {
+			serverName = "redis.example.com"
+		})
+
+		It("says which hosts it's for", func() {
+			connect()
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			var handshakeErr *tls.HandshakeError
+			Expect(errors.As(err, &handshakeErr)).To(BeTrue())
+			Expect(handshakeErr.Reason()).To(Equal("the certificate is for redis.example.com, not 127.0.0.1"))
+			Expect(handshakeErr.Chain).To(HaveLen(1))
+			Expect(handshakeErr.Chain[0].DNSNames).To(Equal([]string{"redis.example.com"}))
+
+			var stats tls.HandshakeStats
+			Eventually(handshakes).Should(Receive(&stats))
+			Expect(stats.Error).To(Equal(err.Error()))
+			Expect(stats.Fields()).To(HaveKeyWithValue("ok", false))
+		})
+	})
+
+	When("the certificate is signed by Amazon RDS", func() {
+		BeforeEach(func() {
+			ca = newTestCert(nil, "Amazon RDS eu-west-2 Root CA RSA2048 G1", 0)
+			creds = nil
+		})
+
+		It("says where to get the Amazon RDS CAs", func() {
+			connect()
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			var handshakeErr *tls.HandshakeError
+			Expect(errors.As(err, &handshakeErr)).To(BeTrue())
+			Expect(handshakeErr.Reason()).To(Equal(
+				"the certificate is signed by CN=Amazon RDS eu-west-2 Root CA RSA2048 G1, give the Amazon RDS CAs from " + tls.RDSCABundleURL + " with --tls-ca-file",
+			))
+		})
+	})
+
+	Describe("SSLKEYLOGFILE", func() {
+		var path string
+
+		BeforeEach(func() {
+			path = filepath.Join(GinkgoT().TempDir(), "keys.log")
+			os.Setenv("SSLKEYLOGFILE", path)
+			DeferCleanup(os.Unsetenv, "SSLKEYLOGFILE")
+			logging.Verbose = true
+			DeferCleanup(func() { logging.Verbose = false })
+		})
+
+		It("gets the TLS secrets in verbose mode", func() {
+			connect()
+			Eventually(handshakes).Should(Receive())
+			Eventually(func() (string, error) {
+				b, err := ioutil.ReadFile(path)
+				return string(b), err
+			}).Should(ContainSubstring("CLIENT_TRAFFIC_SECRET_0"))
+		})
+	})
+})
@@ -0,0 +1,90 @@
+package tls
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	postgresSSLRequestCode    = 80877103
+	postgresGSSENCRequestCode = 80877104
+)
+
+// postgresRequest is a message a postgres client can send before its startup
+// message, which is only its length and a code
+func postgresRequest(code uint32) []byte {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], code)
+	return request
+}
+
+// PostgresSSLRequest asks a postgres server to start TLS, returning whether
+// it agreed
+func PostgresSSLRequest(conn net.Conn) (bool, error) {
+	if _, err := conn.Write(postgresRequest(postgresSSLRequestCode)); err != nil {
+		return false, err
+	}
+	response := make([]byte, 1)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return false, err
+	}
+	switch response[0] {
+	case 'S':
+		return true, nil
+	case 'N':
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected response to SSLRequest: %q", response[0])
+	}
+}
+
+// postgresNegotiate reads a postgres client's first messages, answering an
+// SSLRequest with whether TLS is served and refusing GSS encryption. If
+// the client doesn't start TLS the message it sent instead is returned.
+func postgresNegotiate(conn net.Conn, serveTLS bool) (bool, []byte, error) {
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return false, nil, err
+		}
+		length := binary.BigEndian.Uint32(header[0:4])
+		code := binary.BigEndian.Uint32(header[4:8])
+		if length != 8 {
+			return false, header, nil
+		}
+		switch {
+		case code == postgresSSLRequestCode && serveTLS:
+			_, err := conn.Write([]byte{'S'})
+			return true, nil, err
+		case code == postgresSSLRequestCode, code == postgresGSSENCRequestCode:
+			if _, err := conn.Write([]byte{'N'}); err != nil {
+				return false, nil, err
+			}
+		default:
+			return false, header, nil
+		}
+	}
+}
+
+// dialPostgres connects to a postgres server and asks it to start TLS the
+// way its clients do, returning the connection to start it on
+func dialPostgres(addr string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := PostgresSSLRequest(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, errors.New("the server refused to start TLS")
+	}
+	return conn, nil
+}
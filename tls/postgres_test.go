@@ -0,0 +1,166 @@
+package tls_test
+
+import (
+	"bytes"
+	gotls "crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/tls"
+)
+
+var _ = Describe("PostgresSSLRequest", func() {
+	respondWith := func(response string) (bool, error) {
+		client, server := net.Pipe()
+		defer client.Close()
+		go func() {
+			defer server.Close()
+			request := make([]byte, 8)
+			if _, err := io.ReadFull(server, request); err != nil {
+				return
+			}
+			if bytes.Equal(request, []byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}) {
+				server.Write([]byte(response))
+			}
+		}()
+		return tls.PostgresSSLRequest(client)
+	}
+
+	It("returns true if the server agrees to start TLS", func() {
+		Expect(respondWith("S")).To(BeTrue())
+	})
+
+	It("returns false if the server won't start TLS", func() {
+		Expect(respondWith("N")).To(BeFalse())
+	})
+
+	It("errors on any other response", func() {
+		_, err := respondWith("E")
+		Expect(err).To(MatchError(`unexpected response to SSLRequest: 'E'`))
+	})
+})
+
+var _ = Describe("a postgres tunnel", func() {
+	var (
+		ca         *testCert
+		remoteAddr string
+		refuseTLS  bool
+		localCA    *tls.LocalCA
+	)
+
+	// startup is a postgres startup message with a user parameter
+	startup := func() []byte {
+		body := append([]byte{0, 3, 0, 0}, []byte("user\x00me\x00\x00")...)
+		msg := make([]byte, 4)
+		binary.BigEndian.PutUint32(msg, uint32(len(body)+4))
+		return append(msg, body...)
+	}
+
+	BeforeEach(func() {
+		refuseTLS = false
+		ca = newTestCert(nil, "RDS CA", 0)
+		serverCert := newTestCert(ca, "127.0.0.1", x509.ExtKeyUsageServerAuth)
+		var err error
+		localCA, err = tls.NewLocalCA(GinkgoT().TempDir(), []string{"localhost"})
+		Expect(err).NotTo(HaveOccurred())
+
+		// a postgres server which only accepts TLS, and answers the startup
+		// message with the user it was given
+		server, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(server.Close)
+		remoteAddr = server.Addr().String()
+		go func() {
+			for {
+				conn, err := server.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					request := make([]byte, 8)
+					if _, err := io.ReadFull(conn, request); err != nil {
+						return
+					}
+					if refuseTLS {
+						conn.Write([]byte("N"))
+						return
+					}
+					conn.Write([]byte("S"))
+					tlsConn := gotls.Server(conn, &gotls.Config{Certificates: []gotls.Certificate{serverCert.keyPair}})
+					msg := make([]byte, len(startup()))
+					if _, err := io.ReadFull(tlsConn, msg); err != nil {
+						return
+					}
+					tlsConn.Write(msg[8:10])
+				}()
+			}
+		}()
+	})
+
+	startTunnel := func(serverConfig *gotls.Config) (net.Conn, chan error) {
+		probe, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		localAddr := probe.Addr().String()
+		probe.Close()
+
+		creds, err := tls.ParseClientCredentials(ca.certPEM, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		tunnel := tls.NewTunnel(localAddr, remoteAddr, remoteAddr, false, nil, gotls.VersionTLS12)
+		tunnel.SetProtocol(tls.ProtocolPostgres)
+		tunnel.SetClientCredentials(creds)
+		tunnel.SetServerConfig(serverConfig)
+		errs, err := tunnel.Start()
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(tunnel.Stop)
+
+		conn, err := net.Dial("tcp", localAddr)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(conn.Close)
+		return conn, errs
+	}
+
+	expectStartup := func(conn net.Conn) {
+		_, err := conn.Write(startup())
+		Expect(err).NotTo(HaveOccurred())
+		b := make([]byte, 2)
+		_, err = io.ReadFull(conn, b)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal("us"))
+	}
+
+	It("lets plaintext clients connect to a server which needs TLS", func() {
+		conn, _ := startTunnel(nil)
+		expectStartup(conn)
+	})
+
+	It("tells clients which ask for TLS that it isn't served", func() {
+		conn, _ := startTunnel(nil)
+		Expect(tls.PostgresSSLRequest(conn)).To(BeFalse())
+		expectStartup(conn)
+	})
+
+	It("serves TLS locally", func() {
+		conn, _ := startTunnel(localCA.ServerConfig)
+		Expect(tls.PostgresSSLRequest(conn)).To(BeTrue())
+		pem, err := ioutil.ReadFile(localCA.CAFile)
+		Expect(err).NotTo(HaveOccurred())
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(pem)
+		expectStartup(gotls.Client(conn, &gotls.Config{ServerName: "localhost", RootCAs: roots}))
+	})
+
+	It("fails if the server won't start TLS", func() {
+		refuseTLS = true
+		conn, errs := startTunnel(nil)
+		_, err := conn.Write(startup())
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(errs).Should(Receive(MatchError(ContainSubstring("failed to connect to"))))
+	})
+})
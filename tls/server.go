@@ -0,0 +1,76 @@
+package tls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// ProtocolTLS is a protocol where the client starts TLS as soon as it
+	// connects, such as redis
+	ProtocolTLS = "tls"
+	// ProtocolPostgres is the postgres protocol, where the client asks for
+	// TLS with an SSLRequest
+	ProtocolPostgres = "postgres"
+)
+
+// handshakeTimeout is how long a client has to finish a TLS handshake with
+// a local listener
+const handshakeTimeout = 30 * time.Second
+
+// ServerConn serves TLS to a client connected to a local listener, as the
+// protocol expects. io.EOF is returned if the client disconnects before
+// sending anything. Postgres clients which don't ask for TLS are let through
+// in plaintext, as they would be by a postgres server, and without a config
+// postgres clients are refused TLS.
+func ServerConn(conn net.Conn, config *tls.Config, protocol string) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	switch protocol {
+	case ProtocolTLS:
+		if config == nil {
+			return nil, errors.New("no certificate to serve TLS with")
+		}
+	case ProtocolPostgres:
+		upgrade, first, err := postgresNegotiate(conn, config != nil)
+		if err == io.EOF {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read postgres startup: %s", err)
+		}
+		if !upgrade {
+			return &prefixConn{Conn: conn, prefix: bytes.NewReader(first)}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported protocol %s", protocol)
+	}
+
+	tlsConn := tls.Server(conn, config)
+	if err := tlsConn.Handshake(); err == io.EOF {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("local TLS handshake failed: %s", err)
+	}
+	return tlsConn, nil
+}
+
+// prefixConn is a connection with bytes which have already been read from it
+// put back
+type prefixConn struct {
+	net.Conn
+	prefix *bytes.Reader
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if c.prefix.Len() > 0 {
+		return c.prefix.Read(b)
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,147 @@
+package tls_test
+
+import (
+	gotls "crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/tls"
+)
+
+// postgresMessage is a message a postgres client sends before startup,
+// which is only a length and a code
+func postgresMessage(code uint32) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b[0:4], 8)
+	binary.BigEndian.PutUint32(b[4:8], code)
+	return b
+}
+
+var _ = Describe("ServerConn", func() {
+	var (
+		ca           *tls.LocalCA
+		clientConfig *gotls.Config
+		client       net.Conn
+		server       net.Conn
+	)
+
+	BeforeEach(func() {
+		var err error
+		ca, err = tls.NewLocalCA(GinkgoT().TempDir(), []string{"localhost"})
+		Expect(err).NotTo(HaveOccurred())
+		pem, err := ioutil.ReadFile(ca.CAFile)
+		Expect(err).NotTo(HaveOccurred())
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(pem)
+		clientConfig = &gotls.Config{ServerName: "localhost", RootCAs: roots}
+
+		client, server = net.Pipe()
+		DeferCleanup(client.Close)
+		DeferCleanup(server.Close)
+	})
+
+	// serve runs ServerConn in the background, echoing a line once it has
+	// finished
+	serve := func(protocol string) chan error {
+		errs := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			conn, err := tls.ServerConn(server, ca.ServerConfig, protocol)
+			if err != nil {
+				errs <- err
+				return
+			}
+			b := make([]byte, 5)
+			if _, err := io.ReadFull(conn, b); err != nil {
+				errs <- err
+				return
+			}
+			_, err = conn.Write(b)
+			errs <- err
+		}()
+		return errs
+	}
+
+	expectEcho := func(conn net.Conn) {
+		_, err := conn.Write([]byte("hello"))
+		Expect(err).NotTo(HaveOccurred())
+		b := make([]byte, 5)
+		_, err = io.ReadFull(conn, b)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal("hello"))
+	}
+
+	It("serves TLS straight away", func() {
+		errs := serve(tls.ProtocolTLS)
+		conn := gotls.Client(client, clientConfig)
+		expectEcho(conn)
+		Expect(<-errs).To(Succeed())
+	})
+
+	Describe("postgres", func() {
+		It("serves TLS after an SSLRequest", func() {
+			errs := serve(tls.ProtocolPostgres)
+			_, err := client.Write(postgresMessage(80877103))
+			Expect(err).NotTo(HaveOccurred())
+			answer := make([]byte, 1)
+			_, err = io.ReadFull(client, answer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(answer)).To(Equal("S"))
+
+			conn := gotls.Client(client, clientConfig)
+			expectEcho(conn)
+			Expect(<-errs).To(Succeed())
+		})
+
+		It("refuses GSS encryption and then serves TLS", func() {
+			errs := serve(tls.ProtocolPostgres)
+			answer := make([]byte, 1)
+			_, err := client.Write(postgresMessage(80877104))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.ReadFull(client, answer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(answer)).To(Equal("N"))
+			_, err = client.Write(postgresMessage(80877103))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.ReadFull(client, answer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(answer)).To(Equal("S"))
+
+			conn := gotls.Client(client, clientConfig)
+			expectEcho(conn)
+			Expect(<-errs).To(Succeed())
+		})
+
+		It("lets clients which don't ask for TLS through", func() {
+			errs := make(chan error, 1)
+			go func() {
+				defer GinkgoRecover()
+				conn, err := tls.ServerConn(server, ca.ServerConfig, tls.ProtocolPostgres)
+				if err != nil {
+					errs <- err
+					return
+				}
+				b := make([]byte, 12)
+				_, err = io.ReadFull(conn, b)
+				Expect(b[8:]).To(Equal([]byte("user")))
+				errs <- err
+			}()
+			startup := postgresMessage(196608)
+			binary.BigEndian.PutUint32(startup[0:4], 12)
+			_, err := client.Write(append(startup, []byte("user")...))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(<-errs).To(Succeed())
+		})
+	})
+
+	It("fails for unknown protocols", func() {
+		_, err := tls.ServerConn(server, ca.ServerConfig, "mysql")
+		Expect(err).To(MatchError("unsupported protocol mysql"))
+	})
+})
@@ -2,26 +2,33 @@ package tls
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"runtime"
-	"strings"
 
 	"github.com/alphagov/paas-cf-conduit/logging"
 	"github.com/alphagov/paas-cf-conduit/util"
 )
 
+var logger = logging.For("tls")
+
 type Tunnel struct {
 	localAddr      string
 	remoteAddr     string
 	actualAddr     string
-	listener       net.Listener
+	socketPath     string
+	protocol       string
+	serverConfig   *tls.Config
+	clientCreds    *ClientCredentials
+	listeners      []net.Listener
 	errorChan      chan error
 	tlsCipherSuite []uint16
 	tlsMinVersion  uint16
 	insecure       bool
+	onHandshake    func(HandshakeStats)
 }
 
 func NewTunnel(localAddr, remoteAddr, actualAddr string, insecure bool, tlsCipherSuite []uint16, tlsMinVersion uint16) *Tunnel {
@@ -29,6 +36,7 @@ func NewTunnel(localAddr, remoteAddr, actualAddr string, insecure bool, tlsCiphe
 		localAddr:      localAddr,
 		remoteAddr:     remoteAddr,
 		actualAddr:     actualAddr,
+		protocol:       ProtocolTLS,
 		errorChan:      make(chan error, 8),
 		tlsCipherSuite: tlsCipherSuite,
 		tlsMinVersion:  tlsMinVersion,
@@ -36,29 +44,84 @@ func NewTunnel(localAddr, remoteAddr, actualAddr string, insecure bool, tlsCiphe
 	}
 }
 
+// SetSocketPath makes the tunnel listen on a Unix domain socket as well as
+// its local address
+func (t *Tunnel) SetSocketPath(path string) {
+	t.socketPath = path
+}
+
+// SetProtocol sets how TLS is started with the service and the tunnel's
+// clients, ProtocolTLS by default. Postgres clients of a tunnel which isn't
+// serving TLS are told the tunnel doesn't support it.
+func (t *Tunnel) SetProtocol(protocol string) {
+	t.protocol = protocol
+}
+
+// SetServerConfig makes the tunnel serve TLS to its clients with config
+func (t *Tunnel) SetServerConfig(config *tls.Config) {
+	t.serverConfig = config
+}
+
+// SetClientCredentials sets the CAs the service is verified with, and the
+// certificate the tunnel authenticates to it with
+func (t *Tunnel) SetClientCredentials(creds *ClientCredentials) {
+	t.clientCreds = creds
+}
+
+// SetHandshakeFunc sets a function which is called with the stats of every
+// handshake with the service, including those which failed
+func (t *Tunnel) SetHandshakeFunc(fn func(HandshakeStats)) {
+	t.onHandshake = fn
+}
+
+func (t *Tunnel) recordHandshake(stats HandshakeStats) {
+	if t.onHandshake != nil {
+		t.onHandshake(stats)
+	}
+}
+
 func (t *Tunnel) Start() (chan error, error) {
-	logging.Debug("starting TLS tunnel at", t.localAddr, "to", t.remoteAddr)
-	var err error
-	t.listener, err = net.Listen("tcp", t.localAddr)
+	logger.Debug("starting TLS tunnel at", t.localAddr, "to", t.remoteAddr)
+	listener, err := net.Listen("tcp", t.localAddr)
 	if err != nil {
 		return nil, fmt.Errorf("starting a TLS tunnel failed: %s", err.Error())
 	}
-	go t.run()
+	t.listeners = append(t.listeners, listener)
+	if t.socketPath != "" {
+		listener, err := net.Listen("unix", t.socketPath)
+		if err != nil {
+			t.Stop()
+			return nil, fmt.Errorf("starting a TLS tunnel failed: %s", err.Error())
+		}
+		t.listeners = append(t.listeners, listener)
+	}
+	for _, listener := range t.listeners {
+		go t.run(listener)
+	}
 	return t.errorChan, nil
 }
 
 func (t *Tunnel) Stop() error {
-	return t.listener.Close()
+	var err error
+	for _, listener := range t.listeners {
+		if closeErr := listener.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
-func (t *Tunnel) run() {
+func (t *Tunnel) run(listener net.Listener) {
 	for {
-		conn, err := t.listener.Accept()
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			t.errorChan <- fmt.Errorf("error accepting TLS connection: %s", err)
 			continue
 		}
-		logging.Debug("accepted TLS connection to", t.localAddr, "from", conn.RemoteAddr())
+		logger.Debug("accepted TLS connection to", t.localAddr, "from", conn.RemoteAddr())
 		go func() {
 			err := t.handleRequest(conn)
 			if err != nil {
@@ -69,36 +132,35 @@ func (t *Tunnel) run() {
 }
 
 func (t *Tunnel) handleRequest(conn net.Conn) error {
-	var tlsConfig *tls.Config
-
-	// This horrible hack is to work around an certificate validation issue on OXS
-	// See the comment in util.GetRootCAs for more details
-	// Hopefully this can be removed once we have a better solution
-
-	_, err := os.Stat("/etc/ssl/cert.pem")
-	if runtime.GOOS == "darwin" && err == nil && !t.insecure {
-		rootCAs, err := util.GetRootCAs(nil)
+	if t.serverConfig != nil || t.protocol == ProtocolPostgres {
+		serverConn, err := ServerConn(conn, t.serverConfig, t.protocol)
+		if err == io.EOF {
+			// e.g. checking the tunnel is listening
+			conn.Close()
+			return nil
+		}
 		if err != nil {
+			conn.Close()
 			return err
 		}
-		tlsConfig = &tls.Config{
-			ServerName:   strings.Split(t.actualAddr, ":")[0],
-			RootCAs:      rootCAs,
-			CipherSuites: t.tlsCipherSuite,
-			MinVersion:   t.tlsMinVersion,
-		}
-	} else {
-		tlsConfig = &tls.Config{
-			ServerName:         strings.Split(t.actualAddr, ":")[0],
-			InsecureSkipVerify: t.insecure,
-			CipherSuites:       t.tlsCipherSuite,
-			MinVersion:         t.tlsMinVersion,
-		}
+		conn = serverConn
 	}
 
-	rconn, err := tls.Dial("tcp", t.remoteAddr, tlsConfig)
+	tlsConfig, err := ClientConfig(ServerName(t.actualAddr), t.insecure, t.tlsCipherSuite, t.tlsMinVersion)
 	if err != nil {
-		return fmt.Errorf("failed to connect to %s: %s", t.remoteAddr, "err")
+		conn.Close()
+		return err
+	}
+	t.clientCreds.Apply(tlsConfig)
+
+	rconn, err := t.dial(tlsConfig, conn.RemoteAddr().String())
+	if err != nil {
+		conn.Close()
+		var handshakeErr *HandshakeError
+		if errors.As(err, &handshakeErr) {
+			return err
+		}
+		return fmt.Errorf("failed to connect to %s: %s", t.actualAddr, err)
 	}
 
 	go t.forward(conn, rconn)
@@ -107,9 +169,66 @@ func (t *Tunnel) handleRequest(conn net.Conn) error {
 	return nil
 }
 
+// dial connects to the service and starts TLS with it
+func (t *Tunnel) dial(config *tls.Config, client string) (net.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	switch t.protocol {
+	case ProtocolPostgres:
+		conn, err = dialPostgres(t.remoteAddr)
+	default:
+		conn, err = net.Dial("tcp", t.remoteAddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tlsConn, stats, err := handshake(conn, t.actualAddr, config, client)
+	t.recordHandshake(stats)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// ClientConfig returns the TLS config used to connect to a service at
+// serverName
+func ClientConfig(serverName string, insecure bool, tlsCipherSuite []uint16, tlsMinVersion uint16) (*tls.Config, error) {
+	// This horrible hack is to work around an certificate validation issue on OXS
+	// See the comment in util.GetRootCAs for more details
+	// Hopefully this can be removed once we have a better solution
+
+	_, err := os.Stat("/etc/ssl/cert.pem")
+	if runtime.GOOS == "darwin" && err == nil && !insecure {
+		rootCAs, err := util.GetRootCAs(nil)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{
+			ServerName:   serverName,
+			RootCAs:      rootCAs,
+			CipherSuites: tlsCipherSuite,
+			MinVersion:   tlsMinVersion,
+			KeyLogWriter: KeyLogWriter(),
+		}, nil
+	}
+
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecure,
+		CipherSuites:       tlsCipherSuite,
+		MinVersion:         tlsMinVersion,
+		KeyLogWriter:       KeyLogWriter(),
+	}, nil
+}
+
 func (t *Tunnel) forward(dst, src net.Conn) {
+	// the other direction stops once either side has gone
+	defer dst.Close()
 	_, err := io.Copy(dst, src)
-	if err != nil && err != io.EOF {
+	if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
 		t.errorChan <- fmt.Errorf("failed to send data: %s", err)
 	}
 }
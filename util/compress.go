@@ -0,0 +1,80 @@
+package util
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions lists the compression algorithms understood by NewCompressor
+// and NewDecompressor
+var Compressions = []string{"none", "gzip", "zstd"}
+
+// CompressionForPath picks a compression algorithm from a file's extension
+func CompressionForPath(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return "gzip"
+	case strings.HasSuffix(path, ".zst"):
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// ValidCompression checks that compression is one of Compressions
+func ValidCompression(compression string) error {
+	for _, c := range Compressions {
+		if c == compression {
+			return nil
+		}
+	}
+	return unknownCompression(compression)
+}
+
+// NewCompressor returns a writer which compresses what's written to it into
+// w. Closing it flushes the compressed stream but doesn't close w.
+func NewCompressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "none":
+		return nopWriteCloser{w}, nil
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	default:
+		return nil, unknownCompression(compression)
+	}
+}
+
+// NewDecompressor returns a reader which decompresses what's read from r.
+// Closing it doesn't close r.
+func NewDecompressor(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "none":
+		return io.NopCloser(r), nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, unknownCompression(compression)
+	}
+}
+
+func unknownCompression(compression string) error {
+	return fmt.Errorf("unknown compression '%s', expected one of: %s", compression, strings.Join(Compressions, ", "))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,64 @@
+package util_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+var _ = Describe("Compression", func() {
+	DescribeTable("picks the compression from the file extension",
+		func(path string, expected string) {
+			Expect(util.CompressionForPath(path)).To(Equal(expected))
+		},
+		Entry("gzip", "backup.dump.gz", "gzip"),
+		Entry("zstd", "backup.dump.zst", "zstd"),
+		Entry("anything else", "backup.dump", "none"),
+	)
+
+	DescribeTable("round trips data",
+		func(compression string) {
+			data := strings.Repeat("some data to compress\n", 1000)
+
+			buf := &bytes.Buffer{}
+			w, err := util.NewCompressor(buf, compression)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.WriteString(w, data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Close()).To(Succeed())
+
+			if compression != "none" {
+				Expect(buf.Len()).To(BeNumerically("<", len(data)))
+			}
+
+			r, err := util.NewDecompressor(buf, compression)
+			Expect(err).NotTo(HaveOccurred())
+			decompressed, err := io.ReadAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Close()).To(Succeed())
+			Expect(string(decompressed)).To(Equal(data))
+		},
+		Entry("none", "none"),
+		Entry("gzip", "gzip"),
+		Entry("zstd", "zstd"),
+	)
+
+	It("rejects unknown compressions", func() {
+		_, err := util.NewCompressor(&bytes.Buffer{}, "bzip2")
+		Expect(err).To(MatchError("unknown compression 'bzip2', expected one of: none, gzip, zstd"))
+		_, err = util.NewDecompressor(&bytes.Buffer{}, "bzip2")
+		Expect(err).To(MatchError("unknown compression 'bzip2', expected one of: none, gzip, zstd"))
+		Expect(util.ValidCompression("bzip2")).To(MatchError("unknown compression 'bzip2', expected one of: none, gzip, zstd"))
+	})
+
+	It("accepts the known compressions", func() {
+		for _, compression := range util.Compressions {
+			Expect(util.ValidCompression(compression)).To(Succeed())
+		}
+	})
+})
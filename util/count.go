@@ -0,0 +1,18 @@
+package util
+
+import "sync/atomic"
+
+// CountingWriter counts the bytes written to it, e.g. to report progress
+type CountingWriter struct {
+	n int64
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.n, int64(len(p)))
+	return len(p), nil
+}
+
+// Count returns the number of bytes written so far
+func (c *CountingWriter) Count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
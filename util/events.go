@@ -0,0 +1,80 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventStatus is a StatusBackend which writes a newline-delimited JSON
+// object for every progress message and lifecycle event, for tools which
+// wrap conduit
+type EventStatus struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func NewEventStatus(w io.Writer) *EventStatus {
+	return &EventStatus{w: w}
+}
+
+func (e *EventStatus) Text(msg string) {
+	e.Event("status", Fields{"message": msg})
+}
+
+func (e *EventStatus) Done() {}
+
+func (e *EventStatus) Event(name string, fields Fields) {
+	event := map[string]interface{}{}
+	for k, v := range fields {
+		event[k] = v
+	}
+	event["event"] = name
+	event["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		b, _ = json.Marshal(map[string]interface{}{
+			"event": name,
+			"error": fmt.Sprintf("failed to encode event: %s", err),
+		})
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(append(b, '\n'))
+}
+
+// OpenEventSink opens the destination given to --events, which is either
+// a file path or an inherited file descriptor given as "fd:N"
+func OpenEventSink(spec string) (io.WriteCloser, error) {
+	if strings.HasPrefix(spec, "fd:") {
+		fd, err := strconv.Atoi(strings.TrimPrefix(spec, "fd:"))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid events file descriptor: %s", spec)
+		}
+		f := os.NewFile(uintptr(fd), spec)
+		if fd <= 2 {
+			// don't close stdin, stdout or stderr when we're done
+			return nopCloser{f}, nil
+		}
+		return f, nil
+	}
+
+	f, err := os.OpenFile(spec, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open events file: %s", err)
+	}
+	return f, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
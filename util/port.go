@@ -1,13 +1,17 @@
 package util
 
 import (
-	"fmt"
 	"net"
 	"strconv"
 )
 
 func PortIsInUse(port int) bool {
-	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	return PortIsInUseOn("127.0.0.1", port)
+}
+
+// PortIsInUseOn returns whether the port can't be listened on at host
+func PortIsInUseOn(host string, port int) bool {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
 
 	if listener != nil {
 		listener.Close()
@@ -22,7 +26,12 @@ func PortIsInUse(port int) bool {
 }
 
 func GetRandomPort() (int, error) {
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	return GetRandomPortOn("127.0.0.1")
+}
+
+// GetRandomPortOn returns a port chosen by the OS which is free at host
+func GetRandomPortOn(host string) (int, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
 
 	if err != nil {
 		return 0, err
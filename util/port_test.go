@@ -43,3 +43,17 @@ var _ = Describe("PortIsInUse", func() {
 		})
 	})
 })
+
+var _ = Describe("PortIsInUseOn", func() {
+	It("checks the port on the given host", func() {
+		port, err := util.GetRandomPortOn("127.0.0.1")
+		Expect(err).NotTo(HaveOccurred())
+
+		listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+
+		Expect(util.PortIsInUseOn("127.0.0.1", port)).To(BeTrue())
+		Expect(util.PortIsInUseOn("0.0.0.0", port)).To(BeTrue())
+	})
+})
@@ -0,0 +1,53 @@
+package util_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+var _ = Describe("SplitCommandLine", func() {
+	DescribeTable("splits command lines into words",
+		func(line string, expected []string) {
+			words, err := util.SplitCommandLine(line)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(words).To(Equal(expected))
+		},
+		Entry("empty line", "", []string{}),
+		Entry("whitespace", " \t ", []string{}),
+		Entry("plain words", "pg_dump  -Fc\tmydb", []string{"pg_dump", "-Fc", "mydb"}),
+		Entry("single quotes", `psql -c 'select 1, 2'`, []string{"psql", "-c", "select 1, 2"}),
+		Entry("backslashes in single quotes", `echo 'a\b'`, []string{"echo", `a\b`}),
+		Entry("double quotes", `psql -c "select 'a'"`, []string{"psql", "-c", "select 'a'"}),
+		Entry("escapes in double quotes", `echo "a \"b\" \$c \d"`, []string{"echo", `a "b" $c \d`}),
+		Entry("escaped spaces", `ls my\ dir`, []string{"ls", "my dir"}),
+		Entry("adjacent quoted parts", `echo a'b c'"d"`, []string{"echo", "ab cd"}),
+		Entry("empty quoted words", `echo '' ""`, []string{"echo", "", ""}),
+	)
+
+	It("fails on an unterminated single quote", func() {
+		_, err := util.SplitCommandLine(`psql -c 'select 1`)
+		Expect(err).To(MatchError(ContainSubstring("unterminated single quote")))
+	})
+
+	It("fails on an unterminated double quote", func() {
+		_, err := util.SplitCommandLine(`psql -c "select 1`)
+		Expect(err).To(MatchError(ContainSubstring("unterminated double quote")))
+	})
+})
+
+var _ = Describe("JoinCommandLine", func() {
+	It("only quotes words which need it", func() {
+		Expect(util.JoinCommandLine([]string{"-h", "127.0.0.1", "-a", "it's a secret", ""})).To(
+			Equal(`-h 127.0.0.1 -a 'it'\''s a secret' ''`),
+		)
+	})
+
+	It("can be split back into the same words", func() {
+		words := []string{"redis-cli", "-a", `p@ss "word" $HOME \ 'x'`, "", "a\tb"}
+		split, err := util.SplitCommandLine(util.JoinCommandLine(words))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(split).To(Equal(words))
+	})
+})
@@ -7,18 +7,66 @@ import (
 	"time"
 
 	"github.com/alphagov/paas-cf-conduit/logging"
-
 	"github.com/briandowns/spinner"
 	"github.com/fatih/color"
 )
 
+// Fields holds the details of a lifecycle event
+type Fields map[string]interface{}
+
+// StatusBackend displays progress messages and lifecycle events
+type StatusBackend interface {
+	Text(msg string)
+	Done()
+	Event(name string, fields Fields)
+}
+
+// Status sends progress messages and lifecycle events to each of its backends
 type Status struct {
+	backends []StatusBackend
+}
+
+// NewStatus returns a Status which shows a spinner, or logs progress
+// messages in verbose or non-interactive mode
+func NewStatus(w io.Writer, nonInteractive bool) *Status {
+	return &Status{
+		backends: []StatusBackend{newConsoleStatus(w, nonInteractive)},
+	}
+}
+
+// AddBackend sends all further messages and events to b as well
+func (s *Status) AddBackend(b StatusBackend) {
+	s.backends = append(s.backends, b)
+}
+
+func (s *Status) Text(args ...interface{}) {
+	msg := fmt.Sprintln(args...)
+	msg = msg[:len(msg)-1]
+	for _, b := range s.backends {
+		b.Text(msg)
+	}
+}
+
+func (s *Status) Done() {
+	for _, b := range s.backends {
+		b.Done()
+	}
+}
+
+// Event records that a lifecycle step (e.g. app_created) has happened
+func (s *Status) Event(name string, fields Fields) {
+	for _, b := range s.backends {
+		b.Event(name, fields)
+	}
+}
+
+type consoleStatus struct {
 	spin           *spinner.Spinner
 	nonInteractive bool
 }
 
-func NewStatus(w io.Writer, nonInteractive bool) *Status {
-	s := &Status{
+func newConsoleStatus(w io.Writer, nonInteractive bool) *consoleStatus {
+	s := &consoleStatus{
 		spin:           spinner.New(spinner.CharSets[14], 250*time.Millisecond),
 		nonInteractive: nonInteractive,
 	}
@@ -28,12 +76,10 @@ func NewStatus(w io.Writer, nonInteractive bool) *Status {
 	return s
 }
 
-func (s *Status) Text(args ...interface{}) {
+func (s *consoleStatus) Text(msg string) {
 	if s.spin.Suffix != "" {
 		s.Done()
 	}
-	msg := fmt.Sprintln(args...)
-	msg = msg[:len(msg)-1]
 	if logging.Verbose || s.nonInteractive {
 		logging.Debug(msg)
 	} else {
@@ -42,11 +88,12 @@ func (s *Status) Text(args ...interface{}) {
 	}
 }
 
-func (s *Status) Done() {
+func (s *consoleStatus) Done() {
 	if s.spin.Suffix != "" {
 		s.spin.FinalMSG = color.GreenString("OK") + s.spin.Suffix + "\n"
 	}
 	s.spin.Stop()
 	s.spin.Suffix = ""
-
 }
+
+func (s *consoleStatus) Event(name string, fields Fields) {}
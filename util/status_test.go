@@ -0,0 +1,74 @@
+package util_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+var _ = Describe("Status", func() {
+	var (
+		buf    *bytes.Buffer
+		status *util.Status
+	)
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		status = util.NewStatus(GinkgoWriter, true)
+		status.AddBackend(util.NewEventStatus(buf))
+	})
+
+	readEvents := func() []map[string]interface{} {
+		events := []map[string]interface{}{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			event := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(line), &event)).To(Succeed())
+			events = append(events, event)
+		}
+		return events
+	}
+
+	It("writes a status event for every progress message", func() {
+		status.Text("Binding", "my-db")
+		status.Done()
+
+		events := readEvents()
+		Expect(events).To(HaveLen(1))
+		Expect(events[0]).To(HaveKeyWithValue("event", "status"))
+		Expect(events[0]).To(HaveKeyWithValue("message", "Binding my-db"))
+		Expect(events[0]).To(HaveKey("time"))
+	})
+
+	It("writes lifecycle events with their fields", func() {
+		status.Event("app_created", util.Fields{"app": "__conduit_abc__", "app_guid": "aaaa"})
+		status.Event("command_exited", util.Fields{"exit_code": 3})
+
+		events := readEvents()
+		Expect(events).To(HaveLen(2))
+		Expect(events[0]).To(HaveKeyWithValue("event", "app_created"))
+		Expect(events[0]).To(HaveKeyWithValue("app", "__conduit_abc__"))
+		Expect(events[0]).To(HaveKeyWithValue("app_guid", "aaaa"))
+		Expect(events[1]).To(HaveKeyWithValue("event", "command_exited"))
+		Expect(events[1]).To(HaveKeyWithValue("exit_code", BeNumerically("==", 3)))
+	})
+})
+
+var _ = Describe("OpenEventSink", func() {
+	It("rejects invalid file descriptors", func() {
+		_, err := util.OpenEventSink("fd:three")
+		Expect(err).To(MatchError(ContainSubstring("invalid events file descriptor")))
+	})
+
+	It("creates files", func() {
+		path := GinkgoT().TempDir() + "/events.ndjson"
+		sink, err := util.OpenEventSink(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sink.Close()).To(Succeed())
+		Expect(path).To(BeARegularFile())
+	})
+})
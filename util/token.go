@@ -0,0 +1,49 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenClaims are the claims conduit uses from a UAA access token
+type TokenClaims struct {
+	UserID   string    `json:"user_id"`
+	UserName string    `json:"user_name"`
+	Email    string    `json:"email"`
+	ClientID string    `json:"client_id"`
+	Expiry   time.Time `json:"-"`
+}
+
+// ParseToken reads the claims from a UAA access token (with or without the
+// "bearer " prefix). The signature isn't checked, the API does that.
+func ParseToken(token string) (*TokenClaims, error) {
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = token[7:]
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("access token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode access token: %s", err)
+	}
+
+	var claims struct {
+		TokenClaims
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to decode access token: %s", err)
+	}
+	if claims.Exp != 0 {
+		claims.Expiry = time.Unix(claims.Exp, 0)
+	}
+	return &claims.TokenClaims, nil
+}
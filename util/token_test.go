@@ -0,0 +1,44 @@
+package util_test
+
+import (
+	"encoding/base64"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-cf-conduit/util"
+)
+
+var _ = Describe("ParseToken", func() {
+	jwt := func(payload string) string {
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+	}
+
+	It("reads the claims from a bearer token", func() {
+		claims, err := util.ParseToken("bearer " + jwt(`{"user_id":"abc-123","user_name":"jo@example.com","email":"jo@example.com","client_id":"cf","exp":1700000000}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claims.UserID).To(Equal("abc-123"))
+		Expect(claims.UserName).To(Equal("jo@example.com"))
+		Expect(claims.Email).To(Equal("jo@example.com"))
+		Expect(claims.ClientID).To(Equal("cf"))
+		Expect(claims.Expiry).To(Equal(time.Unix(1700000000, 0)))
+	})
+
+	It("accepts a token without the bearer prefix", func() {
+		claims, err := util.ParseToken(jwt(`{"user_name":"admin"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claims.UserName).To(Equal("admin"))
+		Expect(claims.Expiry.IsZero()).To(BeTrue())
+	})
+
+	It("errors if the token isn't a JWT", func() {
+		_, err := util.ParseToken("bearer not-a-jwt")
+		Expect(err).To(MatchError("access token is not a JWT"))
+	})
+
+	It("errors if the claims can't be decoded", func() {
+		_, err := util.ParseToken("a.!!!.c")
+		Expect(err).To(MatchError(ContainSubstring("failed to decode access token")))
+	})
+})